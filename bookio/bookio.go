@@ -0,0 +1,111 @@
+// Package bookio reads and writes catalog records in the file formats other
+// library systems exchange: CSV with a header row, JSON Lines and MARC21 in
+// ISO 2709 transmission format. It only decodes and encodes; validating the
+// records against the catalog is left to the caller.
+package bookio
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/library/models"
+)
+
+const (
+	CSV       = "csv"
+	JSONLines = "jsonl"
+	MARC      = "marc"
+)
+
+var ErrUnknownFormat = errors.New("format must be one of csv, jsonl or marc")
+
+type format struct {
+	contentType string
+	extension   string
+	newReader   func(io.Reader) Reader
+	newWriter   func(io.Writer) Writer
+}
+
+var formats = map[string]format{
+	CSV:       {"text/csv; charset=utf-8", ".csv", newCSVReader, newCSVWriter},
+	JSONLines: {"application/x-ndjson", ".jsonl", newJSONLinesReader, newJSONLinesWriter},
+	MARC:      {"application/marc", ".mrc", newMARCReader, newMARCWriter},
+}
+
+// Reader decodes one record per call to Read. Read returns io.EOF after the
+// last record and a *RecordError for a record that could not be decoded, after
+// which the next call moves on to the following record. Any other error means
+// the rest of the input cannot be read.
+type Reader interface {
+	Read() (*models.Book, error)
+}
+
+// Writer encodes books one at a time. Flush must be called once the last book
+// has been written.
+type Writer interface {
+	Write(*models.Book) error
+	Flush() error
+}
+
+// RecordError is a single record that could not be decoded.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func NewReader(name string, r io.Reader) (Reader, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	return f.newReader(r), nil
+}
+
+func NewWriter(name string, w io.Writer) (Writer, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	return f.newWriter(w), nil
+}
+
+// ContentType is the media type served for a format.
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// Extension is the file name extension, with the dot, used for a format.
+func Extension(name string) string {
+	return formats[name].extension
+}
+
+// FormatOf guesses the format of a file from its extension and returns an
+// empty string when it cannot tell.
+func FormatOf(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return CSV
+	case ".jsonl", ".ndjson":
+		return JSONLines
+	case ".mrc", ".marc":
+		return MARC
+	}
+	return ""
+}
+
+// splitNames breaks the comma-separated author and category strings of a book
+// into single names.
+func splitNames(names string) []string {
+	var split []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			split = append(split, name)
+		}
+	}
+	return split
+}
@@ -0,0 +1,131 @@
+package bookio
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/library/models"
+)
+
+var books = []models.Book{
+	{
+		BaseModel: models.BaseModel{ID: 7},
+		ISBN:      "9780306406157",
+		Name:      "Pride and Prejudice",
+		Author:    "Jane Austen",
+		Year:      "1813",
+		Edition:   2,
+		Stock:     3,
+		Category:  "Fiction, Classics",
+		Abstract:  "Five sisters, one estate and \"a truth universally acknowledged\".",
+		Cover:     "covers/7.jpg",
+	},
+	{
+		Name:     "Écrits, tome 1",
+		Author:   "Jacques Lacan, Bruce Fink",
+		Year:     "1966",
+		Category: "Psychoanalysis",
+		Abstract: "Line one,\nline two; ünïcödé and a comma.",
+	},
+	{Name: "Untitled"},
+}
+
+// exchanged keeps the fields every format carries; ids and the database
+// bookkeeping do not survive an import.
+func exchanged(book models.Book) models.Book {
+	return models.Book{
+		ISBN:     book.ISBN,
+		Name:     book.Name,
+		Author:   book.Author,
+		Year:     book.Year,
+		Edition:  book.Edition,
+		Stock:    book.Stock,
+		Category: book.Category,
+		Abstract: book.Abstract,
+		Cover:    book.Cover,
+	}
+}
+
+func write(t *testing.T, format string, books []models.Book) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range books {
+		if err := w.Write(&books[i]); err != nil {
+			t.Fatalf("Write(%q): %v", books[i].Name, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAll returns the decoded books and the record errors, failing the test on
+// any error that ends the input early.
+func readAll(t *testing.T, format string, data []byte) ([]models.Book, []error) {
+	t.Helper()
+	r, err := NewReader(format, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read []models.Book
+	var recordErrors []error
+	for {
+		book, err := r.Read()
+		if err == io.EOF {
+			return read, recordErrors
+		}
+		if _, ok := err.(*RecordError); ok {
+			recordErrors = append(recordErrors, err)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		read = append(read, exchanged(*book))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := []models.Book{}
+	for _, book := range books {
+		want = append(want, exchanged(book))
+	}
+	for _, format := range []string{CSV, JSONLines, MARC} {
+		t.Run(format, func(t *testing.T) {
+			got, errs := readAll(t, format, write(t, format, books))
+			if len(errs) > 0 {
+				t.Fatalf("record errors: %v", errs)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read back\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	for _, format := range []string{CSV, JSONLines, MARC} {
+		t.Run(format, func(t *testing.T) {
+			got, errs := readAll(t, format, write(t, format, nil))
+			if len(got) != 0 || len(errs) != 0 {
+				t.Errorf("read back %v, %v from an empty export", got, errs)
+			}
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewReader("xml", nil); err != ErrUnknownFormat {
+		t.Errorf("NewReader = %v, want %v", err, ErrUnknownFormat)
+	}
+	if _, err := NewWriter("xml", nil); err != ErrUnknownFormat {
+		t.Errorf("NewWriter = %v, want %v", err, ErrUnknownFormat)
+	}
+}
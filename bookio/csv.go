@@ -0,0 +1,151 @@
+package bookio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/library/models"
+)
+
+// csvColumns is the header written on export. The id column is informational;
+// on import a book always gets a new id or keeps the one matched by ISBN.
+var csvColumns = []string{"id", "isbn", "name", "author", "year", "edition", "stock", "category", "abstract", "cover"}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) Reader {
+	reader := csv.NewReader(r)
+	// short rows are reported per record instead of failing the whole file
+	reader.FieldsPerRecord = -1
+	return &csvReader{r: reader}
+}
+
+func (r *csvReader) Read() (*models.Book, error) {
+	if r.columns == nil {
+		err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+	}
+	record, err := r.r.Read()
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, &RecordError{Err: err}
+		}
+		return nil, err
+	}
+	book := &models.Book{
+		ISBN:     r.field(record, "isbn"),
+		Name:     r.field(record, "name"),
+		Author:   r.field(record, "author"),
+		Year:     r.field(record, "year"),
+		Category: r.field(record, "category"),
+		Abstract: r.field(record, "abstract"),
+		Cover:    r.field(record, "cover"),
+	}
+	book.Edition, err = r.number(record, "edition")
+	if err != nil {
+		return nil, &RecordError{Err: err}
+	}
+	book.Stock, err = r.number(record, "stock")
+	if err != nil {
+		return nil, &RecordError{Err: err}
+	}
+	return book, nil
+}
+
+// readHeader maps the column names of the first row, in any order and case,
+// to their positions. Unknown columns are ignored.
+func (r *csvReader) readHeader() error {
+	header, err := r.r.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("csv header: %v", err)
+	}
+	r.columns = map[string]int{}
+	for i, column := range header {
+		if i == 0 {
+			// spreadsheets like to start the file with a byte order mark
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		r.columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := r.columns["name"]; !ok {
+		return errors.New("csv header must have a name column")
+	}
+	return nil
+}
+
+func (r *csvReader) field(record []string, column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (r *csvReader) number(record []string, column string) (uint, error) {
+	value := r.field(record, column)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a whole number, got %q", column, value)
+	}
+	return uint(n), nil
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Write(book *models.Book) error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		err := w.w.Write(csvColumns)
+		if err != nil {
+			return err
+		}
+	}
+	return w.w.Write([]string{
+		strconv.FormatUint(uint64(book.ID), 10),
+		book.ISBN,
+		book.Name,
+		book.Author,
+		book.Year,
+		strconv.FormatUint(uint64(book.Edition), 10),
+		strconv.FormatUint(uint64(book.Stock), 10),
+		book.Category,
+		book.Abstract,
+		book.Cover,
+	})
+}
+
+// Flush writes the header even for an empty catalog so the file can be read
+// back.
+func (w *csvWriter) Flush() error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		err := w.w.Write(csvColumns)
+		if err != nil {
+			return err
+		}
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,51 @@
+package bookio
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVRecordErrors(t *testing.T) {
+	input := "name,edition,stock\n" +
+		"First,1,2\n" +
+		"Second,second,1\n" +
+		"Third,3,-1\n" +
+		"Fourth,4\"th,1\n" +
+		"Fifth,5,5\n"
+	got, errs := readAll(t, CSV, []byte(input))
+	names := []string{}
+	for _, book := range got {
+		names = append(names, book.Name)
+	}
+	if strings.Join(names, ",") != "First,Fifth" {
+		t.Errorf("read back %v, want First and Fifth", names)
+	}
+	want := []string{
+		`edition must be a whole number, got "second"`,
+		`stock must be a whole number, got "-1"`,
+		`bare " in non-quoted-field`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("record errors %v, want %d", errs, len(want))
+	}
+	for i := range want {
+		if !strings.Contains(errs[i].Error(), want[i]) {
+			t.Errorf("record error %q, want one containing %q", errs[i], want[i])
+		}
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	// columns come in any order and case, unknown and missing ones are fine
+	input := "\ufeffStock, NAME ,shelf\n4,Moby Dick,B2\n"
+	got, errs := readAll(t, CSV, []byte(input))
+	if len(errs) > 0 || len(got) != 1 || got[0].Name != "Moby Dick" || got[0].Stock != 4 {
+		t.Errorf("read back %+v, %v", got, errs)
+	}
+
+	r, _ := NewReader(CSV, strings.NewReader("title,author\nMoby Dick,Melville\n"))
+	if _, err := r.Read(); err == nil || err == io.EOF {
+		t.Errorf("Read without a name column = %v, want an error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package bookio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/library/models"
+)
+
+// JSON Lines holds one book per line, encoded like the books returned by the
+// API.
+type jsonLinesReader struct {
+	r *bufio.Reader
+}
+
+func newJSONLinesReader(r io.Reader) Reader {
+	return &jsonLinesReader{r: bufio.NewReader(r)}
+}
+
+func (r *jsonLinesReader) Read() (*models.Book, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		// blank lines, usually a trailing newline, are not records
+		if len(line) == 0 {
+			continue
+		}
+		book := &models.Book{}
+		err = json.Unmarshal(line, book)
+		if err != nil {
+			return nil, &RecordError{Err: err}
+		}
+		return book, nil
+	}
+}
+
+type jsonLinesWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLinesWriter(w io.Writer) Writer {
+	buffered := bufio.NewWriter(w)
+	return &jsonLinesWriter{w: buffered, enc: json.NewEncoder(buffered)}
+}
+
+func (w *jsonLinesWriter) Write(book *models.Book) error {
+	return w.enc.Encode(book)
+}
+
+func (w *jsonLinesWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,408 @@
+package bookio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/library/models"
+)
+
+// MARC21 records in ISO 2709: a 24 byte leader, a directory of 12 byte entries
+// and the variable fields. Books map to the bibliographic fields below; the
+// number of copies has no standard field and travels in the local field 999.
+//
+//	001    control number (book id, export only)
+//	020 $a ISBN
+//	100 $a first author, 700 $a further authors
+//	245 $a title, $b subtitle
+//	250 $a edition
+//	264 $c or 260 $c publication year
+//	520 $a abstract, split over repeated fields when it is long
+//	650 $a categories
+//	856 $u cover
+//	999 $s stock
+const (
+	leaderLength     = 24
+	entryLength      = 12
+	maxRecordLength  = 99999
+	maxFieldLength   = 9999
+	subfieldMark     = 0x1f
+	fieldTerminator  = 0x1e
+	recordTerminator = 0x1d
+)
+
+var (
+	ErrRecordTooLong = errors.New("marc record is longer than 99999 bytes")
+	ErrFieldTooLong  = errors.New("marc field is longer than 9999 bytes")
+)
+
+type marcField struct {
+	tag  string
+	data []byte
+}
+
+type marcReader struct {
+	r *bufio.Reader
+}
+
+func newMARCReader(r io.Reader) Reader {
+	return &marcReader{r: bufio.NewReader(r)}
+}
+
+func (r *marcReader) Read() (*models.Book, error) {
+	// records are sometimes separated by line breaks
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' {
+			err = r.r.UnreadByte()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	leader := make([]byte, leaderLength)
+	_, err := io.ReadFull(r.r, leader)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	// without a usable length there is no way to find the next record
+	length, err := strconv.Atoi(string(leader[:5]))
+	if err != nil || length <= leaderLength {
+		return nil, fmt.Errorf("marc record length %q is not valid", leader[:5])
+	}
+	record := make([]byte, length)
+	copy(record, leader)
+	_, err = io.ReadFull(r.r, record[leaderLength:])
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if record[length-1] != recordTerminator {
+		return nil, fmt.Errorf("marc record of length %d does not end with a record terminator", length)
+	}
+	fields, err := parseMARC(record)
+	if err != nil {
+		return nil, &RecordError{Err: err}
+	}
+	return marcBook(fields), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func parseMARC(record []byte) ([]marcField, error) {
+	// anything but Unicode is MARC-8, which only shares its ASCII range with UTF-8
+	if record[9] != 'a' && !isASCII(record) {
+		return nil, errors.New("marc record is MARC-8 encoded, only UTF-8 records are supported")
+	}
+	base, err := strconv.Atoi(string(record[12:17]))
+	if err != nil || base <= leaderLength || base > len(record) || record[base-1] != fieldTerminator {
+		return nil, fmt.Errorf("marc base address %q is not valid", record[12:17])
+	}
+	directory := record[leaderLength : base-1]
+	if len(directory)%entryLength != 0 {
+		return nil, errors.New("marc directory is truncated")
+	}
+	var fields []marcField
+	for i := 0; i < len(directory); i += entryLength {
+		entry := directory[i : i+entryLength]
+		fieldLength, err1 := strconv.Atoi(string(entry[3:7]))
+		start, err2 := strconv.Atoi(string(entry[7:12]))
+		end := base + start + fieldLength
+		if err1 != nil || err2 != nil || fieldLength == 0 || end > len(record)-1 {
+			return nil, fmt.Errorf("marc directory entry %q is not valid", entry)
+		}
+		fields = append(fields, marcField{
+			tag:  string(entry[:3]),
+			data: bytes.TrimSuffix(record[base+start:end], []byte{fieldTerminator}),
+		})
+	}
+	return fields, nil
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func marcBook(fields []marcField) *models.Book {
+	book := &models.Book{}
+	var authors, categories, abstract []string
+	for _, field := range fields {
+		switch field.tag {
+		case "020":
+			if book.ISBN == "" {
+				// qualifiers such as "(pbk.)" follow the number
+				if isbn := strings.Fields(subfield(field, 'a')); len(isbn) > 0 {
+					book.ISBN = isbn[0]
+				}
+			}
+		case "100", "700":
+			if name := personalName(field); name != "" {
+				authors = append(authors, name)
+			}
+		case "245":
+			book.Name = trimISBD(subfield(field, 'a'))
+			if subtitle := trimISBD(subfield(field, 'b')); subtitle != "" {
+				book.Name += ": " + subtitle
+			}
+		case "250":
+			edition, _ := strconv.ParseUint(leadingDigits(subfield(field, 'a')), 10, 32)
+			book.Edition = uint(edition)
+		case "260", "264":
+			if book.Year == "" {
+				book.Year = year(subfield(field, 'c'))
+			}
+		case "520":
+			if text := strings.TrimSpace(subfield(field, 'a')); text != "" {
+				abstract = append(abstract, text)
+			}
+		case "650":
+			if category := trimISBD(subfield(field, 'a')); category != "" {
+				categories = append(categories, category)
+			}
+		case "856":
+			book.Cover = strings.TrimSpace(subfield(field, 'u'))
+		case "999":
+			stock, _ := strconv.ParseUint(strings.TrimSpace(subfield(field, 's')), 10, 32)
+			book.Stock = uint(stock)
+		}
+	}
+	book.Author = strings.Join(authors, ", ")
+	book.Category = strings.Join(categories, ", ")
+	book.Abstract = strings.Join(abstract, " ")
+	return book
+}
+
+// subfield returns the first subfield with the given code of a data field.
+func subfield(field marcField, code byte) string {
+	if len(field.data) < 2 {
+		return ""
+	}
+	for _, sub := range bytes.Split(field.data[2:], []byte{subfieldMark}) {
+		if len(sub) > 0 && sub[0] == code {
+			return string(sub[1:])
+		}
+	}
+	return ""
+}
+
+// personalName turns an inverted heading such as "Austen, Jane," into "Jane
+// Austen" because the catalog keeps several authors in one comma-separated
+// string.
+func personalName(field marcField) string {
+	name := trimISBD(subfield(field, 'a'))
+	if len(field.data) > 0 && field.data[0] == '1' {
+		if i := strings.Index(name, ","); i >= 0 {
+			name = strings.TrimSpace(name[i+1:]) + " " + strings.TrimSpace(name[:i])
+		}
+	}
+	return strings.TrimSpace(name)
+}
+
+// trimISBD drops the punctuation cataloguers put at the end of a subfield to
+// separate it from the next one.
+func trimISBD(s string) string {
+	return strings.TrimRight(strings.TrimSpace(s), " /:;,.=")
+}
+
+func leadingDigits(s string) string {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// year picks the first four digit run out of a date such as "c2019." or
+// "[2004?]".
+func year(s string) string {
+	run := 0
+	for i, r := range s {
+		if r < '0' || r > '9' {
+			run = 0
+			continue
+		}
+		run++
+		if run == 4 {
+			return s[i-3 : i+1]
+		}
+	}
+	return ""
+}
+
+type marcWriter struct {
+	w *bufio.Writer
+}
+
+func newMARCWriter(w io.Writer) Writer {
+	return &marcWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *marcWriter) Write(book *models.Book) error {
+	fields := marcFields(book)
+	// the abstract is the only field that can outgrow the limits of the format,
+	// so it takes whatever room the other fields leave
+	fields = append(fields, abstractFields(book.Abstract, maxRecordLength-recordLength(fields))...)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+	record, err := encodeMARC(fields)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(record)
+	return err
+}
+
+func (w *marcWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func marcFields(book *models.Book) []marcField {
+	fields := []marcField{
+		{"001", []byte(strconv.FormatUint(uint64(book.ID), 10))},
+		{"008", fixedLengthData(book)},
+	}
+	if book.ISBN != "" {
+		fields = append(fields, dataField("020", "  ", 'a', book.ISBN))
+	}
+	for i, author := range splitNames(book.Author) {
+		tag := "700"
+		if i == 0 {
+			tag = "100"
+		}
+		// first indicator 0: the name is in direct order
+		fields = append(fields, dataField(tag, "0 ", 'a', author))
+	}
+	fields = append(fields, dataField("245", "00", 'a', book.Name))
+	if book.Edition > 0 {
+		fields = append(fields, dataField("250", "  ", 'a', strconv.FormatUint(uint64(book.Edition), 10)))
+	}
+	if book.Year != "" {
+		fields = append(fields, dataField("264", " 1", 'c', book.Year))
+	}
+	for _, category := range splitNames(book.Category) {
+		fields = append(fields, dataField("650", " 4", 'a', category))
+	}
+	if book.Cover != "" {
+		fields = append(fields, dataField("856", "40", 'u', book.Cover))
+	}
+	return append(fields, dataField("999", "  ", 's', strconv.FormatUint(uint64(book.Stock), 10)))
+}
+
+// fixedLengthData builds the 40 character 008 field with the date the record
+// was entered and the publication year; the rest is left undefined.
+func fixedLengthData(book *models.Book) []byte {
+	data := []byte(strings.Repeat(" ", 40))
+	if !book.CreatedAt.IsZero() {
+		copy(data[0:6], book.CreatedAt.Format("060102"))
+	}
+	if y := year(book.Year); y != "" {
+		data[6] = 's'
+		copy(data[7:11], y)
+	}
+	copy(data[35:38], "und")
+	data[39] = 'd'
+	return data
+}
+
+func dataField(tag, indicators string, code byte, value string) marcField {
+	data := []byte(indicators)
+	data = append(data, subfieldMark, code)
+	// the delimiters are not allowed inside the data
+	data = append(data, strings.Map(func(r rune) rune {
+		if r == subfieldMark || r == fieldTerminator || r == recordTerminator {
+			return ' '
+		}
+		return r
+	}, value)...)
+	return marcField{tag: tag, data: data}
+}
+
+func encodeMARC(fields []marcField) ([]byte, error) {
+	base := leaderLength + len(fields)*entryLength + 1
+	var directory, data bytes.Buffer
+	for _, field := range fields {
+		length := len(field.data) + 1
+		if length > maxFieldLength {
+			return nil, ErrFieldTooLong
+		}
+		fmt.Fprintf(&directory, "%s%04d%05d", field.tag, length, data.Len())
+		data.Write(field.data)
+		data.WriteByte(fieldTerminator)
+	}
+	directory.WriteByte(fieldTerminator)
+	length := base + data.Len() + 1
+	if length > maxRecordLength {
+		return nil, ErrRecordTooLong
+	}
+	record := make([]byte, 0, length)
+	// new record, language material, monograph, Unicode
+	record = append(record, fmt.Sprintf("%05dnam a22%05d   4500", length, base)...)
+	record = append(record, directory.Bytes()...)
+	record = append(record, data.Bytes()...)
+	record = append(record, recordTerminator)
+	return record, nil
+}
+
+// recordLength is the encoded size of a record made of fields.
+func recordLength(fields []marcField) int {
+	length := leaderLength + 2
+	for _, field := range fields {
+		length += entryLength + len(field.data) + 1
+	}
+	return length
+}
+
+// abstractFields splits text over as many 520 fields as it needs, breaking at
+// spaces, and drops whatever does not fit in budget bytes of record.
+func abstractFields(text string, budget int) []marcField {
+	// a 520 field spends a directory entry, the indicators, the subfield code
+	// and the terminator on top of its text
+	const overhead = entryLength + 5
+	var fields []marcField
+	text = strings.TrimSpace(text)
+	for text != "" {
+		room := budget - overhead
+		if room > maxFieldLength-5 {
+			room = maxFieldLength - 5
+		}
+		if room <= 0 {
+			break
+		}
+		cut := len(text)
+		if cut > room {
+			cut = room
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			if space := strings.LastIndexByte(text[:cut], ' '); space > 0 {
+				cut = space
+			}
+		}
+		field := dataField("520", "  ", 'a', text[:cut])
+		fields = append(fields, field)
+		budget -= entryLength + len(field.data) + 1
+		text = strings.TrimSpace(text[cut:])
+	}
+	return fields
+}
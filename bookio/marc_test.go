@@ -0,0 +1,151 @@
+package bookio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/library/models"
+)
+
+// rawMARC builds an ISO 2709 record around a hand-written directory so broken
+// records can be fed to the reader. encoding is leader position 9.
+func rawMARC(encoding byte, directory, data string) []byte {
+	base := leaderLength + len(directory) + 1
+	length := base + len(data) + 1
+	leader := fmt.Sprintf("%05dnam %c22%05d   4500", length, encoding, base)
+	return []byte(leader + directory + "\x1e" + data + "\x1d")
+}
+
+// directory lays out fields, given as tag and data pairs, and returns the
+// directory entries and the data with the field terminators.
+func directory(fields ...string) (string, string) {
+	var entries, data string
+	for i := 0; i < len(fields); i += 2 {
+		entries += fmt.Sprintf("%s%04d%05d", fields[i], len(fields[i+1])+1, len(data))
+		data += fields[i+1] + "\x1e"
+	}
+	return entries, data
+}
+
+func titleRecord(encoding byte, name string) []byte {
+	entries, data := directory("245", "00\x1fa"+name)
+	return rawMARC(encoding, entries, data)
+}
+
+func TestMARCLongAbstract(t *testing.T) {
+	book := books[0]
+	book.Abstract = strings.Repeat("A long abstract. ", 10000)
+	data := write(t, MARC, []models.Book{book})
+	if len(data) > maxRecordLength {
+		t.Fatalf("record is %d bytes, longer than %d", len(data), maxRecordLength)
+	}
+	got, errs := readAll(t, MARC, data)
+	if len(errs) > 0 || len(got) != 1 {
+		t.Fatalf("read back %d books and %v", len(got), errs)
+	}
+	abstract := got[0].Abstract
+	if len(abstract) < maxRecordLength*9/10 || !strings.HasPrefix(book.Abstract, abstract) {
+		t.Errorf("abstract of %d bytes is not a long prefix of the original", len(abstract))
+	}
+	got[0].Abstract = book.Abstract
+	if got[0] != exchanged(book) {
+		t.Errorf("read back %+v, want %+v", got[0], exchanged(book))
+	}
+}
+
+func TestMARCRecordTooLong(t *testing.T) {
+	// the abstract gives way, other fields that overflow the record cannot
+	categories := []string{}
+	for i := 0; i < 12; i++ {
+		categories = append(categories, fmt.Sprintf("%d%s", i, strings.Repeat("c", 9000)))
+	}
+	book := &models.Book{Name: "Overflow", Category: strings.Join(categories, ", ")}
+	w, _ := NewWriter(MARC, &bytes.Buffer{})
+	if err := w.Write(book); err != ErrRecordTooLong {
+		t.Errorf("Write = %v, want %v", err, ErrRecordTooLong)
+	}
+	book = &models.Book{Name: strings.Repeat("n", maxFieldLength)}
+	if err := w.Write(book); err != ErrFieldTooLong {
+		t.Errorf("Write = %v, want %v", err, ErrFieldTooLong)
+	}
+}
+
+func TestMARCBadRecords(t *testing.T) {
+	valid := titleRecord('a', "Valid")
+	entries, data := directory("245", "00\x1faShort")
+	tests := []struct {
+		name   string
+		record []byte
+		want   string
+	}{
+		{
+			name:   "truncated directory",
+			record: rawMARC('a', entries[:len(entries)-1], data),
+			want:   "marc directory is truncated",
+		},
+		{
+			name:   "directory entry past the end",
+			record: rawMARC('a', "245009900000", data),
+			want:   "is not valid",
+		},
+		{
+			name:   "MARC-8 encoded",
+			record: titleRecord(' ', "Caf\xe2e"),
+			want:   "MARC-8",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a bad record is skipped and the next one still read
+			data := append(append([]byte{}, test.record...), valid...)
+			got, errs := readAll(t, MARC, data)
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.want) {
+				t.Fatalf("record errors %v, want one containing %q", errs, test.want)
+			}
+			if len(got) != 1 || got[0].Name != "Valid" {
+				t.Errorf("read back %+v, want the valid record", got)
+			}
+		})
+	}
+}
+
+func TestMARC8ASCII(t *testing.T) {
+	// MARC-8 and UTF-8 agree on ASCII, so such records are read
+	got, errs := readAll(t, MARC, titleRecord(' ', "Plain"))
+	if len(errs) > 0 || len(got) != 1 || got[0].Name != "Plain" {
+		t.Errorf("read back %+v, %v", got, errs)
+	}
+}
+
+func TestMARCTruncatedInput(t *testing.T) {
+	record := titleRecord('a', "Valid")
+	r, _ := NewReader(MARC, bytes.NewReader(record[:len(record)-5]))
+	if _, err := r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Read = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	r, _ = NewReader(MARC, strings.NewReader("abcdenam a2200025   4500"))
+	if _, err := r.Read(); err == nil || err == io.EOF {
+		t.Errorf("Read of a bad leader = %v, want an error", err)
+	}
+}
+
+func TestMARCFields(t *testing.T) {
+	// records from other systems use inverted names and ISBD punctuation
+	entries, data := directory(
+		"100", "1 \x1faAusten, Jane,",
+		"245", "00\x1faPride and prejudice :\x1fba novel /",
+		"250", "  \x1fa2nd ed.",
+		"264", " 1\x1fcc1813.",
+	)
+	got, errs := readAll(t, MARC, rawMARC('a', entries, data))
+	if len(errs) > 0 || len(got) != 1 {
+		t.Fatalf("read back %+v, %v", got, errs)
+	}
+	want := models.Book{Name: "Pride and prejudice: a novel", Author: "Jane Austen", Year: "1813", Edition: 2}
+	if got[0] != want {
+		t.Errorf("read back %+v, want %+v", got[0], want)
+	}
+}
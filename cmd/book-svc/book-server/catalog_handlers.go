@@ -0,0 +1,488 @@
+package book_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+)
+
+func (srv *Server) addAuthor(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	author := &models.Author{}
+	err := json.NewDecoder(r.Body).Decode(author)
+	if err != nil {
+		handleError(w, ctx, srv, "add_author", err, http.StatusBadRequest)
+		return
+	}
+	created, err := srv.DB.CreateAuthor(*author)
+	if err != nil {
+		srv.handleCatalogError(w, r, "add_author", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(created)
+	if err != nil {
+		handleError(w, ctx, srv, "add_author", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getAuthors(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	authors, err := srv.DB.GetAuthors()
+	if err != nil {
+		handleError(w, ctx, srv, "get_authors", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(authors)
+	if err != nil {
+		handleError(w, ctx, srv, "get_authors", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getAuthorByID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_author_by_id", err, http.StatusBadRequest)
+		return
+	}
+	author, err := srv.DB.GetAuthorByID(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_author_by_id", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(author)
+	if err != nil {
+		handleError(w, ctx, srv, "get_author_by_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookAuthors(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_authors", err, http.StatusBadRequest)
+		return
+	}
+	authors, err := srv.DB.GetBookAuthors(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_book_authors", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(authors)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_authors", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBooksByAuthorID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_author_id", err, http.StatusBadRequest)
+		return
+	}
+	books, err := srv.DB.GetBooksByAuthorID(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_books_by_author_id", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(books)
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_author_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateAuthor(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_author", err, http.StatusBadRequest)
+		return
+	}
+	author := &models.Author{}
+	err = json.NewDecoder(r.Body).Decode(author)
+	if err != nil {
+		handleError(w, ctx, srv, "update_author", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.UpdateAuthor(uint(id), author.Name)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_author", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Author updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_author", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) deleteAuthor(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "delete_author", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.DeleteAuthor(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "delete_author", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Author deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_author", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateBookAuthors(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_authors", err, http.StatusBadRequest)
+		return
+	}
+	link := &models.LinkRequest{}
+	err = json.NewDecoder(r.Body).Decode(link)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_authors", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SetBookAuthors(uint(id), link.IDs)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_book_authors", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Book authors updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_authors", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) addCategory(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	category := &models.Category{}
+	err := json.NewDecoder(r.Body).Decode(category)
+	if err != nil {
+		handleError(w, ctx, srv, "add_category", err, http.StatusBadRequest)
+		return
+	}
+	created, err := srv.DB.CreateCategory(*category)
+	if err != nil {
+		srv.handleCatalogError(w, r, "add_category", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(created)
+	if err != nil {
+		handleError(w, ctx, srv, "add_category", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getCategories(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	categories, err := srv.DB.GetCategories()
+	if err != nil {
+		handleError(w, ctx, srv, "get_categories", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(categories)
+	if err != nil {
+		handleError(w, ctx, srv, "get_categories", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getCategoryByID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_category_by_id", err, http.StatusBadRequest)
+		return
+	}
+	category, err := srv.DB.GetCategoryByID(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_category_by_id", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(category)
+	if err != nil {
+		handleError(w, ctx, srv, "get_category_by_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBooksByCategory(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_category", err, http.StatusBadRequest)
+		return
+	}
+	books, err := srv.DB.GetBooksByCategory(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_books_by_category", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(books)
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_category", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateCategory(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_category", err, http.StatusBadRequest)
+		return
+	}
+	category := &models.Category{}
+	err = json.NewDecoder(r.Body).Decode(category)
+	if err != nil {
+		handleError(w, ctx, srv, "update_category", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.UpdateCategory(uint(id), category.Name, category.ParentID)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_category", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Category updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_category", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) deleteCategory(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "delete_category", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.DeleteCategory(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "delete_category", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Category deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_category", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateBookCategories(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_categories", err, http.StatusBadRequest)
+		return
+	}
+	link := &models.LinkRequest{}
+	err = json.NewDecoder(r.Body).Decode(link)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_categories", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SetBookCategories(uint(id), link.IDs)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_book_categories", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Book categories updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_categories", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) addPublisher(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	publisher := &models.Publisher{}
+	err := json.NewDecoder(r.Body).Decode(publisher)
+	if err != nil {
+		handleError(w, ctx, srv, "add_publisher", err, http.StatusBadRequest)
+		return
+	}
+	created, err := srv.DB.CreatePublisher(*publisher)
+	if err != nil {
+		srv.handleCatalogError(w, r, "add_publisher", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(created)
+	if err != nil {
+		handleError(w, ctx, srv, "add_publisher", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getPublishers(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	publishers, err := srv.DB.GetPublishers()
+	if err != nil {
+		handleError(w, ctx, srv, "get_publishers", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(publishers)
+	if err != nil {
+		handleError(w, ctx, srv, "get_publishers", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getPublisherByID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_publisher_by_id", err, http.StatusBadRequest)
+		return
+	}
+	publisher, err := srv.DB.GetPublisherByID(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_publisher_by_id", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(publisher)
+	if err != nil {
+		handleError(w, ctx, srv, "get_publisher_by_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookPublishers(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_publishers", err, http.StatusBadRequest)
+		return
+	}
+	publishers, err := srv.DB.GetBookPublishers(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_book_publishers", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(publishers)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_publishers", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBooksByPublisherID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_publisher_id", err, http.StatusBadRequest)
+		return
+	}
+	books, err := srv.DB.GetBooksByPublisherID(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "get_books_by_publisher_id", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(books)
+	if err != nil {
+		handleError(w, ctx, srv, "get_books_by_publisher_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updatePublisher(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_publisher", err, http.StatusBadRequest)
+		return
+	}
+	publisher := &models.Publisher{}
+	err = json.NewDecoder(r.Body).Decode(publisher)
+	if err != nil {
+		handleError(w, ctx, srv, "update_publisher", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.UpdatePublisher(uint(id), publisher.Name)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_publisher", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Publisher updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_publisher", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) deletePublisher(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "delete_publisher", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.DeletePublisher(uint(id))
+	if err != nil {
+		srv.handleCatalogError(w, r, "delete_publisher", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Publisher deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_publisher", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateBookPublishers(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_publishers", err, http.StatusBadRequest)
+		return
+	}
+	link := &models.LinkRequest{}
+	err = json.NewDecoder(r.Body).Decode(link)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_publishers", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SetBookPublishers(uint(id), link.IDs)
+	if err != nil {
+		srv.handleCatalogError(w, r, "update_book_publishers", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Book publishers updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_publishers", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) handleCatalogError(w *middleware.LogResponseWriter, r *http.Request, task string, err error) {
+	ctx := r.Context()
+	switch err {
+	case gorm.ErrRecordNotFound:
+		handleError(w, ctx, srv, task, errors.New("no record found"), http.StatusOK)
+	case data_store.ErrEmptyName, data_store.ErrDuplicateName, data_store.ErrCategoryCycle,
+		data_store.ErrCategoryHasChildren:
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+	default:
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+	}
+}
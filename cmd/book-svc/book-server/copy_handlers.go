@@ -0,0 +1,150 @@
+package book_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+)
+
+func (srv *Server) addBookCopy(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	bookCopy := &models.BookCopy{}
+	err := json.NewDecoder(r.Body).Decode(bookCopy)
+	if err != nil {
+		handleError(w, ctx, srv, "add_book_copy", err, http.StatusBadRequest)
+		return
+	}
+	if bookCopy.Barcode == "" {
+		handleError(w, ctx, srv, "add_book_copy", errors.New("barcode is required"), http.StatusBadRequest)
+		return
+	}
+	created, err := srv.DB.CreateBookCopy(*bookCopy)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "add_book_copy", errors.New("no such book found"), http.StatusBadRequest)
+		case data_store.ErrInvalidCopyStatus, data_store.ErrDuplicateBarcode:
+			handleError(w, ctx, srv, "add_book_copy", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "add_book_copy", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(created)
+	if err != nil {
+		handleError(w, ctx, srv, "add_book_copy", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookCopies(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_copies", err, http.StatusBadRequest)
+		return
+	}
+	copies, err := srv.DB.GetBookCopies(uint(bookID))
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_copies", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(copies)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_copies", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookCopyByID(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	copyID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_copy_by_id", err, http.StatusBadRequest)
+		return
+	}
+	bookCopy, err := srv.DB.GetBookCopyByID(uint(copyID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "get_book_copy_by_id", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "get_book_copy_by_id", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(bookCopy)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_copy_by_id", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateBookCopy(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	copyID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_copy", err, http.StatusBadRequest)
+		return
+	}
+	bookCopy := &models.BookCopy{}
+	err = json.NewDecoder(r.Body).Decode(bookCopy)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_copy", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.UpdateBookCopy(uint(copyID), bookCopy.Barcode, bookCopy.Condition, bookCopy.ShelfLocation,
+		bookCopy.AcquisitionDate, bookCopy.Status)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "update_book_copy", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrCopyInUse, data_store.ErrInvalidCopyStatus, data_store.ErrDuplicateBarcode:
+			handleError(w, ctx, srv, "update_book_copy", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "update_book_copy", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode("Book copy updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_copy", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) deleteBookCopy(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	copyID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "delete_book_copy", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.DeleteBookCopy(uint(copyID))
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "delete_book_copy", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrCopyInUse:
+			handleError(w, ctx, srv, "delete_book_copy", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "delete_book_copy", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode("Book copy deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_book_copy", err, http.StatusInternalServerError)
+	}
+}
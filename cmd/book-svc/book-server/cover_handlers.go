@@ -0,0 +1,267 @@
+package book_server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	"github.com/library/middleware"
+	"github.com/library/storage"
+	"github.com/library/thumbnail"
+	"github.com/sirupsen/logrus"
+)
+
+// coverTypes are the image formats accepted as covers, keyed by the content
+// type sniffed from the upload, with the extension used for the stored object.
+var coverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// multipartOverhead leaves room for the boundaries and part headers around
+// the image when capping the request size.
+const multipartOverhead = 64 << 10
+
+// coverCacheControl lets clients and proxies reuse a cover for an hour and
+// then revalidate it with the ETag, which changes whenever a new cover is
+// uploaded.
+const coverCacheControl = "public, max-age=3600"
+
+// uploadBookCover stores the "image" part of a multipart form as the cover of
+// a book, together with its thumbnails, and points Book.Cover at
+// getBookCover. The type is sniffed from the bytes; the one claimed by the
+// client is ignored. WebP covers are kept but cannot be resized.
+func (srv *Server) uploadBookCover(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusBadRequest)
+		return
+	}
+	_, err = srv.DB.GetBookByID(uint(bookID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "upload_book_cover", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, srv.Env.CoverMaxBytes+multipartOverhead)
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > srv.Env.CoverMaxBytes {
+		handleError(w, ctx, srv, "upload_book_cover",
+			fmt.Errorf("cover must not be larger than %d bytes", srv.Env.CoverMaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusBadRequest)
+		return
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	extension, ok := coverTypes[contentType]
+	if !ok {
+		handleError(w, ctx, srv, "upload_book_cover",
+			fmt.Errorf("cover must be a jpeg, png, gif or webp image, got %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.MultiReader(bytes.NewReader(head), file))
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusBadRequest)
+		return
+	}
+	var variants []thumbnail.Variant
+	if thumbnail.Supported(contentType) {
+		variants, err = thumbnail.Generate(data, contentType)
+		if err != nil {
+			handleError(w, ctx, srv, "upload_book_cover", fmt.Errorf("cover cannot be read: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, err := coverKey(uint(bookID), extension)
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.Store.Put(ctx, key, bytes.NewReader(data), contentType)
+	for _, variant := range variants {
+		if err != nil {
+			break
+		}
+		err = srv.Store.Put(ctx, variantKey(key, variant.Size), bytes.NewReader(variant.Data), variant.ContentType)
+	}
+	if err != nil {
+		srv.deleteCover(r, key)
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	oldKey, err := srv.DB.SetBookCover(uint(bookID), key, fmt.Sprintf("/get/book-cover/%d", bookID))
+	if err != nil {
+		srv.deleteCover(r, key)
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "upload_book_cover", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	if oldKey != "" {
+		srv.deleteCover(r, oldKey)
+	}
+	book, err := srv.DB.GetBookByID(uint(bookID))
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(book)
+	if err != nil {
+		handleError(w, ctx, srv, "upload_book_cover", err, http.StatusInternalServerError)
+	}
+}
+
+// getBookCover streams the cover of a book, or with ?size=small, medium or
+// large one of its thumbnails. A cover smaller than the size asked for, or one
+// that could not be resized, is served in full.
+func (srv *Server) getBookCover(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_cover", err, http.StatusBadRequest)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "original"
+	} else if _, ok := thumbnail.Lookup(size); !ok && size != "original" {
+		handleError(w, ctx, srv, "get_book_cover",
+			errors.New("size must be one of small, medium, large or original"), http.StatusBadRequest)
+		return
+	}
+	book, err := srv.DB.GetBookByID(uint(bookID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "get_book_cover", errors.New("no record found"), http.StatusNotFound)
+			return
+		}
+		handleError(w, ctx, srv, "get_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	if book.CoverKey == "" {
+		handleError(w, ctx, srv, "get_book_cover", errors.New("book has no uploaded cover"), http.StatusNotFound)
+		return
+	}
+	// keys are never reused, so the key and the size identify the bytes
+	etag := fmt.Sprintf(`"%s-%s"`, strings.TrimSuffix(path.Base(book.CoverKey), path.Ext(book.CoverKey)), size)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", coverCacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	key := book.CoverKey
+	if size != "original" {
+		key = variantKey(book.CoverKey, size)
+	}
+	body, info, err := srv.Store.Get(ctx, key)
+	if err == storage.ErrNotFound && key != book.CoverKey {
+		body, info, err = srv.Store.Get(ctx, book.CoverKey)
+	}
+	if err != nil {
+		if err == storage.ErrNotFound {
+			handleError(w, ctx, srv, "get_book_cover", err, http.StatusNotFound)
+			return
+		}
+		handleError(w, ctx, srv, "get_book_cover", err, http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", coverCacheControl)
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	_, err = io.Copy(w, body)
+	if err != nil {
+		// the image is partly sent, so the status can no longer change
+		logrus.WithFields(logrus.Fields{
+			"key":   book.CoverKey,
+			"error": err,
+		}).Error("get_book_cover")
+	}
+}
+
+// coverKey names a new cover object. Every upload gets a fresh key so a
+// replaced cover is never served from a stale cache.
+func coverKey(bookID uint, extension string) (string, error) {
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("covers/%d/%s%s", bookID, hex.EncodeToString(suffix), extension), nil
+}
+
+// variantKey names a thumbnail of the cover stored under key. Thumbnails of
+// JPEG covers are JPEG, the others PNG.
+func variantKey(key, size string) string {
+	extension := ".png"
+	if path.Ext(key) == ".jpg" {
+		extension = ".jpg"
+	}
+	return strings.TrimSuffix(key, path.Ext(key)) + "-" + size + extension
+}
+
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteCover removes a cover and its thumbnails once nothing refers to them.
+// A failure only leaves orphans behind, so it is logged rather than reported.
+func (srv *Server) deleteCover(r *http.Request, key string) {
+	keys := []string{key}
+	for _, size := range thumbnail.Sizes {
+		keys = append(keys, variantKey(key, size.Name))
+	}
+	for _, key := range keys {
+		err := srv.Store.Delete(r.Context(), key)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"key":   key,
+				"error": err,
+			}).Error("delete_book_cover")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package book_server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/library/bookio"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	"github.com/sirupsen/logrus"
+)
+
+// importBooks reads a catalog file, either as the request body or as the
+// "file" part of a multipart form, and reports the outcome of every row. The
+// format comes from the format parameter or else the file name extension.
+func (srv *Server) importBooks(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, srv.Env.ImportMaxBytes)
+	var (
+		body     io.Reader = r.Body
+		filename string
+	)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			handleError(w, ctx, srv, "import_books", err, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body, filename = file, header.Filename
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = bookio.FormatOf(filename)
+	}
+	reader, err := bookio.NewReader(format, body)
+	if err != nil {
+		handleError(w, ctx, srv, "import_books", err, http.StatusBadRequest)
+		return
+	}
+
+	var (
+		rows         []models.ImportRow
+		decodeErrors []models.ImportError
+	)
+	for row := 1; ; row++ {
+		book, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*bookio.RecordError); ok {
+			decodeErrors = append(decodeErrors, models.ImportError{Row: row, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			handleError(w, ctx, srv, "import_books", fmt.Errorf("row %d: %v", row, err), http.StatusBadRequest)
+			return
+		}
+		rows = append(rows, models.ImportRow{Row: row, Book: *book})
+	}
+
+	report := srv.DB.ImportBooks(rows, models.ImportOptions{
+		DryRun:    r.URL.Query().Get("dryRun") == "true",
+		Upsert:    r.URL.Query().Get("upsert") == "true",
+		BatchSize: srv.Env.ImportBatchSize,
+	})
+	report.Format = format
+	report.Rows = len(rows) + len(decodeErrors)
+	report.Failed += len(decodeErrors)
+	report.Errors = append(report.Errors, decodeErrors...)
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	logrus.WithFields(logrus.Fields{
+		"format":  report.Format,
+		"dryRun":  report.DryRun,
+		"rows":    report.Rows,
+		"created": report.Created,
+		"updated": report.Updated,
+		"failed":  report.Failed,
+	}).Info("books imported")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		handleError(w, ctx, srv, "import_books", err, http.StatusInternalServerError)
+	}
+}
+
+// exportBooks streams the whole catalog as a file download, CSV unless the
+// format parameter asks for jsonl or marc.
+func (srv *Server) exportBooks(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = bookio.CSV
+	}
+	writer, err := bookio.NewWriter(format, w)
+	if err != nil {
+		handleError(w, ctx, srv, "export_books", err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", bookio.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books%s"`, bookio.Extension(format)))
+	exported := 0
+	err = srv.DB.ExportBooks(func(book *models.Book) error {
+		exported++
+		return writer.Write(book)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil && exported == 0 {
+		w.Header().Del("Content-Disposition")
+		handleError(w, ctx, srv, "export_books", err, http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		// part of the file is already on its way, so the status can no longer change
+		logrus.WithFields(logrus.Fields{
+			"exported": exported,
+			"error":    err,
+		}).Error("export_books")
+	}
+}
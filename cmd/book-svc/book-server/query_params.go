@@ -0,0 +1,89 @@
+package book_server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/library/models"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parseBookQuery reads the catalog filters of GET /get/books from the query
+// string. Unknown parameters are ignored, malformed ones are rejected.
+func parseBookQuery(values url.Values) (*models.BookQuery, error) {
+	query := &models.BookQuery{
+		Author:   values.Get("author"),
+		Category: values.Get("category"),
+		ISBN:     values.Get("isbn"),
+		Sort:     values.Get("sort"),
+		Cursor:   values.Get("cursor"),
+		Limit:    defaultPageLimit,
+	}
+	var err error
+	if v := values.Get("edition"); v != "" {
+		edition, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid edition %q", v)
+		}
+		query.Edition = uint(edition)
+	}
+	if query.YearFrom, err = intParam(values, "yearFrom"); err != nil {
+		return nil, err
+	}
+	if query.YearTo, err = intParam(values, "yearTo"); err != nil {
+		return nil, err
+	}
+	if query.RatingMin, err = floatParam(values, "ratingMin"); err != nil {
+		return nil, err
+	}
+	if query.RatingMax, err = floatParam(values, "ratingMax"); err != nil {
+		return nil, err
+	}
+	if v := values.Get("available"); v != "" {
+		available, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid available %q", v)
+		}
+		query.Available = &available
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+func intParam(values url.Values, name string) (int, error) {
+	v := values.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+func floatParam(values url.Values, name string) (*float64, error) {
+	v := values.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return &f, nil
+}
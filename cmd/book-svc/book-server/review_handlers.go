@@ -0,0 +1,190 @@
+package book_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+)
+
+func (srv *Server) addReview(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "add_review", err, http.StatusBadRequest)
+		return
+	}
+	review := &models.Review{}
+	err = json.NewDecoder(r.Body).Decode(review)
+	if err != nil {
+		handleError(w, ctx, srv, "add_review", err, http.StatusBadRequest)
+		return
+	}
+	review.BookID = uint(bookID)
+	review.UserID, err = middleware.ActingUser(GetAuthInfoFromContext(ctx), review.UserID)
+	if err == middleware.ErrPermissionDenied {
+		handleError(w, ctx, srv, "add_review", err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		handleError(w, ctx, srv, "add_review", err, http.StatusInternalServerError)
+		return
+	}
+	created, err := srv.DB.CreateReview(*review)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "add_review", errors.New("no such book found"), http.StatusBadRequest)
+		case data_store.ErrInvalidRating, data_store.ErrAlreadyReviewed:
+			handleError(w, ctx, srv, "add_review", err, http.StatusBadRequest)
+		case data_store.ErrReviewNotAllowed:
+			handleError(w, ctx, srv, "add_review", err, http.StatusForbidden)
+		default:
+			handleError(w, ctx, srv, "add_review", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(created)
+	if err != nil {
+		handleError(w, ctx, srv, "add_review", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookReviews(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_reviews", err, http.StatusBadRequest)
+		return
+	}
+	reviews, err := srv.DB.GetReviews(uint(bookID))
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_reviews", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(reviews)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_reviews", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) updateReview(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	reviewID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "update_review", err, http.StatusBadRequest)
+		return
+	}
+	review := &models.Review{}
+	err = json.NewDecoder(r.Body).Decode(review)
+	if err != nil {
+		handleError(w, ctx, srv, "update_review", err, http.StatusBadRequest)
+		return
+	}
+	review.UserID, err = middleware.ActingUser(GetAuthInfoFromContext(ctx), review.UserID)
+	if err == middleware.ErrPermissionDenied {
+		handleError(w, ctx, srv, "update_review", err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		handleError(w, ctx, srv, "update_review", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.UpdateReview(uint(reviewID), review.UserID, review.Rating, review.Text)
+	if err != nil {
+		srv.handleReviewError(w, r, "update_review", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Review updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "update_review", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) deleteReview(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	reviewID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "delete_review", err, http.StatusBadRequest)
+		return
+	}
+	var requested uint64
+	if r.FormValue("userId") != "" {
+		requested, err = strconv.ParseUint(r.FormValue("userId"), 10, 32)
+		if err != nil {
+			handleError(w, ctx, srv, "delete_review", err, http.StatusBadRequest)
+			return
+		}
+	}
+	userID, err := middleware.ActingUser(GetAuthInfoFromContext(ctx), uint(requested))
+	if err == middleware.ErrPermissionDenied {
+		handleError(w, ctx, srv, "delete_review", err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		handleError(w, ctx, srv, "delete_review", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.DeleteReview(uint(reviewID), userID)
+	if err != nil {
+		srv.handleReviewError(w, r, "delete_review", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Review deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_review", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) moderateReview(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	reviewID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "moderate_review", err, http.StatusBadRequest)
+		return
+	}
+	hidden, err := strconv.ParseBool(r.FormValue("hidden"))
+	if err != nil {
+		handleError(w, ctx, srv, "moderate_review", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.ModerateReview(uint(reviewID), hidden)
+	if err != nil {
+		srv.handleReviewError(w, r, "moderate_review", err)
+		return
+	}
+	err = json.NewEncoder(w).Encode("Review moderated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "moderate_review", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) handleReviewError(w *middleware.LogResponseWriter, r *http.Request, task string, err error) {
+	ctx := r.Context()
+	switch err {
+	case gorm.ErrRecordNotFound:
+		handleError(w, ctx, srv, task, errors.New("no record found"), http.StatusOK)
+	case data_store.ErrInvalidRating:
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+	case data_store.ErrReviewNotOwned:
+		handleError(w, ctx, srv, task, err, http.StatusForbidden)
+	default:
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
 	"github.com/library/middleware"
+	"github.com/library/models"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -16,33 +17,74 @@ func SetupRouter(srv *Server) *chi.Mux {
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
 	r.Route("/admin/add", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.Use(middleware.RequirePermission(models.PermCatalogWrite))
 		r.Post("/book", srv.addBook)
+		r.Post("/book-copy", srv.addBookCopy)
+		r.Post("/author", srv.addAuthor)
+		r.Post("/category", srv.addCategory)
+		r.Post("/publisher", srv.addPublisher)
 	})
 	r.Route("/admin", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
-		r.Post("/updoad-image", srv.uploadImageToS3)
-		r.Post("/download-image", srv.downloadImageFromS3)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermCatalogWrite))
+			r.Post("/upload-book-cover/{id}", srv.uploadBookCover)
+			r.Put("/update-book-copy/{id}", srv.updateBookCopy)
+			r.Put("/update-author/{id}", srv.updateAuthor)
+			r.Put("/update-category/{id}", srv.updateCategory)
+			r.Put("/update-publisher/{id}", srv.updatePublisher)
+			r.Put("/update-book-authors/{id}", srv.updateBookAuthors)
+			r.Put("/update-book-categories/{id}", srv.updateBookCategories)
+			r.Put("/update-book-publishers/{id}", srv.updateBookPublishers)
+			r.Post("/import/books", srv.importBooks)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermCatalogDelete))
+			r.Delete("/delete-book-copy/{id}", srv.deleteBookCopy)
+			r.Delete("/delete-author/{id}", srv.deleteAuthor)
+			r.Delete("/delete-category/{id}", srv.deleteCategory)
+			r.Delete("/delete-publisher/{id}", srv.deletePublisher)
+		})
+		r.With(middleware.RequirePermission(models.PermReviewsModerate)).Put("/moderate-review/{id}", srv.moderateReview)
+		r.With(middleware.RequirePermission(models.PermReportsRead)).Get("/export/books", srv.exportBooks)
+	})
+	r.Route("/user", func(r chi.Router) {
+		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.Post("/add-review/{id}", srv.addReview)
+		r.Put("/update-review/{id}", srv.updateReview)
+		r.Delete("/delete-review/{id}", srv.deleteReview)
 	})
 	r.Route("/get", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(false, promMetrics, srv.Env)...)
 		r.Get("/books", srv.getBooks)
+		r.Get("/search", srv.searchBooks)
 		r.Get("/books-by-title/{title}", srv.getBooksByTitle)
 		r.Get("/books-by-isbn/{isbn}", srv.getBooksByISBN)
 		r.Get("/book-by-id/{id}", srv.getBookByBookID)
-		r.Get("/book-by-stock/{stock}", srv.getBooksByStock)
-		r.Get("/book-by-author/{author}", srv.getBooksByAuthor)
-		r.Get("/book-by-year/{year}", srv.getBooksByYear)
-		r.Get("/book-by-edition/{edition}", srv.getBooksByEdition)
-		r.Get("/book-by-rating/{rating}", srv.getBooksByRating)
 		r.Get("/book-borrow", srv.getBorrowedBooks)
 		r.Get("/book-overdue", srv.getOverdueBooks)
+		r.Get("/book-copies/{id}", srv.getBookCopies)
+		r.Get("/book-copy/{id}", srv.getBookCopyByID)
+		r.Get("/book-reviews/{id}", srv.getBookReviews)
+		r.Get("/book-cover/{id}", srv.getBookCover)
+		r.Get("/authors", srv.getAuthors)
+		r.Get("/author-by-id/{id}", srv.getAuthorByID)
+		r.Get("/book-authors/{id}", srv.getBookAuthors)
+		r.Get("/books-by-author-id/{id}", srv.getBooksByAuthorID)
+		r.Get("/categories", srv.getCategories)
+		r.Get("/category-by-id/{id}", srv.getCategoryByID)
+		r.Get("/books-by-category/{id}", srv.getBooksByCategory)
+		r.Get("/publishers", srv.getPublishers)
+		r.Get("/publisher-by-id/{id}", srv.getPublisherByID)
+		r.Get("/book-publishers/{id}", srv.getBookPublishers)
+		r.Get("/books-by-publisher/{id}", srv.getBooksByPublisherID)
 	})
 	r.Get("/health", srv.health())
 	r.Handle("/metrics", promhttp.HandlerFor(prom, promhttp.HandlerOpts{}))
@@ -0,0 +1,47 @@
+package book_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/library/middleware"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func (srv *Server) searchBooks(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		handleError(w, ctx, srv, "search_books", errors.New("query is required"), http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			handleError(w, ctx, srv, "search_books", errors.New("limit must be a positive number"), http.StatusBadRequest)
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+	results, err := srv.DB.SearchBooks(query, limit)
+	if err != nil {
+		handleError(w, ctx, srv, "search_books", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		handleError(w, ctx, srv, "search_books", err, http.StatusInternalServerError)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	datastore "github.com/library/data-store"
 	"github.com/library/envConfig"
 	"github.com/library/metrics"
+	"github.com/library/storage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -17,6 +18,7 @@ var (
 
 type Server struct {
 	DB        datastore.DbUtil
+	Store     storage.ObjectStore
 	Env       *envConfig.Env
 	EfkLogger *fluent.Fluent
 	TracingID string
@@ -24,9 +26,10 @@ type Server struct {
 	TestRun   bool
 }
 
-func NewServer(env *envConfig.Env, db datastore.DbUtil, logger *fluent.Fluent) *Server {
+func NewServer(env *envConfig.Env, db datastore.DbUtil, store storage.ObjectStore, logger *fluent.Fluent) *Server {
 	return &Server{
 		DB:        db,
+		Store:     store,
 		Env:       env,
 		EfkLogger: logger,
 		TracingID: "",
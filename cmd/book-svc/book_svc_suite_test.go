@@ -8,6 +8,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"testing"
+	"time"
 )
 
 func TestBook(t *testing.T) {
@@ -19,6 +20,7 @@ func setupAuthInfo(env *envConfig.Env) (string, string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   10101010,
 		"role": models.AdminAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	adminToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -27,6 +29,7 @@ func setupAuthInfo(env *envConfig.Env) (string, string, error) {
 	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   10101010,
 		"role": models.UserAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	userToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -35,11 +38,19 @@ func setupAuthInfo(env *envConfig.Env) (string, string, error) {
 	return adminToken, userToken, err
 }
 
+func readerToken(env *envConfig.Env, userID uint) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":   userID,
+		"role": models.UserAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(env.JwtSigningKey))
+}
+
 func cleanTestData(db *gorm.DB, data *testData) error {
 	if err := db.Exec(`delete from author where name = ?`, data.author).Error; err != nil {
 		return err
 	}
-	if err := db.Exec(`delete from subject where name = ?`, data.subject).Error; err != nil {
+	if err := db.Exec(`delete from category where name = ?`, data.category).Error; err != nil {
 		return err
 	}
 	if err := db.Exec(`delete from book where name = ?`, data.book).Error; err != nil {
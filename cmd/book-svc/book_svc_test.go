@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/kelseyhightower/envconfig"
@@ -13,26 +20,28 @@ import (
 	"github.com/library/envConfig"
 	"github.com/library/middleware"
 	"github.com/library/models"
+	"github.com/library/storage"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 type testData struct {
-	author  string
-	book    string
-	subject string
+	author   string
+	book     string
+	category string
 }
 
 var _ = Describe("Book-Service", func() {
 	var (
 		r *chi.Mux
 		// adminToken   string
-		userToken    string
-		data         *testData
-		authorCount  int
-		subjectCount int
-		bookCount    int
-		err          error
+		userToken     string
+		data          *testData
+		authorCount   int
+		categoryCount int
+		bookCount     int
+		storePath     string
+		err           error
 	)
 
 	BeforeSuite(func() {
@@ -41,40 +50,21 @@ var _ = Describe("Book-Service", func() {
 		Expect(err).To(BeNil())
 		testRun = true
 		dataStore = data_store.DbConnect(env, true)
-		srv = book_server.NewServer(env, dataStore, nil)
+		storePath, err = ioutil.TempDir("", "book-svc-objects")
+		Expect(err).To(BeNil())
+		store, err := storage.NewFileStore(storePath)
+		Expect(err).To(BeNil())
+		srv = book_server.NewServer(env, dataStore, store, nil)
 		srv.TestRun = true
 		r = book_server.SetupRouter(srv)
 		middleware.SetJwtSigningKey(srv.Env.JwtSigningKey)
+		middleware.SetPermissionSource(dataStore)
 		adminToken, userToken, err = setupAuthInfo(env)
 		Expect(err).To(BeNil())
 		r = book_server.SetupRouter(srv)
 		data = &testData{}
 	})
 	Describe("Handlers Test", func() {
-		// Describe("Add Book", func() {
-		// 	It("Should create a new book in DB", func() {
-		// 		author := &models.Author{}
-		// 		err = dataStore.Db.Where("name = 'testAuthor'").First(author).Error
-		// 		Expect(err).To(BeNil())
-		// 		bookReq := &models.Book{
-		// 			Name:     "testBook",
-		// 			Subject:  "testSubject",
-		// 			AuthorID: strconv.Itoa(int(author.ID)),
-		// 		}
-		// 		marshalReq, err := json.Marshal(bookReq)
-		// 		Expect(err).To(BeNil())
-		// 		req := httptest.NewRequest(http.MethodPost, "/admin/add/book", bytes.NewBuffer(marshalReq))
-		// 		req.Header.Set("Content-Type", "application/json")
-		// 		req.Header.Set("Authorization", "Bearer "+adminToken)
-		// 		rec := httptest.NewRecorder()
-		// 		r.ServeHTTP(rec, req)
-		// 		resp := rec.Result()
-		// 		Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
-		// 		data.book = bookReq.Name
-		// 		err = dataStore.Db.Table("book").Count(&bookCount).Error
-		// 		Expect(err).To(BeNil())
-		// 	})
-		// })
 		Describe("Get All Authors", func() {
 			It("Should return all the authors", func() {
 				req := httptest.NewRequest(http.MethodGet, "/get/authors", nil)
@@ -86,6 +76,8 @@ var _ = Describe("Book-Service", func() {
 				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
 				var authors []map[string]interface{}
 				err = json.NewDecoder(resp.Body).Decode(&authors)
+				err = dataStore.Db.Table("author").Count(&authorCount).Error
+				Expect(err).To(BeNil())
 				Expect(len(authors)).To(BeEquivalentTo(authorCount))
 			})
 		})
@@ -100,21 +92,149 @@ var _ = Describe("Book-Service", func() {
 				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
 				var books []map[string]interface{}
 				err = json.NewDecoder(resp.Body).Decode(&books)
-				Expect(len(books)).To(BeEquivalentTo(bookCount))
+				err = dataStore.Db.Table("book").Where("deleted_at is null").Count(&bookCount).Error
+				Expect(err).To(BeNil())
+				Expect(resp.Header.Get("X-Total-Count")).To(BeEquivalentTo(fmt.Sprint(bookCount)))
+				Expect(len(books)).To(BeNumerically("<=", 20))
+			})
+
+			It("Should page through the books with the cursor in the Link header", func() {
+				seen := map[float64]bool{}
+				next := "/get/books?limit=1&sort=-name"
+				for next != "" {
+					req := httptest.NewRequest(http.MethodGet, next, nil)
+					req.Header.Set("Authorization", "Bearer "+userToken)
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					resp := rec.Result()
+					Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+					var books []map[string]interface{}
+					err = json.NewDecoder(resp.Body).Decode(&books)
+					Expect(err).To(BeNil())
+					for _, book := range books {
+						Expect(seen[book["id"].(float64)]).To(BeFalse())
+						seen[book["id"].(float64)] = true
+					}
+					next = ""
+					if link := resp.Header.Get("Link"); link != "" {
+						next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+					}
+				}
+				var total int
+				err = dataStore.Db.Table("book").Where("deleted_at is null").Count(&total).Error
+				Expect(err).To(BeNil())
+				Expect(len(seen)).To(BeEquivalentTo(total))
+			})
+
+			It("Should reject an unknown sort field", func() {
+				req := httptest.NewRequest(http.MethodGet, "/get/books?sort=password", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+
+			It("Should reject a cursor used with a different sort", func() {
+				req := httptest.NewRequest(http.MethodGet, "/get/books?limit=1&sort=name", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				link := resp.Header.Get("Link")
+				Expect(link).NotTo(BeEmpty())
+				next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+
+				req = httptest.NewRequest(http.MethodGet, strings.Replace(next, "sort=name", "sort=-name", 1), nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+
+			It("Should compare the year range as numbers", func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: 10101016},
+					Name:      "yearBook",
+					Year:      "999",
+				})
+				Expect(err).To(BeNil())
+				defer dataStore.Db.Exec(`delete from book where id = ?`, 10101016)
+
+				req := httptest.NewRequest(http.MethodGet, "/get/books?yearFrom=1000&limit=100", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var books []models.Book
+				err = json.NewDecoder(resp.Body).Decode(&books)
+				Expect(err).To(BeNil())
+				for _, book := range books {
+					Expect(book.ID).NotTo(BeEquivalentTo(10101016))
+				}
+
+				req = httptest.NewRequest(http.MethodGet, "/get/books?yearTo=999&limit=100", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp = rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				err = json.NewDecoder(resp.Body).Decode(&books)
+				Expect(err).To(BeNil())
+				Expect(books).To(ContainElement(WithTransform(func(b models.Book) uint { return b.ID }, BeEquivalentTo(10101016))))
 			})
 		})
-		Describe("Get All Subjects", func() {
-			It("Should return all the subjects", func() {
-				req := httptest.NewRequest(http.MethodGet, "/get/subjects", nil)
+		Describe("Get All Categories", func() {
+			It("Should return all the categories", func() {
+				req := httptest.NewRequest(http.MethodGet, "/get/categories", nil)
 				req.Header.Set("Content-Type", "application/json")
 				req.Header.Set("Authorization", "Bearer "+userToken)
 				rec := httptest.NewRecorder()
 				r.ServeHTTP(rec, req)
 				resp := rec.Result()
 				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
-				var subjects []map[string]interface{}
-				err = json.NewDecoder(resp.Body).Decode(&subjects)
-				Expect(len(subjects)).To(BeEquivalentTo(subjectCount))
+				var categories []map[string]interface{}
+				err = json.NewDecoder(resp.Body).Decode(&categories)
+				err = dataStore.Db.Table("category").Count(&categoryCount).Error
+				Expect(err).To(BeNil())
+				Expect(len(categories)).To(BeEquivalentTo(categoryCount))
+			})
+
+			It("Should list the books of a whole category subtree", func() {
+				root, err := dataStore.CreateCategory(models.Category{Name: "testRootCategory"})
+				Expect(err).To(BeNil())
+				child, err := dataStore.CreateCategory(models.Category{Name: "testChildCategory", ParentID: &root.ID})
+				Expect(err).To(BeNil())
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: 10101014},
+					Name:      "categoryBook",
+					Author:    "firstTestAuthor, secondTestAuthor",
+					Category:  "testChildCategory",
+				})
+				Expect(err).To(BeNil())
+				defer func() {
+					dataStore.Db.Exec(`delete from book where id = ?`, 10101014)
+					dataStore.Db.Exec(`delete from category where id in (?, ?)`, child.ID, root.ID)
+					dataStore.Db.Exec(`delete from author where name in (?, ?)`, "firstTestAuthor", "secondTestAuthor")
+				}()
+
+				authors, err := dataStore.GetBookAuthors(10101014)
+				Expect(err).To(BeNil())
+				Expect(len(*authors)).To(BeEquivalentTo(2))
+				Expect((*authors)[0].Name).To(BeEquivalentTo("firstTestAuthor"))
+
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get/books-by-category/%d", root.ID), nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var books []models.Book
+				err = json.NewDecoder(resp.Body).Decode(&books)
+				Expect(err).To(BeNil())
+				Expect(len(books)).To(BeEquivalentTo(1))
+				Expect(books[0].Category).To(BeEquivalentTo("testChildCategory"))
 			})
 		})
 		Describe("Get Books By Name", func() {
@@ -149,9 +269,368 @@ var _ = Describe("Book-Service", func() {
 
 			})
 		})
+		Describe("Add Book Copy", func() {
+			It("Should not allow readers to register copies", func() {
+				copyReq := &models.BookCopy{
+					BookID:  1,
+					Barcode: "unit-test-copy",
+				}
+				marshalReq, err := json.Marshal(copyReq)
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, "/admin/add/book-copy", bytes.NewBuffer(marshalReq))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusUnauthorized))
+			})
+
+			Describe("As an admin", func() {
+				const copyBookID = 10101015
+
+				BeforeEach(func() {
+					err = dataStore.CreateBook(models.Book{
+						BaseModel: models.BaseModel{ID: copyBookID},
+						Name:      "copyBook",
+						Stock:     1,
+					})
+					Expect(err).To(BeNil())
+				})
+
+				AfterEach(func() {
+					err = dataStore.Db.Exec(`delete from book where id = ?`, copyBookID).Error
+					Expect(err).To(BeNil())
+				})
+
+				send := func(method, target string, body interface{}) *http.Response {
+					marshalReq, err := json.Marshal(body)
+					Expect(err).To(BeNil())
+					req := httptest.NewRequest(method, target, bytes.NewBuffer(marshalReq))
+					req.Header.Set("Content-Type", "application/json")
+					req.Header.Set("Authorization", "Bearer "+adminToken)
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					return rec.Result()
+				}
+				stock := func() uint {
+					book, err := dataStore.GetBookByID(copyBookID)
+					Expect(err).To(BeNil())
+					return book.Stock
+				}
+
+				It("Should count added and withdrawn copies in the stock", func() {
+					resp := send(http.MethodPost, "/admin/add/book-copy", &models.BookCopy{
+						BookID:  copyBookID,
+						Barcode: "unit-test-copy-2",
+					})
+					Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+					created := &models.BookCopy{}
+					Expect(json.NewDecoder(resp.Body).Decode(created)).To(BeNil())
+					Expect(created.Status).To(Equal(models.CopyAvailable))
+					Expect(stock()).To(BeEquivalentTo(2))
+
+					resp = send(http.MethodDelete, fmt.Sprintf("/admin/delete-book-copy/%d", created.ID), nil)
+					Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+					Expect(stock()).To(BeEquivalentTo(1))
+				})
+
+				It("Should not withdraw a copy that is on loan", func() {
+					copies, err := dataStore.GetBookCopies(copyBookID)
+					Expect(err).To(BeNil())
+					Expect(*copies).To(HaveLen(1))
+					onLoan := (*copies)[0]
+					err = dataStore.Db.Model(&models.BookCopy{}).Where("id = ?", onLoan.ID).
+						Update("status", models.CopyBorrowed).Error
+					Expect(err).To(BeNil())
+
+					resp := send(http.MethodDelete, fmt.Sprintf("/admin/delete-book-copy/%d", onLoan.ID), nil)
+					Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+					_, err = dataStore.GetBookCopyByID(onLoan.ID)
+					Expect(err).To(BeNil())
+				})
+			})
+		})
+		Describe("Add Book ISBN", func() {
+			const isbnBookID = 10101014
+
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book_copy where book_id = ?`, isbnBookID).Error
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Exec(`delete from book where id = ?`, isbnBookID).Error
+				Expect(err).To(BeNil())
+			})
+
+			addBook := func(isbn, query string) *http.Response {
+				marshalReq, err := json.Marshal(&models.Book{Name: "isbnBook", ISBN: isbn, Stock: 2})
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, "/admin/add/book"+query, bytes.NewBuffer(marshalReq))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Result()
+			}
+
+			It("Should reject an isbn with a bad checksum", func() {
+				Expect(addBook("978-0-306-40615-8", "").StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+
+			It("Should detect the same isbn written as isbn-10 and top up its stock on request", func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: isbnBookID},
+					Name:      "isbnBook",
+					ISBN:      "978-0-306-40615-7",
+					Stock:     1,
+				})
+				Expect(err).To(BeNil())
+				Expect(addBook("0-306-40615-2", "").StatusCode).To(BeEquivalentTo(http.StatusConflict))
+				Expect(addBook("0306406152", "?addStock=true").StatusCode).To(BeEquivalentTo(http.StatusOK))
+
+				book, err := dataStore.GetBookByID(isbnBookID)
+				Expect(err).To(BeNil())
+				Expect(book.Stock).To(BeEquivalentTo(3))
+			})
+		})
+		Describe("Bulk Import", func() {
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book_copy where book_id in
+					(select id from book where isbn_normalized = '9780140449136')`).Error
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Exec(`delete from book where isbn_normalized = '9780140449136'`).Error
+				Expect(err).To(BeNil())
+			})
+
+			importBooks := func(query string) *models.ImportReport {
+				file := "isbn,name,author,stock\n" +
+					"978-0-14-044913-6,importedBook,Homer,2\n" +
+					"978-0-14-044913-7,badIsbnBook,Homer,1\n"
+				req := httptest.NewRequest(http.MethodPost, "/admin/import/books?format=csv"+query,
+					strings.NewReader(file))
+				req.Header.Set("Content-Type", "text/csv")
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusOK))
+				report := &models.ImportReport{}
+				err = json.NewDecoder(rec.Result().Body).Decode(report)
+				Expect(err).To(BeNil())
+				return report
+			}
+
+			It("Should validate every row without writing on a dry run", func() {
+				report := importBooks("&dryRun=true")
+				Expect(report.Rows).To(BeEquivalentTo(2))
+				Expect(report.Created).To(BeEquivalentTo(1))
+				Expect(report.Failed).To(BeEquivalentTo(1))
+				Expect(report.Errors[0].Row).To(BeEquivalentTo(2))
+				books, err := dataStore.GetBooksByISBN("9780140449136")
+				Expect(err).To(BeNil())
+				Expect(*books).To(BeEmpty())
+			})
+
+			It("Should import, report duplicates and export the catalog", func() {
+				Expect(importBooks("").Created).To(BeEquivalentTo(1))
+				Expect(importBooks("").Errors[0].Error).To(ContainSubstring("already exists"))
+				Expect(importBooks("&upsert=true").Updated).To(BeEquivalentTo(1))
+
+				req := httptest.NewRequest(http.MethodGet, "/admin/export/books?format=jsonl", nil)
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusOK))
+				Expect(rec.Body.String()).To(ContainSubstring(`"name":"importedBook"`))
+			})
+		})
+		Describe("Book Cover", func() {
+			const coverBookID = 10101015
+
+			BeforeEach(func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: coverBookID},
+					Name:      "coverBook",
+				})
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book where id = ?`, coverBookID).Error
+				Expect(err).To(BeNil())
+			})
+
+			uploadCover := func(image []byte) *http.Response {
+				body := &bytes.Buffer{}
+				form := multipart.NewWriter(body)
+				part, err := form.CreateFormFile("image", "cover.png")
+				Expect(err).To(BeNil())
+				_, err = part.Write(image)
+				Expect(err).To(BeNil())
+				Expect(form.Close()).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/upload-book-cover/%d", coverBookID), body)
+				req.Header.Set("Content-Type", form.FormDataContentType())
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Result()
+			}
+
+			It("Should refuse a file that is not an image", func() {
+				Expect(uploadCover([]byte("just some text")).StatusCode).To(BeEquivalentTo(http.StatusUnsupportedMediaType))
+			})
+
+			It("Should store the cover and stream it back", func() {
+				buf := &bytes.Buffer{}
+				err = png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 800, 600)))
+				Expect(err).To(BeNil())
+				cover := buf.Bytes()
+				resp := uploadCover(cover)
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				book := &models.Book{}
+				err = json.NewDecoder(resp.Body).Decode(book)
+				Expect(err).To(BeNil())
+				Expect(book.Cover).To(BeEquivalentTo(fmt.Sprintf("/get/book-cover/%d", coverBookID)))
+
+				req := httptest.NewRequest(http.MethodGet, book.Cover, nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusOK))
+				Expect(rec.Result().Header.Get("Content-Type")).To(BeEquivalentTo("image/png"))
+				Expect(rec.Body.Bytes()).To(BeEquivalentTo(cover))
+			})
+
+			It("Should serve thumbnails that clients can revalidate", func() {
+				buf := &bytes.Buffer{}
+				err = png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 800, 600)))
+				Expect(err).To(BeNil())
+				Expect(uploadCover(buf.Bytes()).StatusCode).To(BeEquivalentTo(http.StatusOK))
+
+				getCover := func(size, etag string) *http.Response {
+					req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get/book-cover/%d?size=%s", coverBookID, size), nil)
+					req.Header.Set("If-None-Match", etag)
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					return rec.Result()
+				}
+				resp := getCover("small", "")
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				Expect(resp.Header.Get("Cache-Control")).NotTo(BeEmpty())
+				small, err := png.DecodeConfig(resp.Body)
+				Expect(err).To(BeNil())
+				Expect(small.Width).To(BeEquivalentTo(160))
+				Expect(small.Height).To(BeEquivalentTo(120))
+
+				etag := resp.Header.Get("ETag")
+				Expect(getCover("small", etag).StatusCode).To(BeEquivalentTo(http.StatusNotModified))
+				Expect(getCover("large", etag).StatusCode).To(BeEquivalentTo(http.StatusOK))
+				Expect(getCover("huge", "").StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+		})
+		Describe("Reviews", func() {
+			const (
+				reviewBookID = 10101012
+				reviewerID   = 10101013
+			)
+
+			BeforeEach(func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: reviewBookID},
+					Name:      "reviewBook",
+				})
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Create(&models.Account{
+					BaseModel:   models.BaseModel{ID: reviewerID},
+					Email:       "reviewer@user.com",
+					AccountRole: models.UserAccount,
+				}).Error
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book_history where book_id = ?`, reviewBookID).Error
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Exec(`delete from book where id = ?`, reviewBookID).Error
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Exec(`delete from account where id = ?`, reviewerID).Error
+				Expect(err).To(BeNil())
+			})
+
+			postReviewAs := func(token string, rating uint) *http.Response {
+				marshalReq, err := json.Marshal(&models.Review{UserID: reviewerID, Rating: rating, Text: "good read"})
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/user/add-review/%d", reviewBookID),
+					bytes.NewBuffer(marshalReq))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Result()
+			}
+			postReview := func(rating uint) *http.Response {
+				token, err := readerToken(env, reviewerID)
+				Expect(err).To(BeNil())
+				return postReviewAs(token, rating)
+			}
+
+			It("Should not let a reader review in the name of another", func() {
+				err = dataStore.Db.Create(&models.BookHistory{
+					UserID: reviewerID,
+					BookID: reviewBookID,
+					Status: "returned",
+				}).Error
+				Expect(err).To(BeNil())
+				Expect(postReviewAs(userToken, 4).StatusCode).To(BeEquivalentTo(http.StatusUnauthorized))
+			})
+
+			It("Should not accept a review from a reader who never returned the book", func() {
+				Expect(postReview(4).StatusCode).To(BeEquivalentTo(http.StatusForbidden))
+			})
+
+			It("Should fold the review into the book rating", func() {
+				err = dataStore.Db.Create(&models.BookHistory{
+					UserID: reviewerID,
+					BookID: reviewBookID,
+					Status: "returned",
+				}).Error
+				Expect(err).To(BeNil())
+				Expect(postReview(4).StatusCode).To(BeEquivalentTo(http.StatusOK))
+				Expect(postReview(2).StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+
+				book, err := dataStore.GetBookByID(reviewBookID)
+				Expect(err).To(BeNil())
+				Expect(book.Rating).To(BeEquivalentTo(4))
+				Expect(book.RatingCount).To(BeEquivalentTo(1))
+			})
+		})
+		Describe("Search Books", func() {
+			It("Should rank the books matching the query", func() {
+				req := httptest.NewRequest(http.MethodGet, "/get/search?q=testBook", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var results []models.SearchResult
+				err = json.NewDecoder(resp.Body).Decode(&results)
+				Expect(err).To(BeNil())
+				Expect(results).NotTo(BeEmpty())
+				Expect(results[0].Name).To(BeEquivalentTo("testBook"))
+				Expect(results[0].Snippets["name"]).To(ContainSubstring("<mark>"))
+			})
+
+			It("Should reject an empty query", func() {
+				req := httptest.NewRequest(http.MethodGet, "/get/search?q=", nil)
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Result().StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+		})
 	})
 	AfterSuite(func() {
 		err = cleanTestData(dataStore.Db, data)
 		Expect(err).To(BeNil())
+		err = os.RemoveAll(storePath)
+		Expect(err).To(BeNil())
 	})
 })
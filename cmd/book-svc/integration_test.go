@@ -6,83 +6,102 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/library/models"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// seedCatalog creates testBook linked to testAuthor and testCategory,
+// clearing whatever an earlier run left behind.
+func seedCatalog() (*models.Author, *models.Category, error) {
+	data := &testData{book: "testBook", author: "testAuthor", category: "testCategory"}
+	if err := cleanTestData(dataStore.Db, data); err != nil {
+		return nil, nil, err
+	}
+	author, err := dataStore.CreateAuthor(models.Author{Name: data.author})
+	if err != nil {
+		return nil, nil, err
+	}
+	category, err := dataStore.CreateCategory(models.Category{Name: data.category})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dataStore.CreateBook(models.Book{
+		Name:     data.book,
+		Author:   data.author,
+		Category: data.category,
+	})
+	return author, category, err
+}
+
+func getJSON(url string, v interface{}) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
 func TestBookService(t *testing.T) {
-	// Convey("POST /add-book", t, func() {
-	// 	url := fmt.Sprintf("%s/admin/add/book", testServer.URL)
-	// 	Convey("It should create a new book", func() {
-	// 		authors, err := dataStore.GetAuthorsByName("testAuthor")
-	// 		So(err, ShouldBeNil)
-	// 		testAuthorID = strconv.Itoa(int((*authors)[0].ID))
-	// 		bookReq := &models.Book{
-	// 			Name:     "testBook",
-	// 			Subject:  "testSubject",
-	// 			AuthorID: testAuthorID,
-	// 		}
-	// 		marshalReq, err := json.Marshal(bookReq)
-	// 		So(err, ShouldBeNil)
-	// 		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(marshalReq))
-	// 		So(err, ShouldBeNil)
-	// 		req.Header.Set("Content-Type", "application/json")
-	// 		req.Header.Set("Authorization", "Bearer "+adminToken)
-	// 		resp, err := http.DefaultClient.Do(req)
-	// 		So(err, ShouldBeNil)
-	// 		So(resp.StatusCode, ShouldEqual, http.StatusOK)
-	// 		defer resp.Body.Close()
-	// 	})
-	// })
+	author, category, err := seedCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	Convey("GET /get-book-by-name", t, func() {
-		url := fmt.Sprintf("%s/get/books-by-name/testBook", testServer.URL)
-		Convey("It should retrieve book by name", func() {
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-			So(err, ShouldBeNil)
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+userToken)
-			resp, err := http.DefaultClient.Do(req)
-			So(err, ShouldBeNil)
-			So(resp.StatusCode, ShouldEqual, http.StatusOK)
-			defer resp.Body.Close()
+	Convey("GET /get/books-by-title", t, func() {
+		url := fmt.Sprintf("%s/get/books-by-title/testBook", testServer.URL)
+		Convey("It should retrieve book by title", func() {
 			var books []map[string]interface{}
-			err = json.NewDecoder(resp.Body).Decode(&books)
+			status, err := getJSON(url, &books)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			So(books, ShouldNotBeEmpty)
 			So(books[0]["name"].(string), ShouldEqual, "testBook")
 		})
 	})
 
-	Convey("GET /get-author-by-name", t, func() {
-		url := fmt.Sprintf("%s/get/author-by-name/testAuthor", testServer.URL)
-		Convey("It should retrieve author by name", func() {
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-			So(err, ShouldBeNil)
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+userToken)
-			resp, err := http.DefaultClient.Do(req)
+	Convey("GET /get/authors", t, func() {
+		url := fmt.Sprintf("%s/get/authors", testServer.URL)
+		Convey("It should list the seeded author", func() {
+			var authors []models.Author
+			status, err := getJSON(url, &authors)
 			So(err, ShouldBeNil)
-			So(resp.StatusCode, ShouldEqual, http.StatusOK)
-			defer resp.Body.Close()
-			var authors []map[string]interface{}
-			err = json.NewDecoder(resp.Body).Decode(&authors)
-			So(authors[0]["name"].(string), ShouldEqual, "testAuthor")
+			So(status, ShouldEqual, http.StatusOK)
+			names := map[uint]string{}
+			for _, a := range authors {
+				names[a.ID] = a.Name
+			}
+			So(names[author.ID], ShouldEqual, "testAuthor")
 		})
 	})
 
-	Convey("GET /get-book-by-author", t, func() {
-		url := fmt.Sprintf("%s/get/books-by-author/%s", testServer.URL, testAuthorID)
+	Convey("GET /get/books-by-author-id", t, func() {
+		url := fmt.Sprintf("%s/get/books-by-author-id/%d", testServer.URL, author.ID)
 		Convey("It should retrieve books by author", func() {
-			req, err := http.NewRequest(http.MethodGet, url, nil)
-			So(err, ShouldBeNil)
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+userToken)
-			resp, err := http.DefaultClient.Do(req)
-			So(err, ShouldBeNil)
-			So(resp.StatusCode, ShouldEqual, http.StatusOK)
-			defer resp.Body.Close()
 			var books []map[string]interface{}
-			err = json.NewDecoder(resp.Body).Decode(&books)
+			status, err := getJSON(url, &books)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			So(len(books), ShouldEqual, 1)
 			So(books[0]["name"].(string), ShouldEqual, "testBook")
 		})
 	})
 
+	Convey("GET /get/books-by-category", t, func() {
+		url := fmt.Sprintf("%s/get/books-by-category/%d", testServer.URL, category.ID)
+		Convey("It should retrieve books by category", func() {
+			var books []map[string]interface{}
+			status, err := getJSON(url, &books)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			So(len(books), ShouldEqual, 1)
+			So(books[0]["name"].(string), ShouldEqual, "testBook")
+		})
+	})
 }
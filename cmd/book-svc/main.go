@@ -10,7 +10,9 @@ import (
 	book_server "github.com/library/cmd/book-svc/book-server"
 	data_store "github.com/library/data-store"
 	"github.com/library/envConfig"
+	"github.com/library/jwtkeys"
 	"github.com/library/middleware"
+	"github.com/library/storage"
 	"github.com/sirupsen/logrus"
 )
 
@@ -38,8 +40,24 @@ func main() {
 
 	dataStore = data_store.DbConnect(env, testRun)
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
+	resolver, err := jwtkeys.NewResolver(env.AuthConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("loading jwt keys")
+	}
+	middleware.SetKeyResolver(resolver)
+
+	store, err := storage.New(env.StorageConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("object storage setup")
+	}
 
-	srv = book_server.NewServer(env, dataStore, logger)
+	srv = book_server.NewServer(env, dataStore, store, logger)
 	err = srv.ListenAndServe("book-service", env.BookSvcPort)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -6,17 +6,18 @@ import (
 	data_store "github.com/library/data-store"
 	"github.com/library/envConfig"
 	"github.com/library/middleware"
+	"github.com/library/storage"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http/httptest"
 	"os"
 	"testing"
 )
 
 var (
-	adminToken   string
-	userToken    string
-	testServer   *httptest.Server
-	testAuthorID string
+	adminToken string
+	userToken  string
+	testServer *httptest.Server
 )
 
 func init() {
@@ -34,16 +35,32 @@ func TestMain(m *testing.M) {
 	}
 	dataStore = data_store.DbConnect(env, true)
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
 	adminToken, userToken, err = setupAuthInfo(env)
 
-	srv = book_server.NewServer(env, dataStore, nil)
+	storePath, err := ioutil.TempDir("", "book-svc-objects")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("creating object store")
+	}
+	defer os.RemoveAll(storePath)
+	store, err := storage.NewFileStore(storePath)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("creating object store")
+	}
+
+	srv = book_server.NewServer(env, dataStore, store, nil)
 	r := book_server.SetupRouter(srv)
 	testServer = httptest.NewServer(r)
 	_ = m.Run()
 	if err := cleanTestData(dataStore.Db, &testData{
-		book:    "testBook",
-		subject: "testSubject",
-		author:  "testAuthor",
+		book:     "testBook",
+		category: "testCategory",
+		author:   "testAuthor",
 	}); err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	management_server "github.com/library/cmd/management-svc/management-server"
+	"github.com/library/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	raceBookID    = 3030
+	raceFirstUser = 3031
+	raceReaders   = 12
+)
+
+var _ = Describe("Concurrent Reservations", func() {
+	var (
+		r      *chi.Mux
+		tokens []string
+		err    error
+	)
+
+	BeforeEach(func() {
+		r = management_server.SetupRouter(srv, nil)
+		// every reader reserves with a token of their own, as /user routes act
+		// for the caller only
+		tokens = make([]string, raceReaders)
+		for i := 0; i < raceReaders; i++ {
+			tokens[i], err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"id":   raceFirstUser + i,
+				"role": models.UserAccount,
+				"exp":  time.Now().Add(time.Hour).Unix(),
+			}).SignedString([]byte(env.JwtSigningKey))
+			Expect(err).To(BeNil())
+			err = dataStore.Db.Create(&models.Account{
+				BaseModel:   models.BaseModel{ID: uint(raceFirstUser + i)},
+				Email:       fmt.Sprintf("race%d@user.com", i),
+				AccountRole: models.UserAccount,
+			}).Error
+			Expect(err).To(BeNil())
+		}
+	})
+
+	AfterEach(func() {
+		err = dataStore.Db.Exec(`delete from book where id = ?`, raceBookID).Error
+		Expect(err).To(BeNil())
+		err = dataStore.Db.Exec(`delete from account where id >= ? and id < ?`,
+			raceFirstUser, raceFirstUser+raceReaders).Error
+		Expect(err).To(BeNil())
+	})
+
+	reserveInParallel := func() map[int]int {
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			statuses = map[int]int{}
+		)
+		for i := 0; i < raceReaders; i++ {
+			wg.Add(1)
+			go func(userID int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				formData := url.Values{
+					"userId":       {fmt.Sprint(userID)},
+					"reservedDate": {"2019-10-01"},
+					"returnDate":   {"2019-10-15"},
+				}
+				req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/user/reserve-book/%d", raceBookID),
+					strings.NewReader(formData.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+tokens[userID-raceFirstUser])
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				mu.Lock()
+				statuses[rec.Code]++
+				mu.Unlock()
+			}(raceFirstUser + i)
+		}
+		wg.Wait()
+		return statuses
+	}
+
+	for _, copies := range []uint{1, 3} {
+		copies := copies
+		It(fmt.Sprintf("Should lend exactly %d copies when %d readers race for them", copies, raceReaders), func() {
+			err = dataStore.CreateBook(models.Book{
+				BaseModel: models.BaseModel{ID: raceBookID},
+				Name:      "raceBook",
+				Stock:     copies,
+			})
+			Expect(err).To(BeNil())
+
+			statuses := reserveInParallel()
+			Expect(statuses[http.StatusOK]).To(BeEquivalentTo(copies))
+			Expect(statuses[http.StatusAccepted]).To(BeEquivalentTo(raceReaders - int(copies)))
+
+			book := &models.Book{}
+			err = dataStore.Db.Where("id = ?", raceBookID).First(book).Error
+			Expect(err).To(BeNil())
+			Expect(book.Stock).To(BeEquivalentTo(0))
+
+			var borrowedCopies, loans, holds int
+			err = dataStore.Db.Model(&models.BookCopy{}).Where("book_id = ? and status = ?", raceBookID,
+				models.CopyBorrowed).Count(&borrowedCopies).Error
+			Expect(err).To(BeNil())
+			Expect(borrowedCopies).To(BeEquivalentTo(copies))
+			err = dataStore.Db.Model(&models.BookHistory{}).Where("book_id = ? and status = 'borrowed'",
+				raceBookID).Count(&loans).Error
+			Expect(err).To(BeNil())
+			Expect(loans).To(BeEquivalentTo(copies))
+			err = dataStore.Db.Model(&models.BookQueue{}).Where("book_id = ? and status = ?", raceBookID,
+				models.HoldWaiting).Count(&holds).Error
+			Expect(err).To(BeNil())
+			Expect(holds).To(BeEquivalentTo(raceReaders - int(copies)))
+		})
+	}
+
+	It("Should not double count returns confirmed in parallel", func() {
+		err = dataStore.CreateBook(models.Book{
+			BaseModel: models.BaseModel{ID: raceBookID},
+			Name:      "raceBook",
+			Stock:     1,
+		})
+		Expect(err).To(BeNil())
+		Expect(reserveInParallel()[http.StatusOK]).To(BeEquivalentTo(1))
+
+		loan := &models.BookHistory{}
+		err = dataStore.Db.Where("book_id = ? and status = 'borrowed'", raceBookID).First(loan).Error
+		Expect(err).To(BeNil())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_ = dataStore.AdminConfirmReturnBook(raceBookID, loan.UserID, nil, 0)
+			}()
+		}
+		wg.Wait()
+
+		reader := &models.Account{}
+		err = dataStore.Db.Where("id = ?", loan.UserID).First(reader).Error
+		Expect(err).To(BeNil())
+		Expect(reader.ReservedBooks).To(BeEquivalentTo(0))
+		var ready int
+		err = dataStore.Db.Model(&models.BookQueue{}).Where("book_id = ? and status = ?", raceBookID,
+			models.HoldReady).Count(&ready).Error
+		Expect(err).To(BeNil())
+		Expect(ready).To(BeEquivalentTo(1))
+	})
+})
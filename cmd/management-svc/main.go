@@ -10,6 +10,7 @@ import (
 	management_server "github.com/library/cmd/management-svc/management-server"
 	data_store "github.com/library/data-store"
 	"github.com/library/envConfig"
+	"github.com/library/jwtkeys"
 	"github.com/library/middleware"
 	"github.com/sirupsen/logrus"
 )
@@ -37,6 +38,15 @@ func main() {
 	}
 
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
+	resolver, err := jwtkeys.NewResolver(env.AuthConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("loading jwt keys")
+	}
+	middleware.SetKeyResolver(resolver)
 	dataStore = data_store.DbConnect(env, testRun)
 
 	srv = management_server.NewServer(env, dataStore, logger)
@@ -33,6 +33,8 @@ func TestMain(m *testing.M) {
 	}
 	dataStore = data_store.DbConnect(env, true)
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
 	if err := cleanMockData(dataStore.Db); err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
@@ -0,0 +1,75 @@
+package management_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+)
+
+func (srv *Server) getFinesByStudent(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	userID, ok := srv.actingUser(w, r, "get_fines_of_student", chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	currentTime := time.Now()
+	balance, err := srv.DB.GetFineBalance(uint(userID), srv.Env.FineRatePerDay, &currentTime)
+	if err != nil {
+		handleError(w, ctx, srv, "get_fines_of_student", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(balance)
+	if err != nil {
+		handleError(w, ctx, srv, "get_fines_of_student", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) recordFinePayment(wr http.ResponseWriter, r *http.Request) {
+	srv.settleFine(wr, r, "record_fine_payment", srv.DB.RecordFinePayment, "Payment recorded successfully!")
+}
+
+func (srv *Server) waiveFine(wr http.ResponseWriter, r *http.Request) {
+	srv.settleFine(wr, r, "waive_fine", srv.DB.WaiveFine, "Fine waived successfully!")
+}
+
+func (srv *Server) settleFine(wr http.ResponseWriter, r *http.Request, task string,
+	settle func(uint, int64, string, int64, *time.Time) error, message string) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	userId := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(userId)
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		return
+	}
+	currentTime := time.Now()
+	err = settle(uint(userID), amount, r.FormValue("note"), srv.Env.FineRatePerDay, &currentTime)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, task, errors.New("no record found"), http.StatusOK)
+		case data_store.ErrInvalidFineAmount, data_store.ErrFineExceedsDue:
+			handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(message)
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+	}
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/isbn"
 	"github.com/library/middleware"
 	"github.com/library/models"
 	"github.com/sirupsen/logrus"
@@ -20,14 +22,36 @@ func GetAuthInfoFromContext(ctx context.Context) *models.AuthInfo {
 	return ctx.Value(middleware.ContextAuthInfo).(*models.AuthInfo)
 }
 
+// actingUser resolves the user a /user request acts for from the optional
+// user id in its path or form; readers act for themselves, staff with the
+// users:act permission for anyone.
+// When ok is false the error has been written.
+func (srv *Server) actingUser(w *middleware.LogResponseWriter, r *http.Request, task, requested string) (uint, bool) {
+	ctx := r.Context()
+	var userID uint64
+	if requested != "" {
+		var err error
+		userID, err = strconv.ParseUint(requested, 10, 32)
+		if err != nil {
+			handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+			return 0, false
+		}
+	}
+	actor, err := middleware.ActingUser(GetAuthInfoFromContext(ctx), uint(userID))
+	if err == middleware.ErrPermissionDenied {
+		handleError(w, ctx, srv, task, err, http.StatusUnauthorized)
+		return 0, false
+	}
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return 0, false
+	}
+	return actor, true
+}
+
 func (srv *Server) getCompleteHistory(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_complete_history", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	history, err := srv.DB.GetCompleteHistory()
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -46,11 +70,6 @@ func (srv *Server) getCompleteHistory(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getHistory(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_history", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	bookID, err := strconv.Atoi(id)
 	if err != nil {
@@ -75,11 +94,6 @@ func (srv *Server) getHistory(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getBorrowHistory(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_borrowed_history", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	history, err := srv.DB.GetBooksbyStatus("borrowed")
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -98,11 +112,6 @@ func (srv *Server) getBorrowHistory(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getReturnHistory(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_returned_history", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	history, err := srv.DB.GetBooksbyStatus("returned")
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -121,11 +130,6 @@ func (srv *Server) getReturnHistory(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getOverdueHistory(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_overdue_history", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	history, err := srv.DB.GetBooksbyStatus("overdue")
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -186,24 +190,45 @@ func (srv *Server) reserveBook(wr http.ResponseWriter, r *http.Request) {
 		fmt.Println(err)
 		return
 	}
-	userID, err := strconv.Atoi(user)
-	if err != nil {
-		handleError(w, ctx, srv, "reserve_book", err, http.StatusInternalServerError)
+	userID, ok := srv.actingUser(w, r, "reserve_book", user)
+	if !ok {
 		return
 	}
 	days := returnDate.Sub(reservedDate).Hours() / 24
 	// fmt.Println(days)
-	if days > 42.0 {
+	if days > float64(srv.Env.MaxLoanDays) {
 		// handleError(w, ctx, srv, "Book_cannot_be_reserved_for_more_than_6_weeks", http.StatusBadRequest)
 		json.NewEncoder(w).Encode("Book cannot be reserved for more than 6 weeks!")
 		return
 	}
-	err = srv.DB.ReserveBook(uint(bookID), uint(userID), &reservedDate, &returnDate)
+	err = srv.DB.ReserveBook(uint(bookID), uint(userID), &reservedDate, &returnDate,
+		srv.Env.FineRatePerDay, srv.Env.FineBlockThreshold)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			handleError(w, ctx, srv, "reserve_book", errors.New("no record found"), http.StatusOK)
 			return
 		}
+		if err == data_store.ErrBookQueued {
+			position, err := srv.DB.GetQueuePosition(uint(bookID), uint(userID))
+			if err != nil {
+				handleError(w, ctx, srv, "reserve_book", err, http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			err = json.NewEncoder(w).Encode(position)
+			if err != nil {
+				handleError(w, ctx, srv, "reserve_book", err, http.StatusInternalServerError)
+			}
+			return
+		}
+		if err == data_store.ErrAlreadyQueued {
+			handleError(w, ctx, srv, "reserve_book", err, http.StatusBadRequest)
+			return
+		}
+		if err == data_store.ErrAccountInactive || err == data_store.ErrFinesOutstanding {
+			handleError(w, ctx, srv, "reserve_book", err, http.StatusForbidden)
+			return
+		}
 		handleError(w, ctx, srv, "reserve_book", err, http.StatusInternalServerError)
 		return
 	}
@@ -216,11 +241,6 @@ func (srv *Server) reserveBook(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) adminConfirmReturnBook(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "return_book", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	bookID, err := strconv.Atoi(id)
 	user := r.FormValue("userId")
@@ -229,7 +249,8 @@ func (srv *Server) adminConfirmReturnBook(wr http.ResponseWriter, r *http.Reques
 		handleError(w, ctx, srv, "return_book", err, http.StatusInternalServerError)
 		return
 	}
-	err = srv.DB.AdminConfirmReturnBook(uint(bookID), uint(userID))
+	pickupDeadline := srv.holdPickupDeadline()
+	err = srv.DB.AdminConfirmReturnBook(uint(bookID), uint(userID), &pickupDeadline, srv.Env.FineRatePerDay)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			handleError(w, ctx, srv, "return_book", errors.New("no record found"), http.StatusOK)
@@ -249,6 +270,66 @@ func (srv *Server) adminConfirmReturnBook(wr http.ResponseWriter, r *http.Reques
 	}
 }
 
+func (srv *Server) renewBook(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "renew_book", err, http.StatusBadRequest)
+		return
+	}
+	userID, ok := srv.actingUser(w, r, "renew_book", r.FormValue("userId"))
+	if !ok {
+		return
+	}
+	returnDate, err := time.Parse("2006-01-02", r.FormValue("returnDate"))
+	if err != nil {
+		handleError(w, ctx, srv, "renew_book", err, http.StatusBadRequest)
+		return
+	}
+	history, err := srv.DB.RenewBook(uint(bookID), uint(userID), &returnDate, srv.Env.MaxRenewals, srv.Env.MaxLoanDays)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "renew_book", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrAccountInactive:
+			handleError(w, ctx, srv, "renew_book", err, http.StatusForbidden)
+		case data_store.ErrHoldsWaiting:
+			handleError(w, ctx, srv, "renew_book", err, http.StatusConflict)
+		case data_store.ErrRenewalLimit, data_store.ErrLoanTooLong, data_store.ErrRenewalDate:
+			handleError(w, ctx, srv, "renew_book", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "renew_book", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(history)
+	if err != nil {
+		handleError(w, ctx, srv, "renew_book", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getHistoryEvents(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_history_events", err, http.StatusBadRequest)
+		return
+	}
+	events, err := srv.DB.GetHistoryEvents(uint(bookID))
+	if err != nil {
+		handleError(w, ctx, srv, "get_history_events", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(events)
+	if err != nil {
+		handleError(w, ctx, srv, "get_history_events", err, http.StatusInternalServerError)
+	}
+}
+
 func (srv *Server) studentReturnBook(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
@@ -259,15 +340,21 @@ func (srv *Server) studentReturnBook(wr http.ResponseWriter, r *http.Request) {
 		handleError(w, ctx, srv, "student_return_book", err, http.StatusInternalServerError)
 		return
 	}
-	userID, err := strconv.Atoi(user)
+	userID, ok := srv.actingUser(w, r, "student_return_book", user)
+	if !ok {
+		return
+	}
+	loan, err := srv.DB.GetOpenLoan(uint(bookID), uint(userID))
 	if err != nil {
+		if err == data_store.ErrNoOpenLoan {
+			handleError(w, ctx, srv, "student_return_book", err, http.StatusNotFound)
+			return
+		}
 		handleError(w, ctx, srv, "student_return_book", err, http.StatusInternalServerError)
 		return
 	}
 	returnDate := time.Now()
-	books, err := srv.DB.GetHistory(uint(bookID))
-	reservedDate := (*books)[0].ReservedDate
-	err = srv.DB.StudentReturnBook(uint(bookID), uint(userID), reservedDate, &returnDate)
+	err = srv.DB.StudentReturnBook(uint(bookID), uint(userID), loan.ReservedDate, &returnDate)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			handleError(w, ctx, srv, "student_return_book", errors.New("no record found"), http.StatusOK)
@@ -285,39 +372,44 @@ func (srv *Server) studentReturnBook(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) updateBookOverdue(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "update_book_overdue", errors.New("permission denied"), http.StatusUnauthorized)
+	update, err := srv.runOverdueJob(0)
+	if err != nil {
+		if err == data_store.ErrJobLocked {
+			handleError(w, ctx, srv, "update_book_overdue", err, http.StatusConflict)
+			return
+		}
+		handleError(w, ctx, srv, "update_book_overdue", err, http.StatusInternalServerError)
 		return
 	}
-	currentTime := time.Now()
-	err := srv.DB.UpdateBookOverdue(&currentTime)
+	err = json.NewEncoder(w).Encode(update)
+	if err != nil {
+		handleError(w, ctx, srv, "update_book_overdue", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getOverdueJobLastRun(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	run, err := srv.DB.GetLastJobRun(models.OverdueJob)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			handleError(w, ctx, srv, "update_book_overdue", errors.New("no record found"), http.StatusOK)
+			handleError(w, ctx, srv, "get_overdue_job_last_run", errors.New("no record found"), http.StatusOK)
 			return
 		}
-		handleError(w, ctx, srv, "update_book_overdue", err, http.StatusInternalServerError)
+		handleError(w, ctx, srv, "get_overdue_job_last_run", err, http.StatusInternalServerError)
 		return
 	}
-	err = json.NewEncoder(w).Encode("Updated overdue books successfully!")
+	err = json.NewEncoder(w).Encode(run)
 	if err != nil {
-		handleError(w, ctx, srv, "update_book_overdue", err, http.StatusInternalServerError)
+		handleError(w, ctx, srv, "get_overdue_job_last_run", err, http.StatusInternalServerError)
 	}
 }
 
 func (srv *Server) getBooksStudentOverdue(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	// authInfo := GetAuthInfoFromContext(ctx)
-	// if authInfo.Role != models.AdminAccount {
-	// 	handleError(w, ctx, srv, "get_book_overdue_of_student", errors.New("permission denied"), http.StatusUnauthorized)
-	// 	return
-	// }
-	userId := chi.URLParam(r, "id")
-	userID, err := strconv.Atoi(userId)
-	if err != nil {
-		handleError(w, ctx, srv, "get_book_overdue_of_student", err, http.StatusInternalServerError)
+	userID, ok := srv.actingUser(w, r, "get_book_overdue_of_student", chi.URLParam(r, "id"))
+	if !ok {
 		return
 	}
 	history, err := srv.DB.GetBooksStudentOverdue(uint(userID))
@@ -338,15 +430,8 @@ func (srv *Server) getBooksStudentOverdue(wr http.ResponseWriter, r *http.Reques
 func (srv *Server) getBooksStudentReserved(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	// authInfo := GetAuthInfoFromContext(ctx)
-	// if authInfo.Role != models.AdminAccount {
-	// 	handleError(w, ctx, srv, "get_book_reserved_of_student", errors.New("permission denied"), http.StatusUnauthorized)
-	// 	return
-	// }
-	userId := chi.URLParam(r, "id")
-	userID, err := strconv.Atoi(userId)
-	if err != nil {
-		handleError(w, ctx, srv, "get_book_reserved_of_student", err, http.StatusInternalServerError)
+	userID, ok := srv.actingUser(w, r, "get_book_reserved_of_student", chi.URLParam(r, "id"))
+	if !ok {
 		return
 	}
 	history, err := srv.DB.GetBooksStudentReserved(uint(userID))
@@ -367,11 +452,6 @@ func (srv *Server) getBooksStudentReserved(wr http.ResponseWriter, r *http.Reque
 func (srv *Server) deleteBook(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "delete_book", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	bookID, err := strconv.Atoi(id)
 	if err != nil {
@@ -396,17 +476,10 @@ func (srv *Server) deleteBook(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) updateBook(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "update_name_of_book", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	bookID, _ := strconv.Atoi(id)
 	bookName := r.FormValue("name")
-	isbn := r.FormValue("isbn")
-	stock := r.FormValue("stock")
-	stockInt, _ := strconv.Atoi(stock)
+	bookISBN := r.FormValue("isbn")
 	author := r.FormValue("author")
 	year := r.FormValue("year")
 	edition := r.FormValue("edition")
@@ -414,12 +487,17 @@ func (srv *Server) updateBook(wr http.ResponseWriter, r *http.Request) {
 	cover := r.FormValue("cover")
 	abstract := r.FormValue("abstract")
 	category := r.FormValue("category")
-	rating := r.FormValue("rating")
-	ratingInt, _ := strconv.Atoi(rating)
 
-	err := srv.DB.UpdateBook(uint(bookID), bookName, isbn, uint(stockInt), author, year, uint(editionInt), cover, abstract, category, uint(ratingInt))
-	if err != nil {
-		handleError(w, ctx, srv, "update_name_of_book", err, http.StatusInternalServerError)
+	err := srv.DB.UpdateBook(uint(bookID), bookName, bookISBN, author, year, uint(editionInt), cover, abstract, category)
+	if err != nil {
+		switch err {
+		case isbn.ErrInvalidFormat, isbn.ErrInvalidChecksum:
+			handleError(w, ctx, srv, "update_name_of_book", err, http.StatusBadRequest)
+		case data_store.ErrDuplicateISBN:
+			handleError(w, ctx, srv, "update_name_of_book", err, http.StatusConflict)
+		default:
+			handleError(w, ctx, srv, "update_name_of_book", err, http.StatusInternalServerError)
+		}
 		return
 	}
 	err = json.NewEncoder(w).Encode("Book updated successfully!")
@@ -450,11 +528,6 @@ func handleError(w *middleware.LogResponseWriter, ctx context.Context, srv *Serv
 func (srv *Server) getAllBooksStudentReturned(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_all_book_student_return", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 
 	bookReturnByStudent, err := srv.DB.GetAllBooksStudentReturned()
 	if err != nil {
@@ -474,11 +547,6 @@ func (srv *Server) getAllBooksStudentReturned(wr http.ResponseWriter, r *http.Re
 func (srv *Server) getBooksStudentReturned(wr http.ResponseWriter, r *http.Request) {
 	w := middleware.NewLogResponseWriter(wr)
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_all_book_student_return", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	bookID, _ := strconv.Atoi(id)
 
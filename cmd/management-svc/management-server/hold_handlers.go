@@ -0,0 +1,138 @@
+package management_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+)
+
+func (srv *Server) holdPickupDeadline() time.Time {
+	return time.Now().AddDate(0, 0, srv.Env.HoldPickupDays)
+}
+
+func (srv *Server) getHoldsByStudent(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	userID, ok := srv.actingUser(w, r, "get_holds_of_student", chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	holds, err := srv.DB.GetHoldsByUser(uint(userID))
+	if err != nil {
+		handleError(w, ctx, srv, "get_holds_of_student", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(holds)
+	if err != nil {
+		handleError(w, ctx, srv, "get_holds_of_student", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getQueuePosition(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_queue_position", err, http.StatusBadRequest)
+		return
+	}
+	userID, ok := srv.actingUser(w, r, "get_queue_position", r.FormValue("userId"))
+	if !ok {
+		return
+	}
+	position, err := srv.DB.GetQueuePosition(uint(bookID), uint(userID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "get_queue_position", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "get_queue_position", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(position)
+	if err != nil {
+		handleError(w, ctx, srv, "get_queue_position", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) cancelHold(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	holdID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "cancel_hold", err, http.StatusBadRequest)
+		return
+	}
+	userID, ok := srv.actingUser(w, r, "cancel_hold", r.FormValue("userId"))
+	if !ok {
+		return
+	}
+	pickupDeadline := srv.holdPickupDeadline()
+	err = srv.DB.CancelHold(uint(holdID), uint(userID), &pickupDeadline)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "cancel_hold", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrHoldNotAllowed:
+			handleError(w, ctx, srv, "cancel_hold", err, http.StatusForbidden)
+		case data_store.ErrHoldNotActive:
+			handleError(w, ctx, srv, "cancel_hold", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "cancel_hold", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode("Hold cancelled successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "cancel_hold", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) getBookQueue(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_queue", err, http.StatusBadRequest)
+		return
+	}
+	holds, err := srv.DB.GetBookQueue(uint(bookID))
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_queue", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(holds)
+	if err != nil {
+		handleError(w, ctx, srv, "get_book_queue", err, http.StatusInternalServerError)
+	}
+}
+
+// expireHolds runs the hold expiry job at once; the scheduler runs it on
+// every OverdueJobInterval.
+func (srv *Server) expireHolds(wr http.ResponseWriter, r *http.Request) {
+	w := middleware.NewLogResponseWriter(wr)
+	ctx := r.Context()
+	run, err := srv.runExpireHoldsJob(0)
+	if err != nil {
+		if err == data_store.ErrJobLocked {
+			handleError(w, ctx, srv, "expire_holds", err, http.StatusConflict)
+			return
+		}
+		handleError(w, ctx, srv, "expire_holds", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(map[string]int{"expired": run.Updated})
+	if err != nil {
+		handleError(w, ctx, srv, "expire_holds", err, http.StatusInternalServerError)
+	}
+}
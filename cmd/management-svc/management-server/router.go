@@ -4,6 +4,7 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
 	"github.com/library/middleware"
+	"github.com/library/models"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -24,17 +25,32 @@ func SetupRouter(srv *Server, prom *prometheus.Registry) *chi.Mux {
 
 	r.Route("/admin", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
-		r.Get("/complete-history", srv.getCompleteHistory)
-		r.Get("/get-history/{id}", srv.getHistory)
-		r.Get("/borrowed-history", srv.getBorrowHistory)
-		r.Get("/returned-history", srv.getReturnHistory)
-		r.Get("/overdue-history", srv.getOverdueHistory)
-		r.Get("/student-return-books", srv.getAllBooksStudentReturned)
-		r.Get("/student-return-book/{id}", srv.getBooksStudentReturned)
-		r.Post("/confirm-return-book/{id}", srv.adminConfirmReturnBook)
-		r.Delete("/delete-book/{id}", srv.deleteBook)
-		r.Put("/update-book/{id}", srv.updateBook)
-		r.Get("/update-book-overdue", srv.updateBookOverdue)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermReportsRead))
+			r.Get("/complete-history", srv.getCompleteHistory)
+			r.Get("/get-history/{id}", srv.getHistory)
+			r.Get("/get-history-events/{id}", srv.getHistoryEvents)
+			r.Get("/borrowed-history", srv.getBorrowHistory)
+			r.Get("/returned-history", srv.getReturnHistory)
+			r.Get("/overdue-history", srv.getOverdueHistory)
+			r.Get("/student-return-books", srv.getAllBooksStudentReturned)
+			r.Get("/student-return-book/{id}", srv.getBooksStudentReturned)
+			r.Get("/overdue-job/last-run", srv.getOverdueJobLastRun)
+			r.Get("/book-queue/{id}", srv.getBookQueue)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermLoansConfirm))
+			r.Post("/confirm-return-book/{id}", srv.adminConfirmReturnBook)
+			r.Post("/update-book-overdue", srv.updateBookOverdue)
+			r.Post("/expire-holds", srv.expireHolds)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermFinesManage))
+			r.Post("/fine-payment/{id}", srv.recordFinePayment)
+			r.Post("/fine-waiver/{id}", srv.waiveFine)
+		})
+		r.With(middleware.RequirePermission(models.PermCatalogWrite)).Put("/update-book/{id}", srv.updateBook)
+		r.With(middleware.RequirePermission(models.PermCatalogDelete)).Delete("/delete-book/{id}", srv.deleteBook)
 	})
 	r.Route("/user", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
@@ -42,7 +58,12 @@ func SetupRouter(srv *Server, prom *prometheus.Registry) *chi.Mux {
 		r.Get("/get-book-reserved-by-student/{id}", srv.getBooksStudentReserved)
 		r.Post("/reserve-book/{id}", srv.reserveBook)
 		r.Post("/return-book/{id}", srv.studentReturnBook)
+		r.Post("/renew-book/{id}", srv.renewBook)
 		r.Get("/check-availability/{id}", srv.checkAvailability)
+		r.Get("/get-holds-by-student/{id}", srv.getHoldsByStudent)
+		r.Get("/queue-position/{id}", srv.getQueuePosition)
+		r.Post("/cancel-hold/{id}", srv.cancelHold)
+		r.Get("/get-fines-by-student/{id}", srv.getFinesByStudent)
 	})
 	r.Get("/health", srv.health())
 	r.Handle("/metrics", promhttp.HandlerFor(prom, promhttp.HandlerOpts{}))
@@ -0,0 +1,146 @@
+package management_server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/models"
+	"github.com/sirupsen/logrus"
+)
+
+// errJobNotDue skips a scheduled run when another replica ran the job less
+// than an interval ago.
+var errJobNotDue = errors.New("job ran recently on another instance")
+
+// RunOverdueScheduler marks overdue loans and expires holds that were not
+// picked up on start and then on every OverdueJobInterval until ctx is
+// cancelled. Every replica runs the scheduler; the job locks keep runs from
+// overlapping and the last recorded run keeps replicas whose tickers are out
+// of step from running a job more than once per interval.
+func (srv *Server) RunOverdueScheduler(ctx context.Context) {
+	interval := srv.Env.OverdueJobInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		update, err := srv.runOverdueJob(interval)
+		var run *models.JobRun
+		if update != nil {
+			run = update.Run
+		}
+		logJobRun(models.OverdueJob, run, err)
+		run, err = srv.runExpireHoldsJob(interval)
+		logJobRun(models.ExpireHoldsJob, run, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func logJobRun(job string, run *models.JobRun, err error) {
+	switch {
+	case err == data_store.ErrJobLocked:
+		logrus.WithFields(logrus.Fields{
+			"job": job,
+		}).Info("job running on another instance, skipping")
+	case err == errJobNotDue:
+		logrus.WithFields(logrus.Fields{
+			"job": job,
+		}).Info("job ran recently on another instance, skipping")
+	case err != nil:
+		logrus.WithFields(logrus.Fields{
+			"job":   job,
+			"error": err,
+		}).Error("job run failed")
+	default:
+		logrus.WithFields(logrus.Fields{
+			"job":     job,
+			"updated": run.Updated,
+		}).Info("job run finished")
+	}
+}
+
+// runJob runs work under the lock of job and records the run. work returns
+// how many rows it changed. With a minInterval the run is skipped with
+// errJobNotDue when the last successful run started less than that long ago;
+// a tenth of it is allowed as slack, since a replica's own ticker fires a
+// little less than an interval after the run it recorded.
+func (srv *Server) runJob(job string, minInterval time.Duration, work func() (int, error)) (*models.JobRun, error) {
+	release, err := srv.DB.AcquireJobLock(job)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if minInterval > 0 {
+		last, err := srv.DB.GetLastJobRun(job)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		if err == nil && last.Status == models.JobSucceeded &&
+			time.Since(last.StartedAt) < minInterval-minInterval/10 {
+			return nil, errJobNotDue
+		}
+	}
+	host, _ := os.Hostname()
+	run, err := srv.DB.StartJobRun(job, host)
+	if err != nil {
+		return nil, err
+	}
+	updated, jobErr := work()
+	err = srv.DB.FinishJobRun(run, updated, jobErr)
+	observeJobRun(run)
+	if jobErr != nil {
+		return run, jobErr
+	}
+	return run, err
+}
+
+// runOverdueJob runs UpdateBookOverdue as the overdue job.
+func (srv *Server) runOverdueJob(minInterval time.Duration) (*models.OverdueUpdate, error) {
+	update := &models.OverdueUpdate{Loans: []models.BookHistory{}}
+	run, err := srv.runJob(models.OverdueJob, minInterval, func() (int, error) {
+		currentTime := time.Now()
+		loans, err := srv.DB.UpdateBookOverdue(&currentTime)
+		if err != nil {
+			return 0, err
+		}
+		update.Loans = *loans
+		return len(update.Loans), nil
+	})
+	if run == nil {
+		return nil, err
+	}
+	update.Run = run
+	return update, err
+}
+
+// runExpireHoldsJob expires ready holds whose pickup deadline has passed and
+// hands their copies to the next reader in line.
+func (srv *Server) runExpireHoldsJob(minInterval time.Duration) (*models.JobRun, error) {
+	return srv.runJob(models.ExpireHoldsJob, minInterval, func() (int, error) {
+		currentTime := time.Now()
+		pickupDeadline := srv.holdPickupDeadline()
+		return srv.DB.ExpireHolds(&currentTime, &pickupDeadline)
+	})
+}
+
+func observeJobRun(run *models.JobRun) {
+	if jobMetrics == nil {
+		return
+	}
+	jobMetrics.RunCounter.WithLabelValues(run.Job, run.Status).Inc()
+	if run.FinishedAt != nil {
+		jobMetrics.LastRunTimestamp.WithLabelValues(run.Job).Set(float64(run.FinishedAt.Unix()))
+	}
+	jobMetrics.LastRunUpdated.WithLabelValues(run.Job).Set(float64(run.Updated))
+	success := 0.0
+	if run.Status == models.JobSucceeded {
+		success = 1
+	}
+	jobMetrics.LastRunSuccess.WithLabelValues(run.Job).Set(success)
+}
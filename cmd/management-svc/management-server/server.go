@@ -1,6 +1,12 @@
 package management_server
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/fluent/fluent-logger-golang/fluent"
 	datastore "github.com/library/data-store"
 	"github.com/library/envConfig"
@@ -10,9 +16,13 @@ import (
 	"net/http"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish.
+const shutdownTimeout = 10 * time.Second
+
 var (
 	prom        *prometheus.Registry
 	promMetrics *metrics.Metrics
+	jobMetrics  *metrics.JobMetrics
 )
 
 type Server struct {
@@ -40,14 +50,58 @@ func (srv *Server) ListenAndServe(service string, port string) error {
 	promMetrics = metrics.NewMetrics("management_svc")
 	prom.MustRegister(promMetrics.RequestCounter)
 	prom.MustRegister(promMetrics.LatencyCalculator)
+	jobMetrics = metrics.NewJobMetrics("management_svc")
+	prom.MustRegister(jobMetrics.RunCounter)
+	prom.MustRegister(jobMetrics.LastRunTimestamp)
+	prom.MustRegister(jobMetrics.LastRunUpdated)
+	prom.MustRegister(jobMetrics.LastRunSuccess)
+
+	// the scheduler stops with the server, so no job starts during shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	schedulerDone := make(chan struct{})
+	if srv.Env.OverdueJobInterval > 0 {
+		go func() {
+			defer close(schedulerDone)
+			srv.RunOverdueScheduler(ctx)
+		}()
+	} else {
+		close(schedulerDone)
+	}
 
 	r := SetupRouter(srv, prom)
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"service": service,
+		}).Info("shutting down")
+		cancel()
+		shutdownCtx, done := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer done()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("server shutdown")
+		}
+	}()
 	logrus.WithFields(logrus.Fields{
 		"service": service,
 	}).Info(service+" binding on ", ":"+port)
 
-	err := http.ListenAndServe(":"+port, r)
-	if err != nil {
+	err := server.ListenAndServe()
+	cancel()
+	// let a job that is already running finish and record its run
+	<-schedulerDone
+	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
 	return nil
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/chi"
 	"github.com/kelseyhightower/envconfig"
 	management_server "github.com/library/cmd/management-svc/management-server"
@@ -36,6 +39,7 @@ var _ = Describe("Management-Service", func() {
 		Expect(err).To(BeNil())
 		srv.TestRun = true
 		middleware.SetJwtSigningKey(srv.Env.JwtSigningKey)
+		middleware.SetPermissionSource(dataStore)
 		adminToken, userToken, err = setupAuthInfo(env, dataStore.Db)
 		Expect(err).To(BeNil())
 		r = management_server.SetupRouter(srv, nil)
@@ -110,6 +114,496 @@ var _ = Describe("Management-Service", func() {
 				Expect(err).To(BeNil())
 			})
 		})
+
+		Describe("Hold Queue", func() {
+			It("Should return the holds placed by the student", func() {
+				req := httptest.NewRequest(http.MethodGet, "/user/get-holds-by-student/1010", nil)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var holds []models.BookQueue
+				err = json.NewDecoder(resp.Body).Decode(&holds)
+				Expect(err).To(BeNil())
+			})
+
+			It("Should deny the book queue to non admin users", func() {
+				req := httptest.NewRequest(http.MethodGet, "/admin/book-queue/1010", nil)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusUnauthorized))
+			})
+		})
+
+		Describe("Hold Lifecycle", func() {
+			const bookID = 1013
+			BeforeEach(func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: bookID},
+					Name:      "holdBook",
+					Stock:     1,
+				})
+				Expect(err).To(BeNil())
+				for _, id := range []uint{2030, 2031} {
+					err = dataStore.Db.Create(&models.Account{
+						BaseModel:   models.BaseModel{ID: id},
+						Email:       fmt.Sprintf("unit%d@user.com", id),
+						AccountRole: models.UserAccount,
+					}).Error
+					Expect(err).To(BeNil())
+				}
+			})
+
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book where id = ?`, bookID).Error
+				Expect(err).To(BeNil())
+				err = dataStore.Db.Exec(`delete from account where id in (?)`, []uint{2030, 2031}).Error
+				Expect(err).To(BeNil())
+			})
+
+			post := func(target string, form url.Values) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+			reserve := func(userID uint) error {
+				reservedDate := time.Now()
+				returnDate := reservedDate.AddDate(0, 0, 14)
+				return dataStore.ReserveBook(bookID, userID, &reservedDate, &returnDate,
+					srv.Env.FineRatePerDay, srv.Env.FineBlockThreshold)
+			}
+			holdOf := func(userID uint) *models.BookQueue {
+				hold := &models.BookQueue{}
+				err := dataStore.Db.Where("book_id = ? and user_id = ?", bookID, userID).Order("id desc").First(hold).Error
+				Expect(err).To(BeNil())
+				return hold
+			}
+			copyStatus := func(copyID uint) string {
+				bookCopy, err := dataStore.GetBookCopyByID(copyID)
+				Expect(err).To(BeNil())
+				return bookCopy.Status
+			}
+			// lendAndQueue lends the only copy to 1010, queues 2030 and 2031
+			// behind it and returns the copy, which is then held for 2030
+			lendAndQueue := func() *models.BookQueue {
+				Expect(reserve(1010)).To(BeNil())
+				Expect(reserve(2030)).To(Equal(data_store.ErrBookQueued))
+				Expect(reserve(2031)).To(Equal(data_store.ErrBookQueued))
+				rec := post(fmt.Sprintf("/admin/confirm-return-book/%d", bookID), url.Values{"userId": {"1010"}})
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				ready := holdOf(2030)
+				Expect(ready.Status).To(Equal(models.HoldReady))
+				Expect(ready.CopyID).NotTo(BeZero())
+				Expect(ready.PickupDeadline).NotTo(BeNil())
+				Expect(copyStatus(ready.CopyID)).To(Equal(models.CopyHeld))
+				Expect(holdOf(2031).Status).To(Equal(models.HoldWaiting))
+				return ready
+			}
+
+			It("Should set a returned copy aside for the first waiting reader", func() {
+				ready := lendAndQueue()
+				available, err := dataStore.CheckAvailability(bookID)
+				Expect(err).To(BeNil())
+				Expect(available).To(BeFalse())
+				// the copy set aside for 2030 cannot go to the reader behind
+				Expect(reserve(2031)).To(Equal(data_store.ErrAlreadyQueued))
+
+				Expect(reserve(2030)).To(BeNil())
+				Expect(holdOf(2030).Status).To(Equal(models.HoldFulfilled))
+				Expect(copyStatus(ready.CopyID)).To(Equal(models.CopyBorrowed))
+			})
+
+			It("Should pass an uncollected copy to the next reader after the pickup deadline", func() {
+				ready := lendAndQueue()
+				past := time.Now().Add(-time.Hour)
+				err = dataStore.Db.Model(&models.BookQueue{}).Where("id = ?", ready.ID).
+					Update("pickupDeadline", past).Error
+				Expect(err).To(BeNil())
+
+				rec := post("/admin/expire-holds", nil)
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				var result map[string]int
+				Expect(json.NewDecoder(rec.Body).Decode(&result)).To(BeNil())
+				Expect(result["expired"]).To(BeNumerically(">=", 1))
+
+				Expect(holdOf(2030).Status).To(Equal(models.HoldExpired))
+				next := holdOf(2031)
+				Expect(next.Status).To(Equal(models.HoldReady))
+				Expect(next.CopyID).To(Equal(ready.CopyID))
+				Expect(copyStatus(ready.CopyID)).To(Equal(models.CopyHeld))
+			})
+
+			It("Should pass the copy on when a ready hold is cancelled", func() {
+				ready := lendAndQueue()
+				rec := post(fmt.Sprintf("/user/cancel-hold/%d", ready.ID), url.Values{"userId": {"2030"}})
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				Expect(holdOf(2030).Status).To(Equal(models.HoldCancelled))
+				next := holdOf(2031)
+				Expect(next.Status).To(Equal(models.HoldReady))
+				Expect(next.CopyID).To(Equal(ready.CopyID))
+
+				// with nobody left in line the copy goes back on the shelf
+				rec = post(fmt.Sprintf("/user/cancel-hold/%d", next.ID), url.Values{"userId": {"2031"}})
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				Expect(copyStatus(ready.CopyID)).To(Equal(models.CopyAvailable))
+				available, err := dataStore.CheckAvailability(bookID)
+				Expect(err).To(BeNil())
+				Expect(available).To(BeTrue())
+			})
+		})
+
+		Describe("Renew Book", func() {
+			renew := func(returnDate string) *http.Response {
+				formData := url.Values{
+					"userId":     {"1010"},
+					"returnDate": {returnDate},
+				}
+				req := httptest.NewRequest(http.MethodPost, "/user/renew-book/1011", strings.NewReader(formData.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Result()
+			}
+
+			BeforeEach(func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: 1011},
+					Name:      "renewBook",
+					Stock:     1,
+				})
+				Expect(err).To(BeNil())
+				reservedDate := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+				returnDate := reservedDate.AddDate(0, 0, 14)
+				err = dataStore.ReserveBook(1011, 1010, &reservedDate, &returnDate,
+					srv.Env.FineRatePerDay, srv.Env.FineBlockThreshold)
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				err = dataStore.Db.Exec(`delete from book where id = ?`, 1011).Error
+				Expect(err).To(BeNil())
+			})
+
+			It("Should extend the loan within the six week ceiling", func() {
+				resp := renew("2019-10-29")
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var history models.BookHistory
+				err = json.NewDecoder(resp.Body).Decode(&history)
+				Expect(err).To(BeNil())
+				Expect(history.Renewals).To(BeEquivalentTo(1))
+
+				resp = renew("2019-11-20")
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+
+			It("Should refuse a return date that is not later than the current one", func() {
+				Expect(renew("2019-10-15").StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+				Expect(renew("2019-10-10").StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+
+			It("Should stop renewing once the renewal limit is reached", func() {
+				returnDate := time.Date(2019, 10, 15, 0, 0, 0, 0, time.UTC)
+				for i := 0; i < srv.Env.MaxRenewals; i++ {
+					returnDate = returnDate.AddDate(0, 0, 1)
+					Expect(renew(returnDate.Format("2006-01-02")).StatusCode).To(BeEquivalentTo(http.StatusOK))
+				}
+				returnDate = returnDate.AddDate(0, 0, 1)
+				Expect(renew(returnDate.Format("2006-01-02")).StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+
+				history := &models.BookHistory{}
+				err = dataStore.Db.Where("book_id = ? and user_id = ?", 1011, 1010).First(history).Error
+				Expect(err).To(BeNil())
+				Expect(history.Renewals).To(BeEquivalentTo(srv.Env.MaxRenewals))
+			})
+
+			It("Should not renew while another reader is waiting for the book", func() {
+				err = dataStore.Db.Create(&models.Account{
+					BaseModel:   models.BaseModel{ID: 2032},
+					Email:       "unit2032@user.com",
+					AccountRole: models.UserAccount,
+				}).Error
+				Expect(err).To(BeNil())
+				defer dataStore.Db.Exec(`delete from account where id = ?`, 2032)
+				reservedDate := time.Now()
+				returnDate := reservedDate.AddDate(0, 0, 14)
+				err = dataStore.ReserveBook(1011, 2032, &reservedDate, &returnDate,
+					srv.Env.FineRatePerDay, srv.Env.FineBlockThreshold)
+				Expect(err).To(Equal(data_store.ErrBookQueued))
+
+				Expect(renew("2019-10-29").StatusCode).To(BeEquivalentTo(http.StatusConflict))
+			})
+
+			It("Should not renew for a suspended reader", func() {
+				Expect(dataStore.SuspendAccount(1010, "lost books", nil, time.Now())).To(BeNil())
+				defer func() {
+					Expect(dataStore.ReactivateAccount(1010)).To(BeNil())
+				}()
+				Expect(renew("2019-10-29").StatusCode).To(BeEquivalentTo(http.StatusForbidden))
+			})
+		})
+
+		Describe("Resource ownership", func() {
+			send := func(method, target, token string, form url.Values) int {
+				req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			It("Should not let a reader act for another reader", func() {
+				Expect(send(http.MethodGet, "/user/get-fines-by-student/2020", userToken, nil)).
+					To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(send(http.MethodGet, "/user/get-book-reserved-by-student/2020", userToken, nil)).
+					To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(send(http.MethodGet, "/user/get-holds-by-student/2020", userToken, nil)).
+					To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(send(http.MethodPost, "/user/reserve-book/1010", userToken, url.Values{
+					"userId":       {"2020"},
+					"reservedDate": {"2019-10-01"},
+					"returnDate":   {"2019-10-15"},
+				})).To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(send(http.MethodPost, "/user/cancel-hold/1", userToken, url.Values{
+					"userId": {"2020"},
+				})).To(BeEquivalentTo(http.StatusUnauthorized))
+			})
+
+			It("Should act for the caller when no user is named", func() {
+				Expect(send(http.MethodGet, "/user/queue-position/1010", userToken, nil)).
+					To(BeEquivalentTo(http.StatusOK))
+			})
+
+			It("Should let an admin act for any reader", func() {
+				Expect(send(http.MethodGet, "/user/get-fines-by-student/1010", adminToken, nil)).
+					To(BeEquivalentTo(http.StatusOK))
+			})
+
+			It("Should only return a book the reader has on loan", func() {
+				err = dataStore.CreateBook(models.Book{
+					BaseModel: models.BaseModel{ID: 1014},
+					Name:      "returnBook",
+					Stock:     1,
+				})
+				Expect(err).To(BeNil())
+				defer func() {
+					dataStore.Db.Exec(`delete from student_return_book where book_id = ?`, 1014)
+					dataStore.Db.Exec(`delete from book where id = ?`, 1014)
+				}()
+				Expect(send(http.MethodPost, "/user/return-book/1014", userToken, nil)).
+					To(BeEquivalentTo(http.StatusNotFound))
+
+				reservedDate := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+				returnDate := reservedDate.AddDate(0, 0, 14)
+				err = dataStore.ReserveBook(1014, 1010, &reservedDate, &returnDate,
+					srv.Env.FineRatePerDay, srv.Env.FineBlockThreshold)
+				Expect(err).To(BeNil())
+				Expect(send(http.MethodPost, "/user/return-book/1014", userToken, nil)).
+					To(BeEquivalentTo(http.StatusOK))
+				returned := &models.StudentReturnBook{}
+				err = dataStore.Db.Where("book_id = ? and user_id = ?", 1014, 1010).First(returned).Error
+				Expect(err).To(BeNil())
+				loan, err := dataStore.GetOpenLoan(1014, 1010)
+				Expect(err).To(BeNil())
+				Expect(returned.ReservedDate.Equal(*loan.ReservedDate)).To(BeTrue())
+			})
+
+			It("Should not let a suspended reader borrow", func() {
+				Expect(dataStore.SuspendAccount(1010, "lost books", nil, time.Now())).To(BeNil())
+				defer func() {
+					Expect(dataStore.ReactivateAccount(1010)).To(BeNil())
+				}()
+				Expect(send(http.MethodPost, "/user/reserve-book/1010", userToken, url.Values{
+					"reservedDate": {"2019-10-01"},
+					"returnDate":   {"2019-10-15"},
+				})).To(BeEquivalentTo(http.StatusForbidden))
+			})
+		})
+
+		Describe("Librarian role", func() {
+			var librarianToken string
+
+			BeforeEach(func() {
+				librarianToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+					"id":   2021,
+					"role": models.LibrarianAccount,
+					"exp":  time.Now().Add(time.Hour).Unix(),
+				}).SignedString([]byte(env.JwtSigningKey))
+				Expect(err).To(BeNil())
+			})
+
+			request := func(method, target string) int {
+				req := httptest.NewRequest(method, target, nil)
+				req.Header.Set("Authorization", "Bearer "+librarianToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			It("Should read loan reports and act for readers", func() {
+				Expect(request(http.MethodGet, "/admin/borrowed-history")).To(BeEquivalentTo(http.StatusOK))
+				Expect(request(http.MethodGet, "/user/get-fines-by-student/1010")).To(BeEquivalentTo(http.StatusOK))
+			})
+
+			It("Should not delete books", func() {
+				Expect(request(http.MethodDelete, "/admin/delete-book/1010")).To(BeEquivalentTo(http.StatusUnauthorized))
+				var book models.Book
+				err = dataStore.Db.Where("id = ?", 1010).First(&book).Error
+				Expect(err).To(BeNil())
+			})
+		})
+
+		Describe("Fines", func() {
+			It("Should return the fine balance of the student", func() {
+				req := httptest.NewRequest(http.MethodGet, "/user/get-fines-by-student/1010", nil)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var balance models.FineBalance
+				err = json.NewDecoder(resp.Body).Decode(&balance)
+				Expect(err).To(BeNil())
+				Expect(balance.UserID).To(BeEquivalentTo(1010))
+			})
+
+			It("Should not lend to a reader whose fines exceed the limit", func() {
+				fine := &models.Fine{
+					UserID: 1010,
+					Kind:   models.FineCharge,
+					Amount: srv.Env.FineBlockThreshold + 1,
+					Note:   "unit test",
+				}
+				err = dataStore.Db.Create(fine).Error
+				Expect(err).To(BeNil())
+				defer dataStore.Db.Delete(fine)
+				countLoans := func() int {
+					var loans int
+					err := dataStore.Db.Model(&models.BookHistory{}).Where("user_id = ?", 1010).Count(&loans).Error
+					Expect(err).To(BeNil())
+					return loans
+				}
+				loansBefore := countLoans()
+
+				formData := url.Values{
+					"reservedDate": {"2019-10-01"},
+					"returnDate":   {"2019-10-15"},
+				}
+				req := httptest.NewRequest(http.MethodPost, "/user/reserve-book/1010", strings.NewReader(formData.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+userToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusForbidden))
+				Expect(countLoans()).To(Equal(loansBefore))
+			})
+
+			It("Should not accept a payment larger than the outstanding balance", func() {
+				formData := url.Values{
+					"amount": {"100000"},
+				}
+				req := httptest.NewRequest(http.MethodPost, "/admin/fine-payment/1010", strings.NewReader(formData.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusBadRequest))
+			})
+		})
+
+		Describe("Overdue Job", func() {
+			It("Should record the run and report it as the last run", func() {
+				req := httptest.NewRequest(http.MethodPost, "/admin/update-book-overdue", nil)
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp := rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var update models.OverdueUpdate
+				err = json.NewDecoder(resp.Body).Decode(&update)
+				Expect(err).To(BeNil())
+				Expect(update.Run.Status).To(BeEquivalentTo(models.JobSucceeded))
+				Expect(update.Run.Updated).To(BeEquivalentTo(len(update.Loans)))
+
+				req = httptest.NewRequest(http.MethodGet, "/admin/overdue-job/last-run", nil)
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				resp = rec.Result()
+				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
+				var last models.JobRun
+				err = json.NewDecoder(resp.Body).Decode(&last)
+				Expect(err).To(BeNil())
+				Expect(last.ID).To(BeEquivalentTo(update.Run.ID))
+			})
+
+			It("Should skip a scheduled run when the job ran less than an interval ago", func() {
+				req := httptest.NewRequest(http.MethodPost, "/admin/update-book-overdue", nil)
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				last, err := dataStore.GetLastJobRun(models.OverdueJob)
+				Expect(err).To(BeNil())
+
+				interval := srv.Env.OverdueJobInterval
+				srv.Env.OverdueJobInterval = time.Hour
+				defer func() {
+					srv.Env.OverdueJobInterval = interval
+				}()
+				// a cancelled scheduler makes its first round and stops
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				srv.RunOverdueScheduler(ctx)
+
+				run, err := dataStore.GetLastJobRun(models.OverdueJob)
+				Expect(err).To(BeNil())
+				Expect(run.ID).To(Equal(last.ID))
+			})
+
+			It("Should mark a late loan overdue only once", func() {
+				returnDate := time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local)
+				loan := &models.BookHistory{
+					UserID:     1010,
+					BookID:     1010,
+					ReturnDate: &returnDate,
+					Status:     "borrowed",
+				}
+				err = dataStore.Db.Create(loan).Error
+				Expect(err).To(BeNil())
+				defer dataStore.Db.Delete(loan)
+
+				currentTime := time.Now()
+				for run := 0; run < 2; run++ {
+					loans, err := dataStore.UpdateBookOverdue(&currentTime)
+					Expect(err).To(BeNil())
+					changed := false
+					for _, l := range *loans {
+						if l.ID == loan.ID {
+							changed = true
+						}
+					}
+					Expect(changed).To(Equal(run == 0))
+
+					reader := &models.Account{}
+					err = dataStore.Db.Where("id = ?", 1010).First(reader).Error
+					Expect(err).To(BeNil())
+					Expect(reader.OverdueBooks).To(BeEquivalentTo(1))
+				}
+			})
+		})
 	})
 
 	AfterSuite(func() {
@@ -9,6 +9,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"testing"
+	"time"
 )
 
 func TestManagement(t *testing.T) {
@@ -20,6 +21,7 @@ func setupAuthInfo(env *envConfig.Env, db *gorm.DB) (string, string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   1010,
 		"role": models.AdminAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	adminToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -39,6 +41,7 @@ func setupAuthInfo(env *envConfig.Env, db *gorm.DB) (string, string, error) {
 	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   1010,
 		"role": models.UserAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	userToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -68,7 +71,7 @@ func cleanTestData(db *gorm.DB) error {
 	if err := db.Exec(`delete from author where id = ?`, "1010").Error; err != nil {
 		return err
 	}
-	if err := db.Exec(`delete from subject where id = ?`, "1010").Error; err != nil {
+	if err := db.Exec(`delete from category where id = ?`, "1010").Error; err != nil {
 		return err
 	}
 	if err := db.Exec(`delete from book where id = ?`, "1010").Error; err != nil {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 	"github.com/library/envConfig"
@@ -11,6 +13,7 @@ func setupAuthToken(env *envConfig.Env, db *gorm.DB) (string, string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   101010,
 		"role": models.AdminAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	adminToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -30,6 +33,7 @@ func setupAuthToken(env *envConfig.Env, db *gorm.DB) (string, string, error) {
 	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   101010,
 		"role": models.UserAccount,
+		"exp":  time.Now().Add(time.Hour).Unix(),
 	})
 	userToken, err := token.SignedString([]byte(env.JwtSigningKey))
 	if err != nil {
@@ -59,7 +63,7 @@ func cleanMockData(db *gorm.DB) error {
 	if err := db.Exec(`delete from author where id = ?`, "101010").Error; err != nil {
 		return err
 	}
-	if err := db.Exec(`delete from subject where id = ?`, "101010").Error; err != nil {
+	if err := db.Exec(`delete from category where id = ?`, "101010").Error; err != nil {
 		return err
 	}
 	if err := db.Exec(`delete from book where id = ?`, "101010").Error; err != nil {
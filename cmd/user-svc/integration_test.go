@@ -17,7 +17,7 @@ func TestSignupAndLogin(t *testing.T) {
 	Convey("POST /register", t, func() {
 		Convey("It should register a new user", func() {
 			userEmail = "integration@user.com"
-			regReq := &models.Account{
+			regReq := &models.RegistrationRequest{
 				Email:    userEmail,
 				Password: "password",
 			}
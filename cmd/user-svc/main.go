@@ -8,6 +8,8 @@ import (
 	user_server "github.com/library/cmd/user-svc/user-server"
 	data_store "github.com/library/data-store"
 	"github.com/library/envConfig"
+	"github.com/library/jwtkeys"
+	"github.com/library/mailer"
 	"github.com/library/middleware"
 	"github.com/sirupsen/logrus"
 )
@@ -37,8 +39,37 @@ func main() {
 
 	dataStore = data_store.DbConnect(env, testRun)
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
+	resolver, err := jwtkeys.NewResolver(env.AuthConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("loading jwt keys")
+	}
+	middleware.SetKeyResolver(resolver)
+
+	signingKey, keys, err := jwtkeys.FromConfig(env.AuthConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("loading jwt keys")
+	}
+	if signingKey == nil {
+		logrus.Warn("signing access tokens with the shared JWT_SIGNING_KEY, set JWT_PRIVATE_KEY_FILE to sign with RS256 or ES256")
+	}
+
+	mail, err := mailer.New(env.MailConfig)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("setting up mailer")
+	}
 
 	srv = user_server.NewServer(env, dataStore)
+	srv.SigningKey = signingKey
+	srv.Keys = keys
+	srv.Mailer = mail
 	err = srv.ListenAndServe("user-service", env.UserSvcPort)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -42,6 +42,8 @@ func TestMain(m *testing.M) {
 		}).Fatal("creating admin account")
 	}
 	middleware.SetJwtSigningKey(env.JwtSigningKey)
+	middleware.SetRevocationList(dataStore)
+	middleware.SetPermissionSource(dataStore)
 
 	srv = user_server.NewServer(env, dataStore)
 	r := user_server.SetupRouter(srv, nil)
@@ -64,8 +66,9 @@ func createAdminAccount() error {
 	admin := &models.Account{
 		Email:        "integration@admin.com",
 		AccountRole:  models.AdminAccount,
-		Password:     password,
+		Status:       models.AccountActive,
 		PasswordHash: hashedPwd,
 	}
-	return dataStore.CreateUserAccount(*admin)
+	_, err = dataStore.CreateUserAccount(*admin)
+	return err
 }
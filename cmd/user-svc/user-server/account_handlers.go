@@ -0,0 +1,144 @@
+package user_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	"github.com/sirupsen/logrus"
+)
+
+// managedAccount reads the id of the account an admin endpoint acts on.
+// Admins cannot suspend or delete themselves, so there is always another
+// admin left to undo it.
+func (srv *Server) managedAccount(w *middleware.LogResponseWriter, r *http.Request, task string) (uint, bool) {
+	ctx := r.Context()
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		return 0, false
+	}
+	if uint(userID) == GetAuthInfoFromContext(ctx).ID {
+		handleError(w, ctx, srv, task, errors.New("cannot change the status of your own account"), http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// suspendAccount blocks an account from logging in and borrowing, either
+// until the date in the body or until it is reactivated. Its sessions end at
+// once; access tokens already issued run out within ACCESS_TOKEN_TTL.
+func (srv *Server) suspendAccount(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	userID, ok := srv.managedAccount(w, r, "suspend_account")
+	if !ok {
+		return
+	}
+	request := &models.SuspendRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "suspend_account", err, http.StatusBadRequest)
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		handleError(w, ctx, srv, "suspend_account", errors.New("reason is required"), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if request.Until != nil && !request.Until.After(now) {
+		handleError(w, ctx, srv, "suspend_account", errors.New("until must be in the future"), http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SuspendAccount(userID, request.Reason, request.Until, now)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "suspend_account", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "suspend_account", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode":  http.StatusOK,
+		"suspendedBy": GetAuthInfoFromContext(ctx).ID,
+		"reason":      request.Reason,
+	}).Info(fmt.Sprintf("account suspended: %v", userID))
+	err = json.NewEncoder(w).Encode("Account suspended successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "suspend_account", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) reactivateAccount(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	userID, ok := srv.managedAccount(w, r, "reactivate_account")
+	if !ok {
+		return
+	}
+	err := srv.DB.ReactivateAccount(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "reactivate_account", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		if err == data_store.ErrAccountNotSuspended {
+			handleError(w, ctx, srv, "reactivate_account", err, http.StatusConflict)
+			return
+		}
+		handleError(w, ctx, srv, "reactivate_account", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode":    http.StatusOK,
+		"reactivatedBy": GetAuthInfoFromContext(ctx).ID,
+	}).Info(fmt.Sprintf("account reactivated: %v", userID))
+	err = json.NewEncoder(w).Encode("Account reactivated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "reactivate_account", err, http.StatusInternalServerError)
+	}
+}
+
+// deleteAccount closes an account that has returned all its books. The
+// record is kept, soft-deleted, for the loan history and fines.
+func (srv *Server) deleteAccount(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	userID, ok := srv.managedAccount(w, r, "delete_account")
+	if !ok {
+		return
+	}
+	now := time.Now()
+	pickupDeadline := now.AddDate(0, 0, srv.Env.HoldPickupDays)
+	err := srv.DB.DeleteAccount(userID, now, &pickupDeadline)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "delete_account", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrAccountHasLoans:
+			handleError(w, ctx, srv, "delete_account", err, http.StatusConflict)
+		default:
+			handleError(w, ctx, srv, "delete_account", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusOK,
+		"deletedBy":  GetAuthInfoFromContext(ctx).ID,
+	}).Info(fmt.Sprintf("account deleted: %v", userID))
+	err = json.NewEncoder(w).Encode("Account deleted successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "delete_account", err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,203 @@
+package user_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/mailer"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	password_hash "github.com/library/password-hash"
+	"github.com/sirupsen/logrus"
+)
+
+// accountMail is the email a token of some purpose is sent in. The body is
+// filled in with how long the link stays valid and the link itself.
+type accountMail struct {
+	path    string
+	subject string
+	body    string
+}
+
+var accountMails = map[string]accountMail{
+	models.VerifyEmailToken: {
+		path:    "verify-email",
+		subject: "Verify your email address",
+		body: "Welcome to the library.\n\n" +
+			"Open the link below within %s to verify your email address:\n\n%s\n\n" +
+			"If you did not sign up, you can ignore this email.\n",
+	},
+	models.PasswordResetToken: {
+		path:    "reset-password",
+		subject: "Reset your password",
+		body: "Someone asked to reset the password of your library account.\n\n" +
+			"Open the link below within %s to choose a new password:\n\n%s\n\n" +
+			"If it was not you, you can ignore this email and your password stays the same.\n",
+	},
+}
+
+// accepted is the answer to requests for an email. It is the same whether or
+// not the address has an account, so the endpoints cannot be used to find out
+// who is registered.
+const accepted = "If the address has an account, an email is on its way."
+
+// verifyEmail activates the account a verification token was sent for.
+func (srv *Server) verifyEmail(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.VerifyEmailRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "verify_email", err, http.StatusBadRequest)
+		return
+	}
+	tokenHash, ok := srv.checkAccountToken(models.VerifyEmailToken, request.Token)
+	if !ok {
+		handleError(w, ctx, srv, "verify_email", data_store.ErrAccountTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.VerifyEmail(tokenHash, time.Now())
+	if err != nil {
+		if err == data_store.ErrAccountTokenInvalid {
+			handleError(w, ctx, srv, "verify_email", err, http.StatusBadRequest)
+			return
+		}
+		handleError(w, ctx, srv, "verify_email", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusOK,
+	}).Info(fmt.Sprintf("email verified for account: %v", account.ID))
+	err = json.NewEncoder(w).Encode("Email verified successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "verify_email", err, http.StatusInternalServerError)
+	}
+}
+
+// requestVerification mails a new verification link to a pending account,
+// for when the first one expired or never arrived.
+func (srv *Server) requestVerification(wr http.ResponseWriter, r *http.Request) {
+	srv.requestAccountMail(wr, r, "request_verification", models.VerifyEmailToken)
+}
+
+// requestPasswordReset mails a password reset link to an account.
+func (srv *Server) requestPasswordReset(wr http.ResponseWriter, r *http.Request) {
+	srv.requestAccountMail(wr, r, "request_password_reset", models.PasswordResetToken)
+}
+
+func (srv *Server) requestAccountMail(wr http.ResponseWriter, r *http.Request, task, purpose string) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.EmailRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.GetAccountByEmail(request.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return
+	}
+	if err == nil && (purpose != models.VerifyEmailToken || account.Status == models.AccountPending) {
+		err = srv.sendAccountToken(ctx, account, purpose)
+		if err != nil {
+			// reporting the failure would tell the caller the account exists
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"userId": account.ID,
+			}).Error(task)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(accepted)
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+	}
+}
+
+// confirmPasswordReset sets a new password with a reset token and ends the
+// other sessions of the account.
+func (srv *Server) confirmPasswordReset(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.PasswordResetRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusBadRequest)
+		return
+	}
+	tokenHash, ok := srv.checkAccountToken(models.PasswordResetToken, request.Token)
+	if !ok {
+		handleError(w, ctx, srv, "confirm_password_reset", data_store.ErrAccountTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	err = validatePassword(request.Password)
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusBadRequest)
+		return
+	}
+	hashedPwd, err := password_hash.HashPassword(request.Password)
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusInternalServerError)
+		return
+	}
+	account, err := srv.DB.ResetPassword(tokenHash, hashedPwd, time.Now())
+	if err != nil {
+		if err == data_store.ErrAccountTokenInvalid {
+			handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusBadRequest)
+			return
+		}
+		handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusOK,
+	}).Info(fmt.Sprintf("password reset for account: %v", account.ID))
+	err = json.NewEncoder(w).Encode("Password reset successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_password_reset", err, http.StatusInternalServerError)
+	}
+}
+
+// sendAccountToken stores a new token of purpose for account and mails it.
+func (srv *Server) sendAccountToken(ctx context.Context, account *models.Account, purpose string) error {
+	ttl := srv.Env.EmailVerificationTTL
+	if purpose == models.PasswordResetToken {
+		ttl = srv.Env.PasswordResetTTL
+	}
+	token, tokenHash, err := srv.newAccountToken(purpose)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = srv.DB.CreateAccountToken(account.ID, purpose, tokenHash, now, now.Add(ttl))
+	if err != nil {
+		return err
+	}
+	mail := accountMails[purpose]
+	link := fmt.Sprintf("%s/%s?token=%s", strings.TrimSuffix(srv.Env.AppURL, "/"), mail.path, url.QueryEscape(token))
+	return srv.Mailer.Send(ctx, mailer.Message{
+		To:      account.Email,
+		Subject: mail.subject,
+		Body:    fmt.Sprintf(mail.body, describeTTL(ttl), link),
+	})
+}
+
+// describeTTL spells out a token lifetime for an email, e.g. "48 hours".
+func describeTTL(ttl time.Duration) string {
+	switch {
+	case ttl%time.Hour == 0 && ttl > time.Hour:
+		return fmt.Sprintf("%d hours", ttl/time.Hour)
+	case ttl == time.Hour:
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d minutes", ttl/time.Minute)
+}
@@ -6,69 +6,85 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/mail"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/chi"
 	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
 	"github.com/library/middleware"
 	"github.com/library/models"
 	password_hash "github.com/library/password-hash"
 	"github.com/sirupsen/logrus"
 )
 
+const minPasswordLength = 8
+
+// register creates a reader account and mails it a verification link.
+// Readers always get the user role and stay pending until they verify their
+// email address; staff accounts are created through createAccount. With
+// RequireEmailVerification the reader has to verify before the first login,
+// so no tokens are returned.
 func (srv *Server) register() http.HandlerFunc {
 	return func(wr http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		w := middleware.NewLogResponseWriter(wr)
-		account := &models.Account{}
-		err := json.NewDecoder(r.Body).Decode(account)
+		details := &models.RegistrationRequest{}
+		err := json.NewDecoder(r.Body).Decode(details)
 		if err != nil {
-			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
+			handleError(w, ctx, srv, "registration", err, http.StatusBadRequest)
 			return
 		}
-		// account.AccountRole = models.AdminAccount
-		hashedPwd, err := password_hash.HashPassword(account.Password)
+		err = validateCredentials(details.Email, details.Password)
+		if err != nil {
+			handleError(w, ctx, srv, "registration", err, http.StatusBadRequest)
+			return
+		}
+		hashedPwd, err := password_hash.HashPassword(details.Password)
 		if err != nil {
 			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
 			return
 		}
-		account.PasswordHash = hashedPwd
-		err = srv.DB.CreateUserAccount(*account)
+		account, err := srv.DB.CreateUserAccount(models.Account{
+			Email:        details.Email,
+			AccountRole:  models.UserAccount,
+			Status:       models.AccountPending,
+			PasswordHash: hashedPwd,
+		})
 		if err != nil {
-			if strings.Contains(err.Error(), "1062") {
+			if err == data_store.ErrDuplicateAccount {
 				handleError(w, ctx, srv, "registration", err, http.StatusBadRequest)
 				return
 			}
 			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
 			return
 		}
-		// get the created user account
-		acc, err := srv.DB.VerifyUser(*&models.LoginDetails{
-			Email:       account.Email,
-			Password:    account.Password,
-			AccountRole: account.AccountRole,
-		})
+		logrus.WithFields(logrus.Fields{
+			"statusCode": http.StatusOK,
+		}).Info(fmt.Sprintf("new user registered with email: %v", account.Email))
+		err = srv.sendAccountToken(ctx, account, models.VerifyEmailToken)
 		if err != nil {
-			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
+			// the account exists now; the reader can ask for another email
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"userId": account.ID,
+			}).Error("send_verification_email")
+		}
+		if srv.Env.RequireEmailVerification {
+			w.WriteHeader(http.StatusAccepted)
+			err = json.NewEncoder(w).Encode("Registered successfully, verify your email address to log in.")
+			if err != nil {
+				handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
+			}
 			return
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"id":   acc.ID,
-			"role": acc.AccountRole,
-		})
-
-		tokenStr, err := token.SignedString([]byte(srv.Env.JwtSigningKey))
+		tokens, err := srv.startSession(account)
 		if err != nil {
 			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
 			return
 		}
-		logrus.WithFields(logrus.Fields{
-			"statusCode": http.StatusOK,
-		}).Info(fmt.Sprintf("new user registered with email: %v", account.Email))
-
-		err = json.NewEncoder(w).Encode(&models.Response{AccountRole: account.AccountRole, Token: tokenStr})
+		err = json.NewEncoder(w).Encode(tokens)
 		if err != nil {
 			handleError(w, ctx, srv, "registration", err, http.StatusInternalServerError)
 			return
@@ -76,6 +92,75 @@ func (srv *Server) register() http.HandlerFunc {
 	}
 }
 
+// createAccount lets an administrator create an account with any role, such
+// as a librarian or another administrator. The account is active at once.
+func (srv *Server) createAccount(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	details := &models.NewAccountRequest{}
+	err := json.NewDecoder(r.Body).Decode(details)
+	if err != nil {
+		handleError(w, ctx, srv, "create_account", err, http.StatusBadRequest)
+		return
+	}
+	err = validateCredentials(details.Email, details.Password)
+	if err != nil {
+		handleError(w, ctx, srv, "create_account", err, http.StatusBadRequest)
+		return
+	}
+	hashedPwd, err := password_hash.HashPassword(details.Password)
+	if err != nil {
+		handleError(w, ctx, srv, "create_account", err, http.StatusInternalServerError)
+		return
+	}
+	account, err := srv.DB.CreateUserAccount(models.Account{
+		Email:        details.Email,
+		AccountRole:  details.AccountRole,
+		Status:       models.AccountActive,
+		PasswordHash: hashedPwd,
+	})
+	if err != nil {
+		switch err {
+		case data_store.ErrDuplicateAccount, data_store.ErrUnknownRole:
+			handleError(w, ctx, srv, "create_account", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "create_account", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusCreated,
+		"createdBy":  GetAuthInfoFromContext(ctx).ID,
+	}).Info(fmt.Sprintf("%v account created with email: %v", account.AccountRole, account.Email))
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(account)
+	if err != nil {
+		handleError(w, ctx, srv, "create_account", err, http.StatusInternalServerError)
+	}
+}
+
+// validateCredentials checks the email and password of a new account.
+func validateCredentials(email, password string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("email is not a valid address")
+	}
+	return validatePassword(password)
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+// login opens a session for an email address and password. Failed attempts
+// are counted per email address and per client address, and either one is
+// locked out for a while once it has too many.
+// Accounts that use MFA, or have to, get an MfaChallenge instead of a session
+// and continue at loginMfa or loginEnrollMfa.
 func (srv *Server) login() http.HandlerFunc {
 	return func(wr http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -87,25 +172,54 @@ func (srv *Server) login() http.HandlerFunc {
 			return
 		}
 
-		account, err := srv.DB.VerifyUser(*details)
+		now := time.Now()
+		subjects := srv.loginSubjects(r, details.Email)
+		lockedUntil, err := srv.loginLockedUntil(subjects, now)
 		if err != nil {
-			if err == gorm.ErrRecordNotFound {
-				handleError(w, ctx, srv, "login", fmt.Errorf("no such %v found", details.AccountRole), http.StatusBadRequest)
-			} else {
+			handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+			return
+		}
+		if lockedUntil.After(now) {
+			lockedOut(w, r, srv, lockedUntil, now)
+			return
+		}
+		account, err := srv.DB.VerifyUser(*details)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			account = nil
+		}
+		if !checkPassword(account, details.Password) {
+			err = srv.recordLoginFailure(subjects, now)
+			if err != nil {
 				handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+				return
 			}
+			handleError(w, ctx, srv, "login", errInvalidCredentials, http.StatusUnauthorized)
+			return
+		}
+		err = srv.DB.ClearLoginFailures(models.LoginScopeAccount, subjects[models.LoginScopeAccount])
+		if err != nil {
+			handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
 			return
 		}
-		ok := password_hash.ValidatePassword(details.Password, account.PasswordHash)
-		if !ok {
-			handleError(w, ctx, srv, "login", errors.New("invalid password"), http.StatusUnauthorized)
+		if data_store.AccountSuspended(account, now) {
+			observeFailedLogin(reasonSuspended)
+			handleError(w, ctx, srv, "login", data_store.ErrAccountInactive, http.StatusUnauthorized)
 			return
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"id":   account.ID,
-			"role": account.AccountRole,
-		})
-		tokenStr, err := token.SignedString([]byte(srv.Env.JwtSigningKey))
+		if srv.Env.RequireEmailVerification && account.Status == models.AccountPending {
+			observeFailedLogin(reasonUnverified)
+			handleError(w, ctx, srv, "login", errors.New("email address is not verified"), http.StatusUnauthorized)
+			return
+		}
+		if srv.mfaRequired(account) {
+			srv.mfaChallenge(w, ctx, account)
+			return
+		}
+		tokens, err := srv.startSession(account)
 		if err != nil {
 			handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
 			return
@@ -113,7 +227,7 @@ func (srv *Server) login() http.HandlerFunc {
 		logrus.WithFields(logrus.Fields{
 			"statusCode": http.StatusOK,
 		}).Info(fmt.Sprintf("user login with email: %v", account.Email))
-		err = json.NewEncoder(w).Encode(&models.Response{AccountRole: details.AccountRole, Token: tokenStr, UserId: account.ID})
+		err = json.NewEncoder(w).Encode(tokens)
 		if err != nil {
 			handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
 			return
@@ -121,6 +235,106 @@ func (srv *Server) login() http.HandlerFunc {
 	}
 }
 
+// refresh trades a refresh token for a new access token and a new refresh
+// token. Each refresh token works once; replaying one ends the session.
+func (srv *Server) refresh() http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		w := &middleware.LogResponseWriter{ResponseWriter: wr}
+		request := &models.RefreshRequest{}
+		err := json.NewDecoder(r.Body).Decode(request)
+		if err != nil {
+			handleError(w, ctx, srv, "refresh", err, http.StatusBadRequest)
+			return
+		}
+		if request.RefreshToken == "" {
+			handleError(w, ctx, srv, "refresh", errors.New("refreshToken is required"), http.StatusBadRequest)
+			return
+		}
+		refreshToken, refreshHash, err := newRefreshToken()
+		if err != nil {
+			handleError(w, ctx, srv, "refresh", err, http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		old, err := srv.DB.RotateRefreshToken(hashToken(request.RefreshToken), refreshHash, now,
+			now.Add(srv.Env.RefreshTokenTTL))
+		if err != nil {
+			if err == data_store.ErrRefreshTokenInvalid || err == data_store.ErrRefreshTokenReused {
+				handleError(w, ctx, srv, "refresh", err, http.StatusUnauthorized)
+				return
+			}
+			handleError(w, ctx, srv, "refresh", err, http.StatusInternalServerError)
+			return
+		}
+		// the role is read again so a changed role applies from the next refresh
+		account, err := srv.DB.GetUserByID(old.UserID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				handleError(w, ctx, srv, "refresh", data_store.ErrRefreshTokenInvalid, http.StatusUnauthorized)
+				return
+			}
+			handleError(w, ctx, srv, "refresh", err, http.StatusInternalServerError)
+			return
+		}
+		if data_store.AccountSuspended(account, now) {
+			handleError(w, ctx, srv, "refresh", data_store.ErrAccountInactive, http.StatusUnauthorized)
+			return
+		}
+		if srv.Env.RequireAdminMFA && account.AccountRole == models.AdminAccount && !account.MfaEnabled {
+			handleError(w, ctx, srv, "refresh", errors.New("mfa enrollment is required, log in again"), http.StatusUnauthorized)
+			return
+		}
+		tokens, err := srv.issueTokens(account.ID, account.AccountRole, old.FamilyID, refreshToken)
+		if err != nil {
+			handleError(w, ctx, srv, "refresh", err, http.StatusInternalServerError)
+			return
+		}
+		err = json.NewEncoder(w).Encode(tokens)
+		if err != nil {
+			handleError(w, ctx, srv, "refresh", err, http.StatusInternalServerError)
+		}
+	}
+}
+
+// logout revokes the access token it is called with and every refresh token
+// of its session.
+func (srv *Server) logout(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	authInfo := GetAuthInfoFromContext(ctx)
+	if authInfo.Id != "" {
+		err := srv.DB.RevokeToken(authInfo.Id, time.Unix(authInfo.ExpiresAt, 0))
+		if err != nil {
+			handleError(w, ctx, srv, "logout", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if authInfo.SessionID != "" {
+		err := srv.DB.RevokeSession(authInfo.SessionID, time.Now())
+		if err != nil {
+			handleError(w, ctx, srv, "logout", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwks publishes the keys access tokens are verified with. During a rotation
+// it lists the old and the new key so tokens signed with either pass.
+func (srv *Server) jwks() http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		w := &middleware.LogResponseWriter{ResponseWriter: wr}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		err := json.NewEncoder(w).Encode(srv.Keys.JWKS())
+		if err != nil {
+			handleError(w, ctx, srv, "jwks", err, http.StatusInternalServerError)
+		}
+	}
+}
+
 func (srv *Server) health() http.HandlerFunc {
 	return func(w http.ResponseWriter, request *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -147,11 +361,6 @@ func GetAuthInfoFromContext(ctx context.Context) *models.AuthInfo {
 func (srv *Server) getUserByEmail(wr http.ResponseWriter, r *http.Request) {
 	w := &middleware.LogResponseWriter{ResponseWriter: wr}
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_user_by_email", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	userEmail := chi.URLParam(r, "email")
 
 	users, err := srv.DB.GetUserByEmail(userEmail)
@@ -172,11 +381,6 @@ func (srv *Server) getUserByEmail(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getUserByID(wr http.ResponseWriter, r *http.Request) {
 	w := &middleware.LogResponseWriter{ResponseWriter: wr}
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_user_by_id", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	id := chi.URLParam(r, "id")
 	userID, err := strconv.Atoi(id)
 	if err != nil {
@@ -200,11 +404,6 @@ func (srv *Server) getUserByID(wr http.ResponseWriter, r *http.Request) {
 func (srv *Server) getUsers(wr http.ResponseWriter, r *http.Request) {
 	w := &middleware.LogResponseWriter{ResponseWriter: wr}
 	ctx := r.Context()
-	authInfo := GetAuthInfoFromContext(ctx)
-	if authInfo.Role != models.AdminAccount {
-		handleError(w, ctx, srv, "get_users", errors.New("permission denied"), http.StatusUnauthorized)
-		return
-	}
 	users, err := srv.DB.GetUsers()
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -0,0 +1,181 @@
+package user_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	password_hash "github.com/library/password-hash"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons a login is refused, as counted by the failed logins metric.
+const (
+	reasonInvalidCredentials = "invalid_credentials"
+	reasonLockedOut          = "locked_out"
+	reasonUnverified         = "unverified"
+	reasonSuspended          = "suspended"
+)
+
+var (
+	// errInvalidCredentials is the answer both to an unknown email address and
+	// to a wrong password, so a login cannot tell which accounts exist
+	errInvalidCredentials = errors.New("invalid email or password")
+	errLockedOut          = errors.New("too many failed logins, try again later")
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkPassword compares a password with the hash of account, or with a
+// throwaway hash when there is no account, so both cases take as long.
+func checkPassword(account *models.Account, password string) bool {
+	if account == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = password_hash.HashPassword("not the password of any account")
+		})
+		password_hash.ValidatePassword(password, dummyHash)
+		return false
+	}
+	return password_hash.ValidatePassword(password, account.PasswordHash)
+}
+
+// loginSubjects are the scopes and subjects a login attempt is tracked under.
+func (srv *Server) loginSubjects(r *http.Request, email string) map[string]string {
+	return map[string]string{
+		models.LoginScopeAccount: strings.ToLower(strings.TrimSpace(email)),
+		models.LoginScopeIP:      srv.clientIP(r),
+	}
+}
+
+func (srv *Server) lockoutPolicy(scope string) data_store.LockoutPolicy {
+	policy := data_store.LockoutPolicy{
+		MaxAttempts: srv.Env.LoginMaxAttempts,
+		Lockout:     srv.Env.LoginLockout,
+		MaxLockout:  srv.Env.LoginLockoutMax,
+		Window:      srv.Env.LoginFailureWindow,
+	}
+	if scope == models.LoginScopeIP {
+		policy.MaxAttempts = srv.Env.LoginMaxAttemptsPerIP
+	}
+	return policy
+}
+
+// clientIP is the address a request came from. Behind a proxy the last
+// X-Forwarded-For entry is used, since that is the one the proxy added.
+func (srv *Server) clientIP(r *http.Request) string {
+	if srv.Env.TrustProxyHeaders {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLockedUntil returns the latest end of a lockout of the email address or
+// the client address, or the zero time if neither is locked out.
+func (srv *Server) loginLockedUntil(subjects map[string]string, now time.Time) (time.Time, error) {
+	var lockedUntil time.Time
+	for scope, subject := range subjects {
+		until, err := srv.DB.LoginLockedUntil(scope, subject, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginFailure counts a failed login against the email address and the
+// client address.
+func (srv *Server) recordLoginFailure(subjects map[string]string, now time.Time) error {
+	observeFailedLogin(reasonInvalidCredentials)
+	for scope, subject := range subjects {
+		failure, err := srv.DB.RecordLoginFailure(scope, subject, now, srv.lockoutPolicy(scope))
+		if err != nil {
+			return err
+		}
+		if failure.LockedUntil != nil && failure.LockedUntil.After(now) {
+			logrus.WithFields(logrus.Fields{
+				"scope":       scope,
+				"subject":     subject,
+				"failures":    failure.Failures,
+				"lockedUntil": failure.LockedUntil,
+			}).Warn("login lockout")
+			if loginMetrics != nil {
+				loginMetrics.Lockouts.WithLabelValues(scope).Inc()
+			}
+		}
+	}
+	return nil
+}
+
+func lockedOut(w *middleware.LogResponseWriter, r *http.Request, srv *Server, until, now time.Time) {
+	observeFailedLogin(reasonLockedOut)
+	seconds := int(math.Ceil(until.Sub(now).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	handleError(w, r.Context(), srv, "login", errLockedOut, http.StatusTooManyRequests)
+}
+
+func observeFailedLogin(reason string) {
+	if loginMetrics == nil {
+		return
+	}
+	loginMetrics.FailedLogins.WithLabelValues(reason).Inc()
+}
+
+// unlockAccount lifts the lockout of an account and forgets its failed
+// logins. Lockouts of client addresses expire on their own.
+func (srv *Server) unlockAccount(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "unlock_account", err, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.GetUserByID(uint(userID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "unlock_account", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "unlock_account", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.ClearLoginFailures(models.LoginScopeAccount, strings.ToLower(account.Email))
+	if err != nil {
+		handleError(w, ctx, srv, "unlock_account", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusOK,
+		"unlockedBy": GetAuthInfoFromContext(ctx).ID,
+	}).Info(fmt.Sprintf("account unlocked: %v", account.ID))
+	err = json.NewEncoder(w).Encode("Account unlocked successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "unlock_account", err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,380 @@
+package user_server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	password_hash "github.com/library/password-hash"
+	"github.com/library/totp"
+)
+
+// recoveryCodeCount recovery codes of recoveryCodeLength characters are
+// handed out with every enrollment.
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// totpSkew accepts the codes of the steps next to the current one, for
+// clocks that drift and codes typed in at the end of their step.
+const totpSkew = 1
+
+var errInvalidCode = errors.New("invalid code")
+
+// mfaRequired reports whether account has to pass a second factor, or enroll
+// in one, before it gets a session.
+func (srv *Server) mfaRequired(account *models.Account) bool {
+	return account.MfaEnabled || (srv.Env.RequireAdminMFA && account.AccountRole == models.AdminAccount)
+}
+
+// mfaChallenge answers a correct password with a short-lived token for the
+// second step of the login instead of a session.
+func (srv *Server) mfaChallenge(w *middleware.LogResponseWriter, ctx context.Context, account *models.Account) {
+	purpose := models.MfaLoginToken
+	if !account.MfaEnabled {
+		purpose = models.MfaEnrollToken
+	}
+	token, tokenHash, err := srv.newAccountToken(purpose)
+	if err != nil {
+		handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	err = srv.DB.CreateAccountToken(account.ID, purpose, tokenHash, now, now.Add(srv.Env.MfaPendingTTL))
+	if err != nil {
+		handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(&models.MfaChallenge{
+		MfaRequired:        true,
+		EnrollmentRequired: !account.MfaEnabled,
+		MfaToken:           token,
+		ExpiresIn:          int64(srv.Env.MfaPendingTTL / time.Second),
+	})
+	if err != nil {
+		handleError(w, ctx, srv, "login", err, http.StatusInternalServerError)
+	}
+}
+
+// loginMfa finishes a login with the token from mfaChallenge and a TOTP code
+// or a recovery code. Wrong codes count as failed logins, so guessing codes
+// locks the account out like guessing passwords does.
+func (srv *Server) loginMfa(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.MfaCodeRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	account, tokenHash, ok := srv.pendingMfaAccount(w, ctx, "login_mfa", models.MfaLoginToken, request.MfaToken, now)
+	if !ok {
+		return
+	}
+	subjects := srv.loginSubjects(r, account.Email)
+	lockedUntil, err := srv.loginLockedUntil(subjects, now)
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	if lockedUntil.After(now) {
+		lockedOut(w, r, srv, lockedUntil, now)
+		return
+	}
+	ok, err = srv.checkSecondFactor(account, request, now)
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		err = srv.recordLoginFailure(subjects, now)
+		if err != nil {
+			handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+			return
+		}
+		handleError(w, ctx, srv, "login_mfa", errInvalidCode, http.StatusUnauthorized)
+		return
+	}
+	_, err = srv.DB.RedeemAccountToken(models.MfaLoginToken, tokenHash, now)
+	if err != nil {
+		if err == data_store.ErrAccountTokenInvalid {
+			handleError(w, ctx, srv, "login_mfa", err, http.StatusUnauthorized)
+			return
+		}
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.ClearLoginFailures(models.LoginScopeAccount, subjects[models.LoginScopeAccount])
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	tokens, err := srv.startSession(account)
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		handleError(w, ctx, srv, "login_mfa", err, http.StatusInternalServerError)
+	}
+}
+
+// enrollMfa starts an enrollment for the logged in account.
+func (srv *Server) enrollMfa(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	account, err := srv.DB.GetUserByID(GetAuthInfoFromContext(ctx).ID)
+	if err != nil {
+		handleError(w, ctx, srv, "enroll_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	srv.startEnrollment(w, ctx, "enroll_mfa", account)
+}
+
+// loginEnrollMfa starts the enrollment of an account that has to use MFA
+// before it can log in, with the token from mfaChallenge.
+func (srv *Server) loginEnrollMfa(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.MfaCodeRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "login_enroll_mfa", err, http.StatusBadRequest)
+		return
+	}
+	account, _, ok := srv.pendingMfaAccount(w, ctx, "login_enroll_mfa", models.MfaEnrollToken, request.MfaToken, time.Now())
+	if !ok {
+		return
+	}
+	srv.startEnrollment(w, ctx, "login_enroll_mfa", account)
+}
+
+// startEnrollment gives account a new TOTP secret. MFA is only enabled once
+// a code of it is confirmed.
+func (srv *Server) startEnrollment(w *middleware.LogResponseWriter, ctx context.Context, task string, account *models.Account) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.StartMfaEnrollment(account.ID, secret)
+	if err != nil {
+		if err == data_store.ErrMfaEnabled {
+			handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+			return
+		}
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(&models.MfaEnrollment{
+		Secret: secret,
+		URI:    totp.URI(srv.Env.MfaIssuer, account.Email, secret),
+	})
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+	}
+}
+
+// confirmMfa enables MFA for the logged in account with a code of the secret
+// from enrollMfa, and returns its recovery codes.
+func (srv *Server) confirmMfa(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.MfaCodeRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_mfa", err, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.GetUserByID(GetAuthInfoFromContext(ctx).ID)
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	codes, ok := srv.confirmEnrollment(w, ctx, "confirm_mfa", account, request.Code)
+	if !ok {
+		return
+	}
+	err = json.NewEncoder(w).Encode(&models.MfaConfirmation{RecoveryCodes: codes})
+	if err != nil {
+		handleError(w, ctx, srv, "confirm_mfa", err, http.StatusInternalServerError)
+	}
+}
+
+// loginConfirmMfa enables MFA for an account that had to enroll to log in,
+// and opens its session.
+func (srv *Server) loginConfirmMfa(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.MfaCodeRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "login_confirm_mfa", err, http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	account, tokenHash, ok := srv.pendingMfaAccount(w, ctx, "login_confirm_mfa", models.MfaEnrollToken, request.MfaToken, now)
+	if !ok {
+		return
+	}
+	codes, ok := srv.confirmEnrollment(w, ctx, "login_confirm_mfa", account, request.Code)
+	if !ok {
+		return
+	}
+	_, err = srv.DB.RedeemAccountToken(models.MfaEnrollToken, tokenHash, now)
+	if err != nil {
+		if err == data_store.ErrAccountTokenInvalid {
+			handleError(w, ctx, srv, "login_confirm_mfa", err, http.StatusUnauthorized)
+			return
+		}
+		handleError(w, ctx, srv, "login_confirm_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	tokens, err := srv.startSession(account)
+	if err != nil {
+		handleError(w, ctx, srv, "login_confirm_mfa", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(&models.MfaConfirmation{RecoveryCodes: codes, Response: tokens})
+	if err != nil {
+		handleError(w, ctx, srv, "login_confirm_mfa", err, http.StatusInternalServerError)
+	}
+}
+
+func (srv *Server) confirmEnrollment(w *middleware.LogResponseWriter, ctx context.Context, task string,
+	account *models.Account, code string) ([]string, bool) {
+	if account.MfaEnabled {
+		handleError(w, ctx, srv, task, data_store.ErrMfaEnabled, http.StatusBadRequest)
+		return nil, false
+	}
+	if account.MfaSecret == "" {
+		handleError(w, ctx, srv, task, data_store.ErrMfaNotEnrolled, http.StatusBadRequest)
+		return nil, false
+	}
+	step, ok := totp.Validate(account.MfaSecret, code, time.Now(), totpSkew)
+	if !ok {
+		handleError(w, ctx, srv, task, errInvalidCode, http.StatusBadRequest)
+		return nil, false
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	err = srv.DB.EnableMfa(account.ID, step, hashes)
+	if err != nil {
+		switch err {
+		case data_store.ErrMfaEnabled, data_store.ErrMfaNotEnrolled:
+			handleError(w, ctx, srv, task, err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return codes, true
+}
+
+// pendingMfaAccount checks a token from mfaChallenge without spending it, so
+// a mistyped code can be retried, and loads the account it was issued for.
+func (srv *Server) pendingMfaAccount(w *middleware.LogResponseWriter, ctx context.Context, task, purpose, token string,
+	now time.Time) (*models.Account, string, bool) {
+	tokenHash, ok := srv.checkAccountToken(purpose, token)
+	if !ok {
+		handleError(w, ctx, srv, task, data_store.ErrAccountTokenInvalid, http.StatusUnauthorized)
+		return nil, "", false
+	}
+	pending, err := srv.DB.GetAccountToken(purpose, tokenHash, now)
+	if err != nil {
+		if err == data_store.ErrAccountTokenInvalid {
+			handleError(w, ctx, srv, task, err, http.StatusUnauthorized)
+			return nil, "", false
+		}
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return nil, "", false
+	}
+	account, err := srv.DB.GetUserByID(pending.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, task, data_store.ErrAccountTokenInvalid, http.StatusUnauthorized)
+			return nil, "", false
+		}
+		handleError(w, ctx, srv, task, err, http.StatusInternalServerError)
+		return nil, "", false
+	}
+	return account, tokenHash, true
+}
+
+// checkSecondFactor verifies a TOTP code, or a recovery code when one is
+// given, and spends it.
+func (srv *Server) checkSecondFactor(account *models.Account, request *models.MfaCodeRequest, now time.Time) (bool, error) {
+	if !account.MfaEnabled {
+		return false, nil
+	}
+	if request.RecoveryCode != "" {
+		codes, err := srv.DB.GetRecoveryCodes(account.ID)
+		if err != nil {
+			return false, err
+		}
+		given := normalizeRecoveryCode(request.RecoveryCode)
+		for _, code := range *codes {
+			if !password_hash.ValidatePassword(given, code.CodeHash) {
+				continue
+			}
+			err = srv.DB.UseRecoveryCode(code.ID, now)
+			if err == data_store.ErrMfaCodeUsed {
+				return false, nil
+			}
+			return err == nil, err
+		}
+		return false, nil
+	}
+	step, ok := totp.Validate(account.MfaSecret, request.Code, now, totpSkew)
+	if !ok {
+		return false, nil
+	}
+	err := srv.DB.UseTotpStep(account.ID, step)
+	if err == data_store.ErrMfaCodeUsed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes, formatted as
+// "xxxxx-xxxxx", and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := range codes {
+		buf := make([]byte, 8)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf))[:recoveryCodeLength]
+		hashes[i], err = password_hash.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,176 @@
+package user_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+	password_hash "github.com/library/password-hash"
+	"github.com/sirupsen/logrus"
+)
+
+const maxDisplayNameLength = 100
+
+var (
+	phonePattern    = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{3,30}$`)
+	memberIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+	errWrongPassword = errors.New("current password is incorrect")
+)
+
+// getProfile returns the account of the caller with its current loan and
+// overdue counts.
+func (srv *Server) getProfile(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	account, err := srv.DB.GetProfile(GetAuthInfoFromContext(ctx).ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "get_profile", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "get_profile", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(account)
+	if err != nil {
+		handleError(w, ctx, srv, "get_profile", err, http.StatusInternalServerError)
+	}
+}
+
+// updateProfile replaces the display name, phone number and student or staff
+// ID of the caller. Email, role and status are not changed here.
+func (srv *Server) updateProfile(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.ProfileRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "update_profile", err, http.StatusBadRequest)
+		return
+	}
+	err = validateProfile(request)
+	if err != nil {
+		handleError(w, ctx, srv, "update_profile", err, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.UpdateProfile(GetAuthInfoFromContext(ctx).ID, *request)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "update_profile", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrDuplicateMember:
+			handleError(w, ctx, srv, "update_profile", err, http.StatusConflict)
+		default:
+			handleError(w, ctx, srv, "update_profile", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(account)
+	if err != nil {
+		handleError(w, ctx, srv, "update_profile", err, http.StatusInternalServerError)
+	}
+}
+
+// validateProfile trims the fields of a profile update and checks them. Empty
+// fields clear the detail.
+func validateProfile(request *models.ProfileRequest) error {
+	request.DisplayName = strings.TrimSpace(request.DisplayName)
+	request.Phone = strings.TrimSpace(request.Phone)
+	request.MemberID = strings.TrimSpace(request.MemberID)
+	if utf8.RuneCountInString(request.DisplayName) > maxDisplayNameLength {
+		return fmt.Errorf("displayName must be at most %d characters", maxDisplayNameLength)
+	}
+	if request.Phone != "" && !phonePattern.MatchString(request.Phone) {
+		return errors.New("invalid phone number")
+	}
+	if request.MemberID != "" && !memberIDPattern.MatchString(request.MemberID) {
+		return errors.New("memberId must be up to 32 letters, digits or dashes")
+	}
+	return nil
+}
+
+// changePassword sets a new password once the current one is confirmed. All
+// sessions of the account end and the caller gets a new one. Wrong current
+// passwords count as failed logins, so a stolen access token cannot be used
+// to guess the password.
+func (srv *Server) changePassword(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	request := &models.PasswordChangeRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusBadRequest)
+		return
+	}
+	account, err := srv.DB.GetUserByID(GetAuthInfoFromContext(ctx).ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			handleError(w, ctx, srv, "change_password", errors.New("no record found"), http.StatusOK)
+			return
+		}
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	subjects := srv.loginSubjects(r, account.Email)
+	lockedUntil, err := srv.loginLockedUntil(subjects, now)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	if lockedUntil.After(now) {
+		lockedOut(w, r, srv, lockedUntil, now)
+		return
+	}
+	if !checkPassword(account, request.CurrentPassword) {
+		err = srv.recordLoginFailure(subjects, now)
+		if err != nil {
+			handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+			return
+		}
+		handleError(w, ctx, srv, "change_password", errWrongPassword, http.StatusUnauthorized)
+		return
+	}
+	err = srv.DB.ClearLoginFailures(models.LoginScopeAccount, subjects[models.LoginScopeAccount])
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	err = validatePassword(request.NewPassword)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusBadRequest)
+		return
+	}
+	hashedPwd, err := password_hash.HashPassword(request.NewPassword)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	err = srv.DB.ChangePassword(account.ID, hashedPwd, now)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	tokens, err := srv.startSession(account)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"statusCode": http.StatusOK,
+	}).Info(fmt.Sprintf("password changed for account: %v", account.ID))
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		handleError(w, ctx, srv, "change_password", err, http.StatusInternalServerError)
+	}
+}
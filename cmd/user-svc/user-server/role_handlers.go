@@ -0,0 +1,98 @@
+package user_server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/jinzhu/gorm"
+	data_store "github.com/library/data-store"
+	"github.com/library/middleware"
+	"github.com/library/models"
+)
+
+func (srv *Server) getRoles(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	roles, err := srv.DB.GetRoles()
+	if err != nil {
+		handleError(w, ctx, srv, "get_roles", err, http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(w).Encode(roles)
+	if err != nil {
+		handleError(w, ctx, srv, "get_roles", err, http.StatusInternalServerError)
+	}
+}
+
+// setRolePermissions replaces the permission set of a role with the JSON
+// array of permissions in the body.
+func (srv *Server) setRolePermissions(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	role := chi.URLParam(r, "role")
+	var permissions []string
+	err := json.NewDecoder(r.Body).Decode(&permissions)
+	if err != nil {
+		handleError(w, ctx, srv, "set_role_permissions", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SetRolePermissions(role, permissions)
+	if err != nil {
+		switch err {
+		case data_store.ErrUnknownRole:
+			handleError(w, ctx, srv, "set_role_permissions", err, http.StatusNotFound)
+		case data_store.ErrUnknownPermission, data_store.ErrProtectedRole:
+			handleError(w, ctx, srv, "set_role_permissions", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "set_role_permissions", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode("Role permissions updated successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "set_role_permissions", err, http.StatusInternalServerError)
+	}
+}
+
+// setUserRole assigns the role in the body to an account. The new role
+// applies from the account's next login or token refresh.
+func (srv *Server) setUserRole(wr http.ResponseWriter, r *http.Request) {
+	w := &middleware.LogResponseWriter{ResponseWriter: wr}
+	ctx := r.Context()
+	authInfo := GetAuthInfoFromContext(ctx)
+	id := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		handleError(w, ctx, srv, "set_user_role", err, http.StatusBadRequest)
+		return
+	}
+	if uint(userID) == authInfo.ID {
+		handleError(w, ctx, srv, "set_user_role", errors.New("cannot change your own role"), http.StatusBadRequest)
+		return
+	}
+	assignment := &models.RoleAssignment{}
+	err = json.NewDecoder(r.Body).Decode(assignment)
+	if err != nil {
+		handleError(w, ctx, srv, "set_user_role", err, http.StatusBadRequest)
+		return
+	}
+	err = srv.DB.SetAccountRole(uint(userID), assignment.Role)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			handleError(w, ctx, srv, "set_user_role", errors.New("no record found"), http.StatusOK)
+		case data_store.ErrUnknownRole:
+			handleError(w, ctx, srv, "set_user_role", err, http.StatusBadRequest)
+		default:
+			handleError(w, ctx, srv, "set_user_role", err, http.StatusInternalServerError)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode("Role assigned successfully!")
+	if err != nil {
+		handleError(w, ctx, srv, "set_user_role", err, http.StatusInternalServerError)
+	}
+}
@@ -3,6 +3,7 @@ package user_server
 import (
 	"github.com/go-chi/chi"
 	"github.com/library/middleware"
+	"github.com/library/models"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -12,15 +13,55 @@ func SetupRouter(srv *Server, prom *prometheus.Registry) *chi.Mux {
 	r.Use(middleware.ChainMiddlewares(false, promMetrics, srv.Env)...)
 	r.Post("/register", srv.register())
 	r.Post("/login", srv.login())
+	r.Post("/login/mfa", srv.loginMfa)
+	r.Post("/login/mfa/enroll", srv.loginEnrollMfa)
+	r.Post("/login/mfa/confirm", srv.loginConfirmMfa)
+	r.Post("/refresh", srv.refresh())
+	r.Post("/verify-email", srv.verifyEmail)
+	r.Post("/verify-email/request", srv.requestVerification)
+	r.Post("/password-reset/request", srv.requestPasswordReset)
+	r.Post("/password-reset/confirm", srv.confirmPasswordReset)
 	r.Get("/health", srv.health())
+	r.Get("/.well-known/jwks.json", srv.jwks())
 	r.Handle("/metrics", promhttp.HandlerFor(prom, promhttp.HandlerOpts{}))
 
+	r.With(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...).Post("/logout", srv.logout)
+
+	r.Route("/mfa", func(r chi.Router) {
+		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.Post("/enroll", srv.enrollMfa)
+		r.Post("/confirm", srv.confirmMfa)
+	})
+
+	r.Route("/me", func(r chi.Router) {
+		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.Get("/", srv.getProfile)
+		r.Put("/", srv.updateProfile)
+		r.Post("/password", srv.changePassword)
+	})
+
 	r.Route("/get", func(r chi.Router) {
 		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.Use(middleware.RequirePermission(models.PermUsersRead))
 		r.Get("/users", srv.getUsers)
 		r.Get("/users-by-email/{email}", srv.getUserByEmail)
 		r.Get("/users-by-id/{id}", srv.getUserByID)
 	})
 
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(middleware.ChainMiddlewares(true, promMetrics, srv.Env)...)
+		r.With(middleware.RequirePermission(models.PermUsersRead)).Get("/roles", srv.getRoles)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequirePermission(models.PermUsersWrite))
+			r.Put("/roles/{role}/permissions", srv.setRolePermissions)
+			r.Post("/users", srv.createAccount)
+			r.Put("/users/{id}/role", srv.setUserRole)
+			r.Post("/users/{id}/unlock", srv.unlockAccount)
+			r.Post("/users/{id}/suspend", srv.suspendAccount)
+			r.Post("/users/{id}/reactivate", srv.reactivateAccount)
+			r.Delete("/users/{id}", srv.deleteAccount)
+		})
+	})
+
 	return r
 }
@@ -5,6 +5,8 @@ import (
 
 	datastore "github.com/library/data-store"
 	"github.com/library/envConfig"
+	"github.com/library/jwtkeys"
+	"github.com/library/mailer"
 	"github.com/library/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -13,11 +15,20 @@ import (
 var (
 	prom        *prometheus.Registry
 	promMetrics *metrics.Metrics
+	// loginMetrics is nil until the server listens, as in tests
+	loginMetrics *metrics.LoginMetrics
 )
 
 type Server struct {
-	DB        datastore.DbUtil
-	Env       *envConfig.Env
+	DB  datastore.DbUtil
+	Env *envConfig.Env
+	// SigningKey signs access tokens; without one they are signed with
+	// Env.JwtSigningKey
+	SigningKey *jwtkeys.SigningKey
+	// Keys are published at /.well-known/jwks.json
+	Keys jwtkeys.KeySet
+	// Mailer sends verification and password reset links
+	Mailer    mailer.Mailer
 	TracingID string
 	TestRun   bool
 }
@@ -26,6 +37,7 @@ func NewServer(env *envConfig.Env, db datastore.DbUtil) *Server {
 	return &Server{
 		DB:        db,
 		Env:       env,
+		Mailer:    &mailer.LogMailer{From: env.MailFrom},
 		TracingID: "",
 		TestRun:   false,
 	}
@@ -36,6 +48,9 @@ func (srv *Server) ListenAndServe(service string, port string) error {
 	promMetrics = metrics.NewMetrics("user_svc")
 	prom.MustRegister(promMetrics.RequestCounter)
 	prom.MustRegister(promMetrics.LatencyCalculator)
+	loginMetrics = metrics.NewLoginMetrics("user_svc")
+	prom.MustRegister(loginMetrics.FailedLogins)
+	prom.MustRegister(loginMetrics.Lockouts)
 
 	r := SetupRouter(srv, prom)
 	logrus.WithFields(logrus.Fields{
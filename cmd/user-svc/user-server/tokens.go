@@ -0,0 +1,127 @@
+package user_server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/library/models"
+)
+
+// startSession opens a new refresh token family for account and returns the
+// first token pair of it.
+func (srv *Server) startSession(account *models.Account) (*models.Response, error) {
+	familyID, err := randomID(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = srv.DB.CreateRefreshToken(account.ID, familyID, refreshHash, time.Now().Add(srv.Env.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	return srv.issueTokens(account.ID, account.AccountRole, familyID, refreshToken)
+}
+
+// issueTokens signs an access token for the session and pairs it with the
+// refresh token that was stored for it.
+func (srv *Server) issueTokens(userID uint, role, familyID, refreshToken string) (*models.Response, error) {
+	jti, err := randomID(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"id":   userID,
+		"role": role,
+		"sid":  familyID,
+		"jti":  jti,
+		"iat":  now.Unix(),
+		"exp":  now.Add(srv.Env.AccessTokenTTL).Unix(),
+	}
+	var tokenStr string
+	if srv.SigningKey != nil {
+		tokenStr, err = srv.SigningKey.Sign(claims)
+	} else {
+		tokenStr, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(srv.Env.JwtSigningKey))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.Response{
+		AccountRole:  role,
+		Token:        tokenStr,
+		ExpiresIn:    int64(srv.Env.AccessTokenTTL / time.Second),
+		RefreshToken: refreshToken,
+		UserId:       userID,
+	}, nil
+}
+
+// newRefreshToken returns an opaque refresh token and the hash it is stored
+// under; the token itself only ever exists on the client.
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// newAccountToken returns a token for an emailed link and the hash it is
+// stored under. The token is a random value followed by an HMAC of the value
+// and the purpose, so a tampered token, or one minted for another purpose, is
+// refused before the database is asked.
+func (srv *Server) newAccountToken(purpose string) (string, string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+	token := value + "." + srv.signAccountToken(purpose, value)
+	return token, hashToken(token), nil
+}
+
+// checkAccountToken verifies the signature of a token and returns the hash it
+// is stored under.
+func (srv *Server) checkAccountToken(purpose, token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", false
+	}
+	expected := srv.signAccountToken(purpose, token[:i])
+	if !hmac.Equal([]byte(token[i+1:]), []byte(expected)) {
+		return "", false
+	}
+	return hashToken(token), true
+}
+
+func (srv *Server) signAccountToken(purpose, value string) string {
+	mac := hmac.New(sha256.New, []byte(srv.Env.JwtSigningKey))
+	mac.Write([]byte("account-token:" + purpose + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomID(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
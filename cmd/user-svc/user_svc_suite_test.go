@@ -24,6 +24,25 @@ func setupUserData(db *gorm.DB) error {
 }
 
 func cleanTestData(db *gorm.DB, adminEmail, userEmail string) error {
+	err := db.Exec(`delete from refresh_token where user_id in (select id from account where email in (?, ?))`,
+		adminEmail, userEmail).Error
+	if err != nil {
+		return err
+	}
+	err = db.Exec(`delete from account_token where user_id in (select id from account where email in (?, ?))`,
+		adminEmail, userEmail).Error
+	if err != nil {
+		return err
+	}
+	err = db.Exec(`delete from recovery_code where user_id in (select id from account where email in (?, ?))`,
+		adminEmail, userEmail).Error
+	if err != nil {
+		return err
+	}
+	err = db.Exec(`delete from login_failure where scope = 'ip' or subject in (?, ?)`, adminEmail, userEmail).Error
+	if err != nil {
+		return err
+	}
 	if err := db.Exec(`delete from account where email = ?`, adminEmail).Error; err != nil {
 		return err
 	}
@@ -43,6 +62,7 @@ func addAdmin() (*models.Account, error) {
 		Email:        "unit@admin.com",
 		AccountRole:  models.AdminAccount,
 		Password:     password,
+		Status:       models.AccountActive,
 		PasswordHash: hashedPwd,
 	}, nil
 }
@@ -2,17 +2,34 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/chi"
 	"github.com/kelseyhightower/envconfig"
 	user_server "github.com/library/cmd/user-svc/user-server"
 	data_store "github.com/library/data-store"
 	"github.com/library/envConfig"
+	"github.com/library/jwtkeys"
+	"github.com/library/mailer"
 	"github.com/library/middleware"
 	"github.com/library/models"
+	password_hash "github.com/library/password-hash"
+	"github.com/library/totp"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -34,6 +51,8 @@ var _ = Describe("User-Service", func() {
 		srv.TestRun = true
 		r = user_server.SetupRouter(srv, nil)
 		middleware.SetJwtSigningKey(srv.Env.JwtSigningKey)
+		middleware.SetPermissionSource(dataStore)
+		middleware.SetRevocationList(dataStore)
 		err = setupUserData(dataStore.Db)
 		Expect(err).To(BeNil())
 	})
@@ -41,7 +60,7 @@ var _ = Describe("User-Service", func() {
 		Describe("Registration Test", func() {
 			It("Should register a new user", func() {
 				userEmail = "unit@user.com"
-				regReq := &models.Account{
+				regReq := &models.RegistrationRequest{
 					Email:    "unit@user.com",
 					Password: "password",
 				}
@@ -55,6 +74,36 @@ var _ = Describe("User-Service", func() {
 				Expect(resp.StatusCode).To(BeEquivalentTo(http.StatusOK))
 				resp.Body.Close()
 			})
+			It("Should ignore the role and status sent by the client", func() {
+				email := "unit@intruder.com"
+				marshalReq, err := json.Marshal(map[string]string{
+					"email":       email,
+					"password":    "password",
+					"accountRole": models.AdminAccount,
+					"status":      models.AccountActive,
+				})
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(marshalReq)))
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				account, err := dataStore.GetUserByEmail(email)
+				Expect(err).To(BeNil())
+				Expect(account.AccountRole).To(Equal(models.UserAccount))
+				Expect(account.Status).To(Equal(models.AccountPending))
+				Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+			})
+			It("Should reject an invalid email or a short password", func() {
+				for _, regReq := range []*models.RegistrationRequest{
+					{Email: "not an email", Password: "password"},
+					{Email: "unit@short.com", Password: "short"},
+				} {
+					marshalReq, err := json.Marshal(regReq)
+					Expect(err).To(BeNil())
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(marshalReq)))
+					Expect(rec.Code).To(BeEquivalentTo(http.StatusBadRequest))
+				}
+			})
 		})
 
 		Describe("Login Test", func() {
@@ -114,6 +163,606 @@ var _ = Describe("User-Service", func() {
 				})
 			})
 		})
+
+		Describe("Session Test", func() {
+			It("Should rotate refresh tokens and revoke the session on logout", func() {
+				login := func(email, role string) *models.Response {
+					marshalReq, err := json.Marshal(&models.LoginDetails{
+						Email:       email,
+						Password:    "password",
+						AccountRole: role,
+					})
+					Expect(err).To(BeNil())
+					req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq))
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+					tokens := &models.Response{}
+					Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+					return tokens
+				}
+				refresh := func(refreshToken string) *httptest.ResponseRecorder {
+					marshalReq, err := json.Marshal(&models.RefreshRequest{RefreshToken: refreshToken})
+					Expect(err).To(BeNil())
+					req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewBuffer(marshalReq))
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					return rec
+				}
+				getUsers := func(token string) int {
+					req := httptest.NewRequest(http.MethodGet, "/get/users", nil)
+					req.Header.Set("Authorization", "Bearer "+token)
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+					return rec.Code
+				}
+
+				first := login(userEmail, models.UserAccount)
+				Expect(first.ExpiresIn).To(BeNumerically(">", 0))
+				Expect(first.RefreshToken).NotTo(BeEmpty())
+
+				rec := refresh(first.RefreshToken)
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				second := &models.Response{}
+				Expect(json.NewDecoder(rec.Body).Decode(second)).To(BeNil())
+				Expect(second.RefreshToken).NotTo(Equal(first.RefreshToken))
+
+				// replaying the rotated token revokes the whole session
+				Expect(refresh(first.RefreshToken).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(refresh(second.RefreshToken).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+
+				// an admin token, so /get/users only refuses it once it is revoked
+				third := login(adminEmail, models.AdminAccount)
+				Expect(getUsers(third.Token)).To(BeEquivalentTo(http.StatusOK))
+				req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+				req.Header.Set("Authorization", "Bearer "+third.Token)
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusNoContent))
+				Expect(getUsers(third.Token)).To(BeEquivalentTo(http.StatusUnauthorized))
+				Expect(refresh(third.RefreshToken).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			})
+		})
+	})
+	Describe("Roles Test", func() {
+		It("Should let admins manage role assignments", func() {
+			login := func(email, role string) string {
+				marshalReq, err := json.Marshal(&models.LoginDetails{Email: email, Password: "password", AccountRole: role})
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				tokens := &models.Response{}
+				Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+				return tokens.Token
+			}
+			send := func(method, target, token string, body interface{}) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(body)
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(method, target, bytes.NewBuffer(marshalReq))
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+			adminToken := login(adminEmail, models.AdminAccount)
+			userToken := login(userEmail, models.UserAccount)
+			user, err := dataStore.GetUserByEmail(userEmail)
+			Expect(err).To(BeNil())
+
+			Expect(send(http.MethodGet, "/admin/roles", userToken, nil).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			rec := send(http.MethodGet, "/admin/roles", adminToken, nil)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			var roles []models.Role
+			Expect(json.NewDecoder(rec.Body).Decode(&roles)).To(BeNil())
+			for _, role := range roles {
+				if role.Name == models.LibrarianAccount {
+					Expect(role.Permissions).To(ContainElement(models.PermLoansConfirm))
+					Expect(role.Permissions).NotTo(ContainElement(models.PermCatalogDelete))
+				}
+			}
+
+			Expect(send(http.MethodPut, "/admin/roles/admin/permissions", adminToken, []string{models.PermUsersRead}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(send(http.MethodPut, fmt.Sprintf("/admin/users/%d/role", user.ID), adminToken,
+				&models.RoleAssignment{Role: "janitor"}).Code).To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(send(http.MethodPut, fmt.Sprintf("/admin/users/%d/role", user.ID), adminToken,
+				&models.RoleAssignment{Role: models.LibrarianAccount}).Code).To(BeEquivalentTo(http.StatusOK))
+
+			librarianToken := login(userEmail, models.LibrarianAccount)
+			Expect(send(http.MethodGet, "/get/users", librarianToken, nil).Code).To(BeEquivalentTo(http.StatusOK))
+			Expect(send(http.MethodPut, fmt.Sprintf("/admin/users/%d/role", user.ID), librarianToken,
+				&models.RoleAssignment{Role: models.AdminAccount}).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+
+			Expect(send(http.MethodPut, fmt.Sprintf("/admin/users/%d/role", user.ID), adminToken,
+				&models.RoleAssignment{Role: models.UserAccount}).Code).To(BeEquivalentTo(http.StatusOK))
+		})
+		It("Should let admins create staff accounts", func() {
+			marshalReq, err := json.Marshal(&models.LoginDetails{Email: adminEmail, Password: "password", AccountRole: models.AdminAccount})
+			Expect(err).To(BeNil())
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			create := func(details *models.NewAccountRequest) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(details)
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, "/admin/users", bytes.NewBuffer(marshalReq))
+				req.Header.Set("Authorization", "Bearer "+tokens.Token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+			email := "unit@librarian.com"
+			Expect(create(&models.NewAccountRequest{Email: email, Password: "password", AccountRole: "janitor"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			rec = create(&models.NewAccountRequest{Email: email, Password: "password", AccountRole: models.LibrarianAccount})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusCreated))
+			account := &models.Account{}
+			Expect(json.NewDecoder(rec.Body).Decode(account)).To(BeNil())
+			Expect(account.AccountRole).To(Equal(models.LibrarianAccount))
+			Expect(account.Status).To(Equal(models.AccountActive))
+			Expect(create(&models.NewAccountRequest{Email: email, Password: "password", AccountRole: models.LibrarianAccount}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+		})
+	})
+	Describe("Login Protection Test", func() {
+		It("Should lock out repeated failures without telling which accounts exist", func() {
+			login := func(email, password string) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(&models.LoginDetails{Email: email, Password: password, AccountRole: models.UserAccount})
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq))
+				req.RemoteAddr = "198.51.100.7:4321"
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+			unknown := login("nobody@lockout.com", "password")
+			wrong := login(userEmail, "wrong password")
+			Expect(unknown.Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(wrong.Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(unknown.Body.String()).To(Equal(wrong.Body.String()))
+
+			for i := 1; i < srv.Env.LoginMaxAttempts; i++ {
+				Expect(login(userEmail, "wrong password").Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			}
+			// locked out, even with the right password
+			rec := login(userEmail, "password")
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusTooManyRequests))
+			Expect(rec.Header().Get("Retry-After")).NotTo(BeEmpty())
+
+			marshalReq, err := json.Marshal(&models.LoginDetails{Email: adminEmail, Password: "password", AccountRole: models.AdminAccount})
+			Expect(err).To(BeNil())
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			user, err := dataStore.GetUserByEmail(userEmail)
+			Expect(err).To(BeNil())
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%d/unlock", user.ID), nil)
+			req.Header.Set("Authorization", "Bearer "+tokens.Token)
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+
+			Expect(login(userEmail, "password").Code).To(BeEquivalentTo(http.StatusOK))
+			Expect(dataStore.ClearLoginFailures(models.LoginScopeIP, "198.51.100.7")).To(BeNil())
+			Expect(dataStore.ClearLoginFailures(models.LoginScopeAccount, "nobody@lockout.com")).To(BeNil())
+		})
+	})
+	Describe("Email Test", func() {
+		var (
+			mailFile string
+			post     func(target string, body interface{}) *httptest.ResponseRecorder
+			lastLink func() string
+		)
+		BeforeEach(func() {
+			dir, err := ioutil.TempDir("", "mail-")
+			Expect(err).To(BeNil())
+			mailFile = filepath.Join(dir, "mail.mbox")
+			srv.Mailer, err = mailer.NewFileMailer(mailFile, env.MailFrom)
+			Expect(err).To(BeNil())
+			post = func(target string, body interface{}) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(body)
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewBuffer(marshalReq)))
+				return rec
+			}
+			lastLink = func() string {
+				data, err := ioutil.ReadFile(mailFile)
+				Expect(err).To(BeNil())
+				tokens := regexp.MustCompile(`token=([A-Za-z0-9_.-]+)`).FindAllStringSubmatch(string(data), -1)
+				Expect(tokens).NotTo(BeEmpty())
+				return tokens[len(tokens)-1][1]
+			}
+		})
+		AfterEach(func() {
+			srv.Env.RequireEmailVerification = false
+			os.RemoveAll(filepath.Dir(mailFile))
+		})
+
+		It("Should verify an email address once", func() {
+			email := "unit@verify.com"
+			srv.Env.RequireEmailVerification = true
+			Expect(post("/register", &models.RegistrationRequest{Email: email, Password: "password"}).Code).
+				To(BeEquivalentTo(http.StatusAccepted))
+			login := &models.LoginDetails{Email: email, Password: "password", AccountRole: models.UserAccount}
+			Expect(post("/login", login).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+
+			token := lastLink()
+			Expect(post("/verify-email", &models.VerifyEmailRequest{Token: token + "x"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(post("/verify-email", &models.VerifyEmailRequest{Token: token}).Code).
+				To(BeEquivalentTo(http.StatusOK))
+			Expect(post("/verify-email", &models.VerifyEmailRequest{Token: token}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			account, err := dataStore.GetUserByEmail(email)
+			Expect(err).To(BeNil())
+			Expect(account.Status).To(Equal(models.AccountActive))
+			Expect(post("/login", login).Code).To(BeEquivalentTo(http.StatusOK))
+			Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+		})
+
+		It("Should reset a password and end the old sessions", func() {
+			email := "unit@reset.com"
+			rec := post("/register", &models.RegistrationRequest{Email: email, Password: "password"})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			session := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(session)).To(BeNil())
+			// a verification token is not a reset token
+			Expect(post("/password-reset/confirm", &models.PasswordResetRequest{Token: lastLink(), Password: "new password"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+
+			// unknown addresses get the same answer and no email
+			Expect(post("/password-reset/request", &models.EmailRequest{Email: "nobody@reset.com"}).Code).
+				To(BeEquivalentTo(http.StatusAccepted))
+			Expect(post("/password-reset/request", &models.EmailRequest{Email: email}).Code).
+				To(BeEquivalentTo(http.StatusAccepted))
+			token := lastLink()
+			Expect(post("/password-reset/confirm", &models.PasswordResetRequest{Token: token, Password: "new password"}).Code).
+				To(BeEquivalentTo(http.StatusOK))
+			Expect(post("/password-reset/confirm", &models.PasswordResetRequest{Token: token, Password: "other password"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+
+			Expect(post("/refresh", &models.RefreshRequest{RefreshToken: session.RefreshToken}).Code).
+				To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(post("/login", &models.LoginDetails{Email: email, Password: "password", AccountRole: models.UserAccount}).Code).
+				To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(post("/login", &models.LoginDetails{Email: email, Password: "new password", AccountRole: models.UserAccount}).Code).
+				To(BeEquivalentTo(http.StatusOK))
+			Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+		})
+	})
+	Describe("MFA Test", func() {
+		It("Should make admins enroll and then ask for a code at every login", func() {
+			email := "unit@mfa.com"
+			hashedPwd, err := password_hash.HashPassword("password")
+			Expect(err).To(BeNil())
+			_, err = dataStore.CreateUserAccount(models.Account{
+				Email:        email,
+				AccountRole:  models.AdminAccount,
+				Status:       models.AccountActive,
+				PasswordHash: hashedPwd,
+			})
+			Expect(err).To(BeNil())
+			srv.Env.RequireAdminMFA = true
+			defer func() {
+				srv.Env.RequireAdminMFA = false
+				Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+			}()
+			post := func(target string, body interface{}) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(body)
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewBuffer(marshalReq)))
+				return rec
+			}
+			challenge := func() *models.MfaChallenge {
+				rec := post("/login", &models.LoginDetails{Email: email, Password: "password", AccountRole: models.AdminAccount})
+				Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+				challenge := &models.MfaChallenge{}
+				Expect(json.NewDecoder(rec.Body).Decode(challenge)).To(BeNil())
+				Expect(challenge.MfaRequired).To(BeTrue())
+				return challenge
+			}
+
+			enroll := challenge()
+			Expect(enroll.EnrollmentRequired).To(BeTrue())
+			rec := post("/login/mfa/enroll", &models.MfaCodeRequest{MfaToken: enroll.MfaToken})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			enrollment := &models.MfaEnrollment{}
+			Expect(json.NewDecoder(rec.Body).Decode(enrollment)).To(BeNil())
+			Expect(enrollment.URI).To(HavePrefix("otpauth://totp/"))
+			Expect(post("/login/mfa/confirm", &models.MfaCodeRequest{MfaToken: enroll.MfaToken, Code: "000000"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+			Expect(err).To(BeNil())
+			rec = post("/login/mfa/confirm", &models.MfaCodeRequest{MfaToken: enroll.MfaToken, Code: code})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			confirmation := &models.MfaConfirmation{}
+			Expect(json.NewDecoder(rec.Body).Decode(confirmation)).To(BeNil())
+			Expect(confirmation.RecoveryCodes).To(HaveLen(10))
+			Expect(confirmation.Response).NotTo(BeNil())
+			Expect(confirmation.Token).NotTo(BeEmpty())
+
+			login := challenge()
+			Expect(login.EnrollmentRequired).To(BeFalse())
+			// the code that confirmed the enrollment cannot be used again
+			Expect(post("/login/mfa", &models.MfaCodeRequest{MfaToken: login.MfaToken, Code: code}).Code).
+				To(BeEquivalentTo(http.StatusUnauthorized))
+			recovery := &models.MfaCodeRequest{MfaToken: login.MfaToken, RecoveryCode: strings.ToUpper(confirmation.RecoveryCodes[0])}
+			rec = post("/login/mfa", recovery)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			Expect(tokens.Token).NotTo(BeEmpty())
+			// the challenge token is spent with the login
+			Expect(post("/login/mfa", recovery).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+
+			login = challenge()
+			recovery.MfaToken = login.MfaToken
+			Expect(post("/login/mfa", recovery).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			code, err = totp.Code(enrollment.Secret, totp.Step(time.Now())+1)
+			Expect(err).To(BeNil())
+			Expect(post("/login/mfa", &models.MfaCodeRequest{MfaToken: login.MfaToken, Code: code}).Code).
+				To(BeEquivalentTo(http.StatusOK))
+		})
+
+		It("Should not accept a time step twice", func() {
+			email := "unit@mfastep.com"
+			account, err := dataStore.CreateUserAccount(models.Account{
+				Email:       email,
+				AccountRole: models.AdminAccount,
+				Status:      models.AccountActive,
+			})
+			Expect(err).To(BeNil())
+			defer func() {
+				Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+			}()
+
+			step := totp.Step(time.Now())
+			Expect(dataStore.UseTotpStep(account.ID, step)).To(BeNil())
+			stored := &models.Account{}
+			Expect(dataStore.Db.Where("id = ?", account.ID).First(stored).Error).To(BeNil())
+			Expect(stored.MfaLastStep).To(BeEquivalentTo(step))
+			// the same step and any earlier one are replays
+			Expect(dataStore.UseTotpStep(account.ID, step)).To(Equal(data_store.ErrMfaCodeUsed))
+			Expect(dataStore.UseTotpStep(account.ID, step-1)).To(Equal(data_store.ErrMfaCodeUsed))
+			Expect(dataStore.UseTotpStep(account.ID, step+1)).To(BeNil())
+		})
+	})
+	Describe("Account Lifecycle Test", func() {
+		It("Should suspend, reactivate and delete accounts", func() {
+			email := "unit@lifecycle.com"
+			hashedPwd, err := password_hash.HashPassword("password")
+			Expect(err).To(BeNil())
+			account, err := dataStore.CreateUserAccount(models.Account{
+				Email:        email,
+				AccountRole:  models.UserAccount,
+				Status:       models.AccountActive,
+				PasswordHash: hashedPwd,
+			})
+			Expect(err).To(BeNil())
+			book := &models.Book{Name: "lifecycleBook", Author: "testAuthor"}
+			Expect(dataStore.Db.Create(book).Error).To(BeNil())
+			defer func() {
+				Expect(dataStore.Db.Exec(`delete from book where id = ?`, book.ID).Error).To(BeNil())
+				Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+			}()
+			login := func(email, password, role string) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(&models.LoginDetails{Email: email, Password: password, AccountRole: role})
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+				return rec
+			}
+			rec := login(adminEmail, "password", models.AdminAccount)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			admin, err := dataStore.GetUserByEmail(adminEmail)
+			Expect(err).To(BeNil())
+			send := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(body)
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(method, target, bytes.NewBuffer(marshalReq))
+				req.Header.Set("Authorization", "Bearer "+tokens.Token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+			suspend := fmt.Sprintf("/admin/users/%d/suspend", account.ID)
+			past := time.Now().Add(-time.Hour)
+			Expect(send(http.MethodPost, suspend, &models.SuspendRequest{}).Code).To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(send(http.MethodPost, suspend, &models.SuspendRequest{Reason: "lost books", Until: &past}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			Expect(send(http.MethodPost, fmt.Sprintf("/admin/users/%d/suspend", admin.ID), &models.SuspendRequest{Reason: "lost books"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+
+			Expect(send(http.MethodPost, suspend, &models.SuspendRequest{Reason: "lost books"}).Code).To(BeEquivalentTo(http.StatusOK))
+			Expect(login(email, "password", models.UserAccount).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(send(http.MethodPost, fmt.Sprintf("/admin/users/%d/reactivate", account.ID), nil).Code).
+				To(BeEquivalentTo(http.StatusOK))
+			Expect(login(email, "password", models.UserAccount).Code).To(BeEquivalentTo(http.StatusOK))
+			Expect(send(http.MethodPost, fmt.Sprintf("/admin/users/%d/reactivate", account.ID), nil).Code).
+				To(BeEquivalentTo(http.StatusConflict))
+			// reactivation only lifts a suspension, it does not stand in for email verification
+			pending, err := dataStore.CreateUserAccount(models.Account{
+				Email:       "unit@pending.com",
+				AccountRole: models.UserAccount,
+				Status:      models.AccountPending,
+			})
+			Expect(err).To(BeNil())
+			defer func() {
+				Expect(cleanTestData(dataStore.Db, pending.Email, pending.Email)).To(BeNil())
+			}()
+			Expect(send(http.MethodPost, fmt.Sprintf("/admin/users/%d/reactivate", pending.ID), nil).Code).
+				To(BeEquivalentTo(http.StatusConflict))
+			Expect(dataStore.Db.Where("id = ?", pending.ID).First(pending).Error).To(BeNil())
+			Expect(pending.Status).To(BeEquivalentTo(models.AccountPending))
+
+			// accounts with borrowed books cannot be closed
+			loan := &models.BookHistory{UserID: account.ID, BookID: book.ID, Status: "borrowed"}
+			Expect(dataStore.Db.Create(loan).Error).To(BeNil())
+			Expect(send(http.MethodDelete, fmt.Sprintf("/admin/users/%d", account.ID), nil).Code).
+				To(BeEquivalentTo(http.StatusConflict))
+			Expect(dataStore.Db.Delete(loan).Error).To(BeNil())
+			Expect(send(http.MethodDelete, fmt.Sprintf("/admin/users/%d", account.ID), nil).Code).
+				To(BeEquivalentTo(http.StatusOK))
+			Expect(login(email, "password", models.UserAccount).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+		})
+	})
+	Describe("Profile Test", func() {
+		It("Should let readers keep their own profile and password", func() {
+			email := "unit@profile.com"
+			hashedPwd, err := password_hash.HashPassword("password")
+			Expect(err).To(BeNil())
+			_, err = dataStore.CreateUserAccount(models.Account{
+				Email:        email,
+				AccountRole:  models.UserAccount,
+				Status:       models.AccountActive,
+				PasswordHash: hashedPwd,
+			})
+			Expect(err).To(BeNil())
+			defer func() {
+				Expect(cleanTestData(dataStore.Db, email, email)).To(BeNil())
+			}()
+			login := func(password string) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(&models.LoginDetails{Email: email, Password: password, AccountRole: models.UserAccount})
+				Expect(err).To(BeNil())
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+				return rec
+			}
+			rec := login("password")
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			send := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+				marshalReq, err := json.Marshal(body)
+				Expect(err).To(BeNil())
+				req := httptest.NewRequest(method, target, bytes.NewBuffer(marshalReq))
+				req.Header.Set("Authorization", "Bearer "+tokens.Token)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec = send(http.MethodGet, "/me", nil)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			profile := &models.Account{}
+			Expect(json.NewDecoder(rec.Body).Decode(profile)).To(BeNil())
+			Expect(profile.Email).To(Equal(email))
+			Expect(profile.ReservedBooks).To(BeZero())
+			Expect(profile.OverdueBooks).To(BeZero())
+
+			Expect(send(http.MethodPut, "/me", &models.ProfileRequest{Phone: "not a phone"}).Code).
+				To(BeEquivalentTo(http.StatusBadRequest))
+			rec = send(http.MethodPut, "/me", &models.ProfileRequest{
+				DisplayName: " Unit Profile ",
+				Phone:       "+1 555-0100",
+				MemberID:    "S-1234",
+			})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			profile = &models.Account{}
+			Expect(json.NewDecoder(rec.Body).Decode(profile)).To(BeNil())
+			Expect(profile.DisplayName).To(Equal("Unit Profile"))
+			Expect(profile.MemberID).NotTo(BeNil())
+			Expect(*profile.MemberID).To(Equal("S-1234"))
+			Expect(profile.AccountRole).To(Equal(models.UserAccount))
+
+			Expect(send(http.MethodPost, "/me/password", &models.PasswordChangeRequest{
+				CurrentPassword: "wrong password",
+				NewPassword:     "new password",
+			}).Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(send(http.MethodPost, "/me/password", &models.PasswordChangeRequest{
+				CurrentPassword: "password",
+				NewPassword:     "short",
+			}).Code).To(BeEquivalentTo(http.StatusBadRequest))
+			rec = send(http.MethodPost, "/me/password", &models.PasswordChangeRequest{
+				CurrentPassword: "password",
+				NewPassword:     "new password",
+			})
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			session := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(session)).To(BeNil())
+			Expect(session.RefreshToken).NotTo(BeEmpty())
+
+			// the refresh token from before the change no longer works
+			marshalReq, err := json.Marshal(&models.RefreshRequest{RefreshToken: tokens.RefreshToken})
+			Expect(err).To(BeNil())
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewBuffer(marshalReq)))
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(login("password").Code).To(BeEquivalentTo(http.StatusUnauthorized))
+			Expect(login("new password").Code).To(BeEquivalentTo(http.StatusOK))
+		})
+	})
+	Describe("Signing Keys Test", func() {
+		It("Should sign with ES256 and publish the key as JWKS", func() {
+			private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).To(BeNil())
+			der, err := x509.MarshalECPrivateKey(private)
+			Expect(err).To(BeNil())
+			keyFile, err := ioutil.TempFile("", "jwt-key-")
+			Expect(err).To(BeNil())
+			defer os.Remove(keyFile.Name())
+			Expect(pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})).To(BeNil())
+			Expect(keyFile.Close()).To(BeNil())
+
+			env.JwtPrivateKeyFile = keyFile.Name()
+			defer func() {
+				env.JwtPrivateKeyFile = ""
+				srv.SigningKey, srv.Keys = nil, nil
+				middleware.SetKeyResolver(nil)
+			}()
+			srv.SigningKey, srv.Keys, err = jwtkeys.FromConfig(env.AuthConfig)
+			Expect(err).To(BeNil())
+			middleware.SetKeyResolver(srv.Keys)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			published, err := jwtkeys.ParseJWKS(rec.Body.Bytes())
+			Expect(err).To(BeNil())
+			Expect(published).To(HaveKey(srv.SigningKey.ID))
+
+			marshalReq, err := json.Marshal(&models.LoginDetails{
+				Email:       adminEmail,
+				Password:    "password",
+				AccountRole: models.AdminAccount,
+			})
+			Expect(err).To(BeNil())
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(marshalReq)))
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+			tokens := &models.Response{}
+			Expect(json.NewDecoder(rec.Body).Decode(tokens)).To(BeNil())
+			Expect(middleware.ValidateSignedToken(&models.AuthInfo{}, tokens.Token, published)).To(BeNil())
+
+			req := httptest.NewRequest(http.MethodGet, "/get/users", nil)
+			req.Header.Set("Authorization", "Bearer "+tokens.Token)
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusOK))
+
+			// HMAC tokens are refused once keys are in use
+			hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"id":   1,
+				"role": models.AdminAccount,
+				"exp":  time.Now().Add(time.Hour).Unix(),
+			}).SignedString([]byte(env.JwtSigningKey))
+			Expect(err).To(BeNil())
+			req = httptest.NewRequest(http.MethodGet, "/get/users", nil)
+			req.Header.Set("Authorization", "Bearer "+hmacToken)
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			Expect(rec.Code).To(BeEquivalentTo(http.StatusUnauthorized))
+		})
 	})
 	AfterSuite(func() {
 		err = cleanTestData(dataStore.Db, adminEmail, userEmail)
@@ -0,0 +1,148 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var ErrAccountTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// CreateAccountToken stores a token mailed to userID. Unused tokens of the
+// same purpose are retired, so only the most recent email works.
+func (ds *DataStore) CreateAccountToken(userID uint, purpose, tokenHash string, now, expiresAt time.Time) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.AccountToken{}).
+			Where("user_id = ? and purpose = ? and used_at is null", userID, purpose).
+			Update("usedAt", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.AccountToken{
+			CreatedAt: now,
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+}
+
+// VerifyEmail redeems an email verification token and activates the account
+// it was sent for.
+func (ds *DataStore) VerifyEmail(tokenHash string, now time.Time) (*models.Account, error) {
+	account := &models.Account{}
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		token, err := redeemAccountToken(tx, models.VerifyEmailToken, tokenHash, now)
+		if err != nil {
+			return err
+		}
+		err = activate(tx, token.UserID)
+		if err != nil {
+			return err
+		}
+		return accountOf(tx, token, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ResetPassword redeems a password reset token and replaces the password of
+// its account. Every refresh token of the account is revoked so sessions
+// opened with the old password end. The reset link reached the owner's
+// mailbox, so a pending account is activated as well.
+func (ds *DataStore) ResetPassword(tokenHash, passwordHash string, now time.Time) (*models.Account, error) {
+	account := &models.Account{}
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		token, err := redeemAccountToken(tx, models.PasswordResetToken, tokenHash, now)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Account{}).Where("id = ?", token.UserID).
+			Update("passwordHash", passwordHash).Error
+		if err != nil {
+			return err
+		}
+		err = activate(tx, token.UserID)
+		if err != nil {
+			return err
+		}
+		err = revokeUserSessions(tx, token.UserID, now)
+		if err != nil {
+			return err
+		}
+		return accountOf(tx, token, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// GetAccountToken returns an unused, unexpired token without redeeming it,
+// for steps that may be retried before the token is spent.
+func (ds *DataStore) GetAccountToken(purpose, tokenHash string, now time.Time) (*models.AccountToken, error) {
+	token := &models.AccountToken{}
+	err := ds.Db.Where("token_hash = ? and purpose = ?", tokenHash, purpose).First(token).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrAccountTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrAccountTokenInvalid
+	}
+	return token, nil
+}
+
+// RedeemAccountToken spends a token; a token that is already spent or has
+// expired gives ErrAccountTokenInvalid.
+func (ds *DataStore) RedeemAccountToken(purpose, tokenHash string, now time.Time) (*models.AccountToken, error) {
+	var token *models.AccountToken
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = redeemAccountToken(tx, purpose, tokenHash, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func redeemAccountToken(tx *gorm.DB, purpose, tokenHash string, now time.Time) (*models.AccountToken, error) {
+	token := &models.AccountToken{}
+	err := forUpdate(tx).Where("token_hash = ? and purpose = ?", tokenHash, purpose).First(token).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrAccountTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrAccountTokenInvalid
+	}
+	err = tx.Model(token).Where("id = ?", token.ID).Update("usedAt", now).Error
+	return token, err
+}
+
+func activate(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.Account{}).
+		Where("id = ? and status = ?", userID, models.AccountPending).
+		Update("status", models.AccountActive).Error
+}
+
+// accountOf loads the account a token was issued for; a token of an account
+// deleted since is as good as invalid.
+func accountOf(tx *gorm.DB, token *models.AccountToken, account *models.Account) error {
+	err := tx.Where("id = ?", token.UserID).First(account).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrAccountTokenInvalid
+	}
+	return err
+}
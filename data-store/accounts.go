@@ -0,0 +1,194 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrAccountInactive     = errors.New("account is suspended or closed")
+	ErrAccountHasLoans     = errors.New("account still has borrowed books")
+	ErrDuplicateMember     = errors.New("student or staff ID is already in use")
+	ErrAccountNotSuspended = errors.New("account is not suspended")
+)
+
+// AccountSuspended reports whether account is suspended at now. A suspension
+// with an end date lifts itself once that date has passed.
+func AccountSuspended(account *models.Account, now time.Time) bool {
+	if account.Status != models.AccountSuspended {
+		return false
+	}
+	return account.SuspendedUntil == nil || now.Before(*account.SuspendedUntil)
+}
+
+// SuspendAccount suspends an account until the given time, or until it is
+// reactivated when until is nil, and ends its sessions.
+func (ds *DataStore) SuspendAccount(userID uint, reason string, until *time.Time, now time.Time) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ?", userID).First(&models.Account{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Account{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"status":          models.AccountSuspended,
+			"suspendedReason": reason,
+			"suspendedUntil":  until,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, userID, now)
+	})
+}
+
+// ReactivateAccount lifts the suspension of an account. Any other status is
+// left alone, so a pending account still has to verify its email.
+func (ds *DataStore) ReactivateAccount(userID uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ?", userID).First(&models.Account{}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.Account{}).Where("id = ? and status = ?", userID, models.AccountSuspended).
+			Updates(map[string]interface{}{
+				"status":          models.AccountActive,
+				"suspendedReason": "",
+				"suspendedUntil":  nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAccountNotSuspended
+		}
+		return nil
+	})
+}
+
+// DeleteAccount soft-deletes an account that has no borrowed books. Its holds
+// are cancelled, handing copies set aside for it to the next reader in line,
+// and its sessions end. Loan history and fines stay for the records.
+func (ds *DataStore) DeleteAccount(userID uint, now time.Time, pickupDeadline *time.Time) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		var holds []models.BookQueue
+		err := tx.Where("user_id = ? and status in (?)", userID, []string{models.HoldWaiting, models.HoldReady}).
+			Order("book_id").Find(&holds).Error
+		if err != nil {
+			return err
+		}
+		// books are locked before the account, in the order ReserveBook uses,
+		// and the holds read again once nothing can change them
+		for _, hold := range holds {
+			_, err = lockBook(tx, hold.BookID)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Where("user_id = ? and status in (?)", userID, []string{models.HoldWaiting, models.HoldReady}).
+			Find(&holds).Error
+		if err != nil {
+			return err
+		}
+		account := &models.Account{}
+		err = forUpdate(tx).Where("id = ?", userID).First(account).Error
+		if err != nil {
+			return err
+		}
+		var activeLoans int
+		err = tx.Model(&models.BookHistory{}).Where("user_id = ? and status in (?)", userID,
+			[]string{"borrowed", "overdue"}).Count(&activeLoans).Error
+		if err != nil {
+			return err
+		}
+		if activeLoans > 0 {
+			return ErrAccountHasLoans
+		}
+		for _, hold := range holds {
+			err = tx.Model(&models.BookQueue{}).Where("id = ?", hold.ID).Updates(map[string]interface{}{
+				"status": models.HoldCancelled,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if hold.Status == models.HoldReady {
+				err = allocateReturnedCopy(tx, hold.BookID, hold.CopyID, pickupDeadline)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = revokeUserSessions(tx, userID, now)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(account).Error
+	})
+}
+
+// GetProfile returns an account with ReservedBooks and OverdueBooks counted
+// from its current loans.
+func (ds *DataStore) GetProfile(userID uint) (*models.Account, error) {
+	account := &models.Account{}
+	err := ds.Db.Where("id = ?", userID).First(account).Error
+	if err != nil {
+		return nil, err
+	}
+	var counts struct {
+		Loans   uint
+		Overdue uint
+	}
+	err = ds.Db.Raw(`select count(*) as loans, coalesce(sum(status = 'overdue'), 0) as overdue
+		from book_history where user_id = ? and status in ('borrowed', 'overdue')`, userID).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	account.ReservedBooks = counts.Loans
+	account.OverdueBooks = counts.Overdue
+	return account, nil
+}
+
+// UpdateProfile replaces the profile details of an account. An empty MemberID
+// clears it.
+func (ds *DataStore) UpdateProfile(userID uint, profile models.ProfileRequest) (*models.Account, error) {
+	err := ds.Db.Where("id = ?", userID).First(&models.Account{}).Error
+	if err != nil {
+		return nil, err
+	}
+	var memberID *string
+	if profile.MemberID != "" {
+		memberID = &profile.MemberID
+	}
+	err = ds.Db.Model(&models.Account{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"displayName": profile.DisplayName,
+		"phone":       profile.Phone,
+		"memberID":    memberID,
+	}).Error
+	if isDuplicateEntry(err) {
+		return nil, ErrDuplicateMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ds.GetProfile(userID)
+}
+
+// ChangePassword sets a new password hash and ends all sessions of the account.
+func (ds *DataStore) ChangePassword(userID uint, passwordHash string, now time.Time) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Account{}).Where("id = ?", userID).
+			Update("passwordHash", passwordHash).Error
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, userID, now)
+	})
+}
+
+func revokeUserSessions(tx *gorm.DB, userID uint, at time.Time) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? and revoked_at is null", userID).
+		Update("revokedAt", at).Error
+}
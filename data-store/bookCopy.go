@@ -0,0 +1,163 @@
+package data_store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrCopyInUse         = errors.New("copy is on loan or on hold")
+	ErrInvalidCopyStatus = errors.New("copy status can only be available, damaged or lost")
+	ErrDuplicateBarcode  = errors.New("a copy with this barcode already exists")
+)
+
+func (ds *DataStore) CreateBookCopy(bookCopy models.BookCopy) (*models.BookCopy, error) {
+	if bookCopy.Status == "" {
+		bookCopy.Status = models.CopyAvailable
+	}
+	if bookCopy.Condition == "" {
+		bookCopy.Condition = "good"
+	}
+	if !shelfStatus(bookCopy.Status) {
+		return nil, ErrInvalidCopyStatus
+	}
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookCopy.BookID)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&bookCopy).Error
+		if isDuplicateEntry(err) {
+			return ErrDuplicateBarcode
+		}
+		if err != nil {
+			return err
+		}
+		return syncStock(tx, bookCopy.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bookCopy, nil
+}
+
+func (ds *DataStore) GetBookCopies(bookID uint) (*[]models.BookCopy, error) {
+	var copies []models.BookCopy
+	err := ds.Db.Where("book_id = ?", bookID).Order("id").Find(&copies).Error
+	return &copies, err
+}
+
+func (ds *DataStore) GetBookCopyByID(id uint) (*models.BookCopy, error) {
+	bookCopy := &models.BookCopy{}
+	err := ds.Db.Where("id = ?", id).First(bookCopy).Error
+	return bookCopy, err
+}
+
+func (ds *DataStore) UpdateBookCopy(id uint, barcode, condition, shelfLocation string,
+	acquisitionDate *time.Time, status string) error {
+	bookCopy := &models.BookCopy{}
+	err := ds.Db.Where("id = ?", id).First(bookCopy).Error
+	if err != nil {
+		return err
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookCopy.BookID)
+		if err != nil {
+			return err
+		}
+		err = forUpdate(tx).Where("id = ?", id).First(bookCopy).Error
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			"barcode":          barcode,
+			"copy_condition":   condition,
+			"shelf_location":   shelfLocation,
+			"acquisition_date": acquisitionDate,
+		}
+		if status != "" && status != bookCopy.Status {
+			if !shelfStatus(bookCopy.Status) {
+				return ErrCopyInUse
+			}
+			if !shelfStatus(status) {
+				return ErrInvalidCopyStatus
+			}
+			updates["status"] = status
+		}
+		err = tx.Model(bookCopy).Where("id = ?", id).Updates(updates).Error
+		if isDuplicateEntry(err) {
+			return ErrDuplicateBarcode
+		}
+		if err != nil {
+			return err
+		}
+		return syncStock(tx, bookCopy.BookID)
+	})
+}
+
+func (ds *DataStore) DeleteBookCopy(id uint) error {
+	bookCopy := &models.BookCopy{}
+	err := ds.Db.Where("id = ?", id).First(bookCopy).Error
+	if err != nil {
+		return err
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookCopy.BookID)
+		if err != nil {
+			return err
+		}
+		err = forUpdate(tx).Where("id = ?", id).First(bookCopy).Error
+		if err != nil {
+			return err
+		}
+		if !shelfStatus(bookCopy.Status) {
+			return ErrCopyInUse
+		}
+		err = tx.Delete(bookCopy).Error
+		if err != nil {
+			return err
+		}
+		return syncStock(tx, bookCopy.BookID)
+	})
+}
+
+// shelfStatus reports whether a copy status may be set by hand; borrowed and
+// held are only ever entered through the loan and hold workflows.
+func shelfStatus(status string) bool {
+	return status == models.CopyAvailable || status == models.CopyDamaged || status == models.CopyLost
+}
+
+// syncStock recomputes book.stock from the copies that are currently on the shelf.
+func syncStock(db *gorm.DB, bookID uint) error {
+	return db.Exec(`update book set stock = (
+		select count(*) from book_copy where book_id = ? and status = ? and deleted_at is null
+	) where id = ?`, bookID, models.CopyAvailable, bookID).Error
+}
+
+func createCopies(db *gorm.DB, bookID, count uint) error {
+	// number new copies after every copy the book ever had, deleted ones included
+	var existing uint
+	err := db.Unscoped().Model(&models.BookCopy{}).Where("book_id = ?", bookID).Count(&existing).Error
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := existing + 1; i <= existing+count; i++ {
+		bookCopy := &models.BookCopy{
+			BookID:          bookID,
+			Barcode:         fmt.Sprintf("LIB-%d-%d", bookID, i),
+			Condition:       "good",
+			AcquisitionDate: &now,
+			Status:          models.CopyAvailable,
+		}
+		err := db.Create(bookCopy).Error
+		if err != nil {
+			return err
+		}
+	}
+	return syncStock(db, bookID)
+}
@@ -0,0 +1,152 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrBookQueued     = errors.New("book unavailable, added to hold queue")
+	ErrAlreadyQueued  = errors.New("book already on hold for this user")
+	ErrHoldNotActive  = errors.New("hold is no longer active")
+	ErrHoldNotAllowed = errors.New("hold belongs to another user")
+)
+
+func (ds *DataStore) GetHoldsByUser(userID uint) (*[]models.BookQueue, error) {
+	var holds []models.BookQueue
+	err := ds.Db.Where("user_id = ?", userID).Order("id desc").Find(&holds).Error
+	return &holds, err
+}
+
+func (ds *DataStore) GetBookQueue(bookID uint) (*[]models.BookQueue, error) {
+	var holds []models.BookQueue
+	err := ds.Db.Where("book_id = ? and status in (?)", bookID, []string{models.HoldWaiting, models.HoldReady}).
+		Order("id").Find(&holds).Error
+	return &holds, err
+}
+
+func (ds *DataStore) GetQueuePosition(bookID, userID uint) (*models.QueuePosition, error) {
+	holds, err := ds.GetBookQueue(bookID)
+	if err != nil {
+		return nil, err
+	}
+	position := &models.QueuePosition{
+		BookID:      bookID,
+		UserID:      userID,
+		QueueLength: len(*holds),
+	}
+	for i, hold := range *holds {
+		if hold.UserID == userID {
+			position.HoldID = hold.ID
+			position.Status = hold.Status
+			position.Position = i + 1
+			position.PickupDeadline = hold.PickupDeadline
+			return position, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (ds *DataStore) CancelHold(holdID, userID uint, pickupDeadline *time.Time) error {
+	hold := &models.BookQueue{}
+	err := ds.Db.Where("id = ?", holdID).First(hold).Error
+	if err != nil {
+		return err
+	}
+	if hold.UserID != userID {
+		return ErrHoldNotAllowed
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, hold.BookID)
+		if err != nil {
+			return err
+		}
+		err = forUpdate(tx).Where("id = ?", holdID).First(hold).Error
+		if err != nil {
+			return err
+		}
+		if hold.Status != models.HoldWaiting && hold.Status != models.HoldReady {
+			return ErrHoldNotActive
+		}
+		err = tx.Model(hold).Where("id = ?", holdID).Updates(map[string]interface{}{
+			"status": models.HoldCancelled,
+		}).Error
+		if err != nil {
+			return err
+		}
+		// a ready hold already has a copy set aside, so it moves on to the next reader
+		if hold.Status == models.HoldReady {
+			return allocateReturnedCopy(tx, hold.BookID, hold.CopyID, pickupDeadline)
+		}
+		return nil
+	})
+}
+
+func (ds *DataStore) ExpireHolds(currentTime, pickupDeadline *time.Time) (int, error) {
+	var holds []models.BookQueue
+	err := ds.Db.Where("status = ? and pickup_deadline < ?", models.HoldReady, currentTime).Find(&holds).Error
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, hold := range holds {
+		changed := false
+		err = ds.withTransaction(func(tx *gorm.DB) error {
+			_, err := lockBook(tx, hold.BookID)
+			if err != nil {
+				return err
+			}
+			// the reader may have picked the book up since the hold was listed
+			result := tx.Model(&models.BookQueue{}).Where("id = ? and status = ?", hold.ID, models.HoldReady).
+				Updates(map[string]interface{}{
+					"status": models.HoldExpired,
+				})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			changed = true
+			return allocateReturnedCopy(tx, hold.BookID, hold.CopyID, pickupDeadline)
+		})
+		if err != nil {
+			return expired, err
+		}
+		if changed {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// allocateReturnedCopy hands a copy that came back to the head of the hold
+// queue, or puts it back on the shelf when nobody is waiting.
+func allocateReturnedCopy(db *gorm.DB, bookID, copyID uint, pickupDeadline *time.Time) error {
+	next := &models.BookQueue{}
+	err := db.Where("book_id = ? and status = ?", bookID, models.HoldWaiting).Order("id").First(next).Error
+	if err == gorm.ErrRecordNotFound {
+		err = db.Model(&models.BookCopy{}).Where("id = ?", copyID).Updates(map[string]interface{}{
+			"status": models.CopyAvailable,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return syncStock(db, bookID)
+	}
+	if err != nil {
+		return err
+	}
+	err = db.Model(&models.BookCopy{}).Where("id = ?", copyID).Updates(map[string]interface{}{
+		"status": models.CopyHeld,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(next).Where("id = ?", next.ID).Updates(map[string]interface{}{
+		"status":          models.HoldReady,
+		"copy_id":         copyID,
+		"ready_date":      time.Now(),
+		"pickup_deadline": pickupDeadline,
+	}).Error
+}
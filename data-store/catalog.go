@@ -0,0 +1,465 @@
+package data_store
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrEmptyName           = errors.New("name is required")
+	ErrDuplicateName       = errors.New("name already exists")
+	ErrCategoryCycle       = errors.New("category cannot be moved under itself")
+	ErrCategoryHasChildren = errors.New("category still has sub-categories")
+)
+
+func (ds *DataStore) CreateAuthor(author models.Author) (*models.Author, error) {
+	author.ID = 0
+	author.Name = strings.TrimSpace(author.Name)
+	if author.Name == "" {
+		return nil, ErrEmptyName
+	}
+	err := ds.Db.Create(&author).Error
+	if isDuplicateEntry(err) {
+		return nil, ErrDuplicateName
+	}
+	return &author, err
+}
+
+func (ds *DataStore) GetAuthors() (*[]models.Author, error) {
+	var authors []models.Author
+	err := ds.Db.Order("name").Find(&authors).Error
+	return &authors, err
+}
+
+func (ds *DataStore) GetAuthorByID(id uint) (*models.Author, error) {
+	author := &models.Author{}
+	err := ds.Db.Where("id = ?", id).First(author).Error
+	return author, err
+}
+
+func (ds *DataStore) GetBookAuthors(bookID uint) (*[]models.Author, error) {
+	var authors []models.Author
+	err := ds.Db.Joins("inner join book_author on book_author.author_id = author.id").
+		Where("book_author.book_id = ?", bookID).Order("book_author.position").Find(&authors).Error
+	return &authors, err
+}
+
+// UpdateAuthor renames an author; every book credited to them picks up the new
+// name.
+func (ds *DataStore) UpdateAuthor(id uint, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptyName
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		bookIDs, err := lockLinkedBooks(tx, "book_author", "author_id", id)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Author{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"name": name,
+		}).Error
+		if isDuplicateEntry(err) {
+			return ErrDuplicateName
+		}
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, bookIDs)
+	})
+}
+
+func (ds *DataStore) DeleteAuthor(id uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		bookIDs, err := lockLinkedBooks(tx, "book_author", "author_id", id)
+		if err != nil {
+			return err
+		}
+		err = tx.Where("id = ?", id).Delete(&models.Author{}).Error
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, bookIDs)
+	})
+}
+
+func (ds *DataStore) SetBookAuthors(bookID uint, authorIDs []uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		err = setBookAuthors(tx, bookID, authorIDs)
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, []uint{bookID})
+	})
+}
+
+func (ds *DataStore) GetBooksByAuthorID(authorID uint) (*[]models.Book, error) {
+	var books []models.Book
+	err := ds.Db.Joins("inner join book_author on book_author.book_id = book.id").
+		Where("book_author.author_id = ?", authorID).Order("book.id").Find(&books).Error
+	return &books, err
+}
+
+func (ds *DataStore) CreateCategory(category models.Category) (*models.Category, error) {
+	category.ID = 0
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return nil, ErrEmptyName
+	}
+	if category.ParentID != nil {
+		err := ds.Db.Where("id = ?", *category.ParentID).First(&models.Category{}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := ds.Db.Create(&category).Error
+	return &category, err
+}
+
+func (ds *DataStore) GetCategories() (*[]models.Category, error) {
+	var categories []models.Category
+	err := ds.Db.Order("id").Find(&categories).Error
+	return &categories, err
+}
+
+func (ds *DataStore) GetCategoryByID(id uint) (*models.Category, error) {
+	category := &models.Category{}
+	err := ds.Db.Where("id = ?", id).First(category).Error
+	return category, err
+}
+
+// UpdateCategory renames a category and moves it under parentID, or to the
+// root when parentID is nil. A category cannot be moved into its own subtree.
+func (ds *DataStore) UpdateCategory(id uint, name string, parentID *uint) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptyName
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		bookIDs, err := lockLinkedBooks(tx, "book_category", "category_id", id)
+		if err != nil {
+			return err
+		}
+		err = forUpdate(tx).Where("id = ?", id).First(&models.Category{}).Error
+		if err != nil {
+			return err
+		}
+		for ancestor := parentID; ancestor != nil; {
+			if *ancestor == id {
+				return ErrCategoryCycle
+			}
+			parent := &models.Category{}
+			err = tx.Where("id = ?", *ancestor).First(parent).Error
+			if err != nil {
+				return err
+			}
+			ancestor = parent.ParentID
+		}
+		err = tx.Model(&models.Category{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"name":     name,
+			"parentId": parentID,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, bookIDs)
+	})
+}
+
+func (ds *DataStore) DeleteCategory(id uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		bookIDs, err := lockLinkedBooks(tx, "book_category", "category_id", id)
+		if err != nil {
+			return err
+		}
+		var children int
+		err = tx.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children).Error
+		if err != nil {
+			return err
+		}
+		if children > 0 {
+			return ErrCategoryHasChildren
+		}
+		err = tx.Where("id = ?", id).Delete(&models.Category{}).Error
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, bookIDs)
+	})
+}
+
+func (ds *DataStore) SetBookCategories(bookID uint, categoryIDs []uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		err = setBookCategories(tx, bookID, categoryIDs)
+		if err != nil {
+			return err
+		}
+		return syncBookNames(tx, []uint{bookID})
+	})
+}
+
+// GetBooksByCategory returns the books filed under a category or any of its
+// sub-categories.
+func (ds *DataStore) GetBooksByCategory(categoryID uint) (*[]models.Book, error) {
+	var books []models.Book
+	err := ds.Db.Where(`book.id in (
+		select book_category.book_id from book_category where book_category.category_id in (
+			with recursive subtree (id) as (
+				select id from category where id = ?
+				union all
+				select category.id from category inner join subtree on category.parent_id = subtree.id
+			)
+			select id from subtree
+		)
+	)`, categoryID).Order("book.id").Find(&books).Error
+	return &books, err
+}
+
+func (ds *DataStore) CreatePublisher(publisher models.Publisher) (*models.Publisher, error) {
+	publisher.ID = 0
+	publisher.Name = strings.TrimSpace(publisher.Name)
+	if publisher.Name == "" {
+		return nil, ErrEmptyName
+	}
+	err := ds.Db.Create(&publisher).Error
+	if isDuplicateEntry(err) {
+		return nil, ErrDuplicateName
+	}
+	return &publisher, err
+}
+
+func (ds *DataStore) GetPublishers() (*[]models.Publisher, error) {
+	var publishers []models.Publisher
+	err := ds.Db.Order("name").Find(&publishers).Error
+	return &publishers, err
+}
+
+func (ds *DataStore) GetPublisherByID(id uint) (*models.Publisher, error) {
+	publisher := &models.Publisher{}
+	err := ds.Db.Where("id = ?", id).First(publisher).Error
+	return publisher, err
+}
+
+func (ds *DataStore) GetBookPublishers(bookID uint) (*[]models.Publisher, error) {
+	var publishers []models.Publisher
+	err := ds.Db.Joins("inner join book_publisher on book_publisher.publisher_id = publisher.id").
+		Where("book_publisher.book_id = ?", bookID).Order("publisher.name").Find(&publishers).Error
+	return &publishers, err
+}
+
+func (ds *DataStore) UpdatePublisher(id uint, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptyName
+	}
+	publisher := &models.Publisher{}
+	err := ds.Db.Where("id = ?", id).First(publisher).Error
+	if err != nil {
+		return err
+	}
+	err = ds.Db.Model(publisher).Where("id = ?", id).Updates(map[string]interface{}{
+		"name": name,
+	}).Error
+	if isDuplicateEntry(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (ds *DataStore) DeletePublisher(id uint) error {
+	publisher := &models.Publisher{}
+	err := ds.Db.Where("id = ?", id).First(publisher).Error
+	if err != nil {
+		return err
+	}
+	return ds.Db.Where("id = ?", id).Delete(&models.Publisher{}).Error
+}
+
+func (ds *DataStore) SetBookPublishers(bookID uint, publisherIDs []uint) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		publisherIDs = uniqueIDs(publisherIDs)
+		err = checkIDs(tx, &models.Publisher{}, publisherIDs)
+		if err != nil {
+			return err
+		}
+		err = tx.Where("book_id = ?", bookID).Delete(&models.BookPublisher{}).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range publisherIDs {
+			err = tx.Create(&models.BookPublisher{BookID: bookID, PublisherID: id}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *DataStore) GetBooksByPublisherID(publisherID uint) (*[]models.Book, error) {
+	var books []models.Book
+	err := ds.Db.Joins("inner join book_publisher on book_publisher.book_id = book.id").
+		Where("book_publisher.publisher_id = ?", publisherID).Order("book.id").Find(&books).Error
+	return &books, err
+}
+
+// linkBookNames links a book to the authors and categories named in its
+// comma separated free-text fields, creating the ones that do not exist yet.
+func linkBookNames(tx *gorm.DB, bookID uint, authorNames, categoryNames string) error {
+	var authorIDs []uint
+	for _, name := range splitNames(authorNames) {
+		author := &models.Author{}
+		err := tx.Where(models.Author{Name: name}).FirstOrCreate(author).Error
+		if err != nil {
+			return err
+		}
+		authorIDs = append(authorIDs, author.ID)
+	}
+	err := setBookAuthors(tx, bookID, authorIDs)
+	if err != nil {
+		return err
+	}
+	var categoryIDs []uint
+	for _, name := range splitNames(categoryNames) {
+		// prefer a root category when the same name is used at several levels
+		category := &models.Category{}
+		err = tx.Where("name = ?", name).Order("parent_id is not null, id").First(category).Error
+		if err == gorm.ErrRecordNotFound {
+			category.Name = name
+			err = tx.Create(category).Error
+		}
+		if err != nil {
+			return err
+		}
+		categoryIDs = append(categoryIDs, category.ID)
+	}
+	err = setBookCategories(tx, bookID, categoryIDs)
+	if err != nil {
+		return err
+	}
+	return syncBookNames(tx, []uint{bookID})
+}
+
+func setBookAuthors(tx *gorm.DB, bookID uint, authorIDs []uint) error {
+	authorIDs = uniqueIDs(authorIDs)
+	err := checkIDs(tx, &models.Author{}, authorIDs)
+	if err != nil {
+		return err
+	}
+	err = tx.Where("book_id = ?", bookID).Delete(&models.BookAuthor{}).Error
+	if err != nil {
+		return err
+	}
+	for i, id := range authorIDs {
+		err = tx.Create(&models.BookAuthor{BookID: bookID, AuthorID: id, Position: i}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setBookCategories(tx *gorm.DB, bookID uint, categoryIDs []uint) error {
+	categoryIDs = uniqueIDs(categoryIDs)
+	err := checkIDs(tx, &models.Category{}, categoryIDs)
+	if err != nil {
+		return err
+	}
+	err = tx.Where("book_id = ?", bookID).Delete(&models.BookCategory{}).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range categoryIDs {
+		err = tx.Create(&models.BookCategory{BookID: bookID, CategoryID: id}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncBookNames rewrites the author and category display names of the given
+// books from their links.
+func syncBookNames(db *gorm.DB, bookIDs []uint) error {
+	if len(bookIDs) == 0 {
+		return nil
+	}
+	return db.Exec(`update book set
+		author = coalesce((
+			select group_concat(author.name order by book_author.position separator ', ')
+			from book_author inner join author on author.id = book_author.author_id
+			where book_author.book_id = book.id
+		), ''),
+		category = coalesce((
+			select group_concat(category.name order by category.id separator ', ')
+			from book_category inner join category on category.id = book_category.category_id
+			where book_category.book_id = book.id
+		), '')
+	where id in (?)`, bookIDs).Error
+}
+
+// lockLinkedBooks locks, in id order, the books linked to a row of a join
+// table so renames and deletes take the book locks before anything else.
+func lockLinkedBooks(tx *gorm.DB, table, column string, id uint) ([]uint, error) {
+	var bookIDs []uint
+	err := tx.Table(table).Where(column+" = ?", id).Order("book_id").Pluck("book_id", &bookIDs).Error
+	if err != nil || len(bookIDs) == 0 {
+		return bookIDs, err
+	}
+	var books []models.Book
+	err = forUpdate(tx).Unscoped().Where("id in (?)", bookIDs).Order("id").Find(&books).Error
+	return bookIDs, err
+}
+
+// checkIDs returns ErrRecordNotFound unless every id exists in the table of model.
+func checkIDs(tx *gorm.DB, model interface{}, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var found int
+	err := tx.Model(model).Where("id in (?)", ids).Count(&found).Error
+	if err != nil {
+		return err
+	}
+	if found != len(ids) {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := map[uint]bool{}
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func splitNames(names string) []string {
+	var split []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			split = append(split, name)
+		}
+	}
+	return split
+}
@@ -0,0 +1,190 @@
+package data_store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrInvalidSort   = errors.New("unknown sort field")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// co-authored books list every author, so names are matched through the links
+const (
+	bookByAuthorName = `book.id in (select book_author.book_id from book_author
+		inner join author on author.id = book_author.author_id where author.name = ?)`
+	bookByCategoryName = `book.id in (select book_category.book_id from book_category
+		inner join category on category.id = book_category.category_id where category.name = ?)`
+)
+
+// sortColumns maps the sort fields accepted by QueryBooks to their columns.
+var sortColumns = map[string]string{
+	"id":      "id",
+	"name":    "name",
+	"author":  "author",
+	"year":    "year",
+	"edition": "edition",
+	"rating":  "rating",
+	"stock":   "stock",
+}
+
+// bookCursor is the position after the last book of a page: its value in the
+// sort column and its id, which breaks ties between equal values. Sort records
+// the order the page was read in, a cursor is only valid for that order.
+type bookCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// QueryBooks returns one page of the catalog matching every filter set in q,
+// ordered by q.Sort. Pages are keyed on the last row instead of an offset, so
+// rows added while a client pages through do not shift or repeat results.
+func (ds *DataStore) QueryBooks(q models.BookQuery) (*models.BookPage, error) {
+	field := strings.TrimPrefix(q.Sort, "-")
+	if field == "" {
+		field = "id"
+	}
+	column, ok := sortColumns[field]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	desc := strings.HasPrefix(q.Sort, "-")
+	sortKey := field
+	if desc {
+		sortKey = "-" + field
+	}
+
+	filtered := filterBooks(ds.Db.Model(&models.Book{}), q)
+	page := &models.BookPage{Books: []models.Book{}}
+	err := filtered.Count(&page.Total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	query := filtered
+	if q.Cursor != "" {
+		cursor, err := decodeBookCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != sortKey {
+			return nil, ErrInvalidCursor
+		}
+		query = afterCursor(query, column, desc, cursor)
+	}
+	direction := "asc"
+	if desc {
+		direction = "desc"
+	}
+	// one extra row tells whether there is a next page
+	err = query.Order(column + " " + direction).Order("id " + direction).Limit(q.Limit + 1).
+		Find(&page.Books).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Books) > q.Limit {
+		page.Books = page.Books[:q.Limit]
+		last := page.Books[len(page.Books)-1]
+		page.NextCursor = encodeBookCursor(bookCursor{
+			Sort:  sortKey,
+			Value: sortValue(last, field),
+			ID:    last.ID,
+		})
+	}
+	return page, nil
+}
+
+func filterBooks(db *gorm.DB, q models.BookQuery) *gorm.DB {
+	if q.Author != "" {
+		db = db.Where(bookByAuthorName, q.Author)
+	}
+	if q.Category != "" {
+		db = db.Where(bookByCategoryName, q.Category)
+	}
+	if q.ISBN != "" {
+		if _, normalized, err := normalizeISBN(q.ISBN); err == nil && normalized != nil {
+			db = db.Where("isbn_normalized = ?", *normalized)
+		} else {
+			db = db.Where("isbn = ?", q.ISBN)
+		}
+	}
+	if q.Edition != 0 {
+		db = db.Where("edition = ?", q.Edition)
+	}
+	// year is stored as text, compared as text "999" would sort after "1999"
+	if q.YearFrom != 0 {
+		db = db.Where("cast(year as signed) >= ?", q.YearFrom)
+	}
+	if q.YearTo != 0 {
+		db = db.Where("cast(year as signed) <= ?", q.YearTo)
+	}
+	if q.RatingMin != nil {
+		db = db.Where("rating >= ?", *q.RatingMin)
+	}
+	if q.RatingMax != nil {
+		db = db.Where("rating <= ?", *q.RatingMax)
+	}
+	if q.Available != nil {
+		if *q.Available {
+			db = db.Where("stock > 0")
+		} else {
+			db = db.Where("stock = 0")
+		}
+	}
+	return db
+}
+
+func afterCursor(db *gorm.DB, column string, desc bool, cursor *bookCursor) *gorm.DB {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if column == "id" {
+		return db.Where("id "+op+" ?", cursor.ID)
+	}
+	return db.Where(fmt.Sprintf("(%s %s ?) or (%s = ? and id %s ?)", column, op, column, op),
+		cursor.Value, cursor.Value, cursor.ID)
+}
+
+func sortValue(book models.Book, field string) string {
+	switch field {
+	case "name":
+		return book.Name
+	case "author":
+		return book.Author
+	case "year":
+		return book.Year
+	case "edition":
+		return fmt.Sprint(book.Edition)
+	case "rating":
+		return fmt.Sprint(book.Rating)
+	case "stock":
+		return fmt.Sprint(book.Stock)
+	}
+	return fmt.Sprint(book.ID)
+}
+
+func encodeBookCursor(cursor bookCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeBookCursor(s string) (*bookCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &bookCursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
@@ -3,7 +3,7 @@ package data_store
 import (
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
 	"github.com/library/envConfig"
 	"github.com/library/migrations"
@@ -19,62 +19,229 @@ type DbUtil interface {
 	InsertData
 	GetData
 	BookReserve
+	BookHold
+	BookCopies
+	Fines
+	Reviews
+	Catalog
+	Jobs
+	Sessions
+	AccountTokens
+	Logins
+	Accounts
+	Mfa
+	Roles
 	DeleteData
 	UpdateData
 	VerifyUser(models.LoginDetails) (*models.Account, error)
 }
 
 type InsertData interface {
-	CreateUserAccount(models.Account) error
+	CreateUserAccount(models.Account) (*models.Account, error)
 	CreateBook(book models.Book) error
+	AddStockByISBN(string, uint) (*models.Book, error)
+	ImportBooks([]models.ImportRow, models.ImportOptions) *models.ImportReport
 }
 
 type GetData interface {
 	GetBooks() (*[]models.Book, error)
+	ExportBooks(func(*models.Book) error) error
+	QueryBooks(models.BookQuery) (*models.BookPage, error)
 	GetBooksByTitle(string) (*[]models.Book, error)
 	GetBooksByISBN(string) (*[]models.Book, error)
 	GetBookByID(uint) (*models.Book, error)
-	GetBooksByAuthor(string) (*[]models.Book, error)
-	GetBooksByStock(uint) (*[]models.Book, error)
-	GetBooksByYear(string) (*[]models.Book, error)
-	GetBooksByEdition(uint) (*[]models.Book, error)
 	GetBooksByAvailable() (*[]models.Book, error)
 	GetBorrowedBooks() (*[]models.BookHistory, error)
 	GetOverdueBooks() (*[]models.BookHistory, error)
 	GetUserByEmail(string) (*models.Account, error)
+	GetAccountByEmail(string) (*models.Account, error)
 	GetUserByID(uint) (*models.Account, error)
 	GetUsers() (*[]models.Account, error)
 	GetAllBooksReturnByUser() (*[]models.StudentReturnBook, error)
 	GetBooksReturnByUser(uint) (*models.StudentReturnBook, error)
-	GetBooksByRating(uint) (*[]models.Book, error)
+	SearchBooks(string, int) (*[]models.SearchResult, error)
 }
 
 type BookReserve interface {
 	GetHistory(uint) (*[]models.BookHistory, error)
+	GetOpenLoan(uint, uint) (*models.BookHistory, error)
 	GetBooksbyStatus(string) (*[]models.BookHistoryAll, error)
 	GetCompleteHistory() (*[]models.BookHistory, error)
 	CheckAvailability(uint) (bool, error)
-	ReserveBook(uint, uint, *time.Time, *time.Time) error
-	AdminConfirmReturnBook(uint, uint) error
+	ReserveBook(uint, uint, *time.Time, *time.Time, int64, int64) error
+	AdminConfirmReturnBook(uint, uint, *time.Time, int64) error
 	StudentReturnBook(uint, uint, *time.Time, *time.Time) error
-	UpdateBookOverdue(*time.Time) error
+	RenewBook(uint, uint, *time.Time, int, int) (*models.BookHistory, error)
+	GetHistoryEvents(uint) (*[]models.BookHistoryEvent, error)
+	UpdateBookOverdue(*time.Time) (*[]models.BookHistory, error)
 	GetBooksStudentOverdue(uint) (*[]models.BookHistoryAll, error)
 	GetBooksStudentReserved(uint) (*[]models.BookHistoryAll, error)
 	GetAllBooksStudentReturned() (*[]models.StudentReturnBook, error)
 	GetBooksStudentReturned(uint) (*[]models.StudentReturnBook, error)
 }
 
+type BookHold interface {
+	GetHoldsByUser(uint) (*[]models.BookQueue, error)
+	GetBookQueue(uint) (*[]models.BookQueue, error)
+	GetQueuePosition(uint, uint) (*models.QueuePosition, error)
+	CancelHold(uint, uint, *time.Time) error
+	ExpireHolds(*time.Time, *time.Time) (int, error)
+}
+
+type BookCopies interface {
+	CreateBookCopy(models.BookCopy) (*models.BookCopy, error)
+	GetBookCopies(uint) (*[]models.BookCopy, error)
+	GetBookCopyByID(uint) (*models.BookCopy, error)
+	UpdateBookCopy(uint, string, string, string, *time.Time, string) error
+	DeleteBookCopy(uint) error
+}
+
+type Fines interface {
+	GetFineBalance(uint, int64, *time.Time) (*models.FineBalance, error)
+	RecordFinePayment(uint, int64, string, int64, *time.Time) error
+	WaiveFine(uint, int64, string, int64, *time.Time) error
+}
+
+type Reviews interface {
+	CreateReview(models.Review) (*models.Review, error)
+	GetReviews(uint) (*[]models.Review, error)
+	UpdateReview(uint, uint, uint, string) error
+	DeleteReview(uint, uint) error
+	ModerateReview(uint, bool) error
+}
+
+type Catalog interface {
+	CreateAuthor(models.Author) (*models.Author, error)
+	GetAuthors() (*[]models.Author, error)
+	GetAuthorByID(uint) (*models.Author, error)
+	GetBookAuthors(uint) (*[]models.Author, error)
+	UpdateAuthor(uint, string) error
+	DeleteAuthor(uint) error
+	SetBookAuthors(uint, []uint) error
+	GetBooksByAuthorID(uint) (*[]models.Book, error)
+	CreateCategory(models.Category) (*models.Category, error)
+	GetCategories() (*[]models.Category, error)
+	GetCategoryByID(uint) (*models.Category, error)
+	UpdateCategory(uint, string, *uint) error
+	DeleteCategory(uint) error
+	SetBookCategories(uint, []uint) error
+	GetBooksByCategory(uint) (*[]models.Book, error)
+	CreatePublisher(models.Publisher) (*models.Publisher, error)
+	GetPublishers() (*[]models.Publisher, error)
+	GetPublisherByID(uint) (*models.Publisher, error)
+	GetBookPublishers(uint) (*[]models.Publisher, error)
+	UpdatePublisher(uint, string) error
+	DeletePublisher(uint) error
+	SetBookPublishers(uint, []uint) error
+	GetBooksByPublisherID(uint) (*[]models.Book, error)
+}
+
+type Jobs interface {
+	AcquireJobLock(string) (func(), error)
+	StartJobRun(string, string) (*models.JobRun, error)
+	FinishJobRun(*models.JobRun, int, error) error
+	GetLastJobRun(string) (*models.JobRun, error)
+}
+
+type Sessions interface {
+	CreateRefreshToken(uint, string, string, time.Time) error
+	RotateRefreshToken(string, string, time.Time, time.Time) (*models.RefreshToken, error)
+	RevokeSession(string, time.Time) error
+	RevokeToken(string, time.Time) error
+	IsTokenRevoked(string) (bool, error)
+}
+
+type AccountTokens interface {
+	CreateAccountToken(uint, string, string, time.Time, time.Time) error
+	VerifyEmail(string, time.Time) (*models.Account, error)
+	ResetPassword(string, string, time.Time) (*models.Account, error)
+	GetAccountToken(string, string, time.Time) (*models.AccountToken, error)
+	RedeemAccountToken(string, string, time.Time) (*models.AccountToken, error)
+}
+
+type Logins interface {
+	LoginLockedUntil(string, string, time.Time) (time.Time, error)
+	RecordLoginFailure(string, string, time.Time, LockoutPolicy) (*models.LoginFailure, error)
+	ClearLoginFailures(string, string) error
+}
+
+type Accounts interface {
+	SuspendAccount(uint, string, *time.Time, time.Time) error
+	ReactivateAccount(uint) error
+	DeleteAccount(uint, time.Time, *time.Time) error
+	GetProfile(uint) (*models.Account, error)
+	UpdateProfile(uint, models.ProfileRequest) (*models.Account, error)
+	ChangePassword(uint, string, time.Time) error
+}
+
+type Mfa interface {
+	StartMfaEnrollment(uint, string) error
+	EnableMfa(uint, int64, []string) error
+	UseTotpStep(uint, int64) error
+	GetRecoveryCodes(uint) (*[]models.RecoveryCode, error)
+	UseRecoveryCode(uint, time.Time) error
+}
+
+type Roles interface {
+	GetRolePermissions(string) ([]string, error)
+	GetRoles() (*[]models.Role, error)
+	SetRolePermissions(string, []string) error
+	SetAccountRole(uint, string) error
+}
+
 type DeleteData interface {
 	DeleteBook(uint) error
 	DeleteRecordStudentReturnBook(uint) error
 }
 
 type UpdateData interface {
-	UpdateBook(uint, string, string, uint, string, string, uint, string, string, string, uint) error
+	UpdateBook(uint, string, string, string, string, uint, string, string, string) error
+	SetBookCover(uint, string, string) (string, error)
 }
 
 var retryAttempts = 0
 
+// withTransaction runs fn inside a single DB transaction. The transaction is
+// rolled back when fn returns an error or panics, and committed otherwise.
+func (ds *DataStore) withTransaction(fn func(tx *gorm.DB) error) (err error) {
+	tx := ds.Db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// isDuplicateEntry reports whether err is MySQL rejecting a row that breaks a
+// unique key (error 1062).
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+// forUpdate makes the next query on tx take row locks (SELECT ... FOR UPDATE).
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Set("gorm:query_option", "FOR UPDATE")
+}
+
+// lockBook takes the row lock on a book. Every change to a title's copies,
+// holds or loans locks the book row first so concurrent requests for the same
+// title are serialized and always acquire locks in the same order.
+func lockBook(tx *gorm.DB, bookID uint) (*models.Book, error) {
+	book := &models.Book{}
+	err := forUpdate(tx).Where("id = ?", bookID).First(book).Error
+	return book, err
+}
+
 func DbConnect(dbConfig *envConfig.Env, testing bool) *DataStore {
 	var sqlUrl string
 	if testing {
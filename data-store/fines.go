@@ -0,0 +1,107 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrInvalidFineAmount = errors.New("amount must be greater than zero")
+	ErrFineExceedsDue    = errors.New("amount exceeds the outstanding balance")
+	ErrFinesOutstanding  = errors.New("outstanding fines exceed the borrowing limit")
+)
+
+// DaysOverdue is the number of whole days between the due date and at.
+func DaysOverdue(dueDate *time.Time, at time.Time) int64 {
+	if dueDate == nil || !at.After(*dueDate) {
+		return 0
+	}
+	return int64(at.Sub(*dueDate).Hours() / 24)
+}
+
+func (ds *DataStore) GetFineBalance(userID uint, fineRatePerDay int64, currentTime *time.Time) (*models.FineBalance, error) {
+	return fineBalance(ds.Db, userID, fineRatePerDay, currentTime)
+}
+
+func (ds *DataStore) RecordFinePayment(userID uint, amount int64, note string, fineRatePerDay int64, currentTime *time.Time) error {
+	return ds.settleFine(userID, models.FinePayment, amount, note, fineRatePerDay, currentTime)
+}
+
+func (ds *DataStore) WaiveFine(userID uint, amount int64, note string, fineRatePerDay int64, currentTime *time.Time) error {
+	return ds.settleFine(userID, models.FineWaiver, amount, note, fineRatePerDay, currentTime)
+}
+
+func (ds *DataStore) settleFine(userID uint, kind string, amount int64, note string, fineRatePerDay int64, currentTime *time.Time) error {
+	if amount <= 0 {
+		return ErrInvalidFineAmount
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		// the account row serializes concurrent settlements for the same reader
+		err := forUpdate(tx).Where("id = ?", userID).First(&models.Account{}).Error
+		if err != nil {
+			return err
+		}
+		balance, err := fineBalance(tx, userID, fineRatePerDay, currentTime)
+		if err != nil {
+			return err
+		}
+		if amount > balance.Outstanding {
+			return ErrFineExceedsDue
+		}
+		return tx.Create(&models.Fine{
+			UserID: userID,
+			Kind:   kind,
+			Amount: amount,
+			Note:   note,
+		}).Error
+	})
+}
+
+// chargeOverdueFine records the final fine for a loan that is being closed.
+func chargeOverdueFine(tx *gorm.DB, history *models.BookHistory, fineRatePerDay int64, returnedAt time.Time) error {
+	days := DaysOverdue(history.ReturnDate, returnedAt)
+	if days == 0 || fineRatePerDay <= 0 {
+		return nil
+	}
+	return tx.Create(&models.Fine{
+		UserID:    history.UserID,
+		HistoryID: history.ID,
+		Kind:      models.FineCharge,
+		Amount:    days * fineRatePerDay,
+		Note:      "overdue return",
+	}).Error
+}
+
+// fineBalance sums the ledger for a reader and adds the fines still accruing
+// on loans that are past their return date but not yet back.
+func fineBalance(db *gorm.DB, userID uint, fineRatePerDay int64, currentTime *time.Time) (*models.FineBalance, error) {
+	balance := &models.FineBalance{UserID: userID}
+	err := db.Where("user_id = ?", userID).Order("id").Find(&balance.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range balance.Entries {
+		switch entry.Kind {
+		case models.FineCharge:
+			balance.Charged += entry.Amount
+		case models.FinePayment:
+			balance.Paid += entry.Amount
+		case models.FineWaiver:
+			balance.Waived += entry.Amount
+		}
+	}
+	var loans []models.BookHistory
+	err = db.Where("user_id = ? and status in (?) and return_date < ?", userID,
+		[]string{"borrowed", "overdue"}, currentTime).Find(&loans).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, loan := range loans {
+		balance.Accruing += DaysOverdue(loan.ReturnDate, *currentTime) * fineRatePerDay
+	}
+	balance.Outstanding = balance.Charged + balance.Accruing - balance.Paid - balance.Waived
+	return balance, nil
+}
@@ -22,9 +22,16 @@ func (ds *DataStore) GetBooksByTitle(title string) (*[]models.Book, error) {
 	return &books, err
 }
 
-func (ds *DataStore) GetBooksByISBN(isbn string) (*[]models.Book, error) {
+// GetBooksByISBN matches any spelling of the ISBN: with or without hyphens,
+// in its ISBN-10 or ISBN-13 form.
+func (ds *DataStore) GetBooksByISBN(rawISBN string) (*[]models.Book, error) {
 	var books []models.Book
-	err := ds.Db.Where("isbn=?", isbn).Find(&books).Error
+	_, normalized, err := normalizeISBN(rawISBN)
+	if err != nil || normalized == nil {
+		err = ds.Db.Where("isbn=?", rawISBN).Find(&books).Error
+		return &books, err
+	}
+	err = ds.Db.Where("isbn_normalized=?", *normalized).Find(&books).Error
 	return &books, err
 }
 
@@ -35,30 +42,6 @@ func (ds *DataStore) GetBooksByISBN(isbn string) (*[]models.Book, error) {
 // 	return &books, err
 // }
 
-func (ds *DataStore) GetBooksByStock(stock uint) (*[]models.Book, error) {
-	var books []models.Book
-	err := ds.Db.Where("stock=?", stock).Find(&books).Error
-	return &books, err
-}
-
-func (ds *DataStore) GetBooksByAuthor(author string) (*[]models.Book, error) {
-	var books []models.Book
-	err := ds.Db.Where("author=?", author).Find(&books).Error
-	return &books, err
-}
-
-func (ds *DataStore) GetBooksByYear(year string) (*[]models.Book, error) {
-	var books []models.Book
-	err := ds.Db.Where("year=?", year).Find(&books).Error
-	return &books, err
-}
-
-func (ds *DataStore) GetBooksByEdition(edition uint) (*[]models.Book, error) {
-	var books []models.Book
-	err := ds.Db.Where("edition=?", edition).Find(&books).Error
-	return &books, err
-}
-
 func (ds *DataStore) GetBooksByAvailable() (*[]models.Book, error) {
 	var books []models.Book
 	err := ds.Db.Where("stock>0").Find(&books).Error
@@ -77,18 +60,19 @@ func (ds *DataStore) GetOverdueBooks() (*[]models.BookHistory, error) {
 	return &books, err
 }
 
-func (ds *DataStore) GetBooksByRating(rating uint) (*[]models.Book, error) {
-	var books []models.Book
-	err := ds.Db.Where("rating=?", rating).Find(&books).Error
-	return &books, err
-}
-
 func (ds *DataStore) GetUserByEmail(email string) (*models.Account, error) {
 	user := &models.Account{}
 	err := ds.Db.Where("email=? and account_role='user'", email).Find(user).Error
 	return user, err
 }
 
+// GetAccountByEmail finds an account of any role.
+func (ds *DataStore) GetAccountByEmail(email string) (*models.Account, error) {
+	account := &models.Account{}
+	err := ds.Db.Where("email = ?", email).First(account).Error
+	return account, err
+}
+
 func (ds *DataStore) GetUserByID(id uint) (*models.Account, error) {
 	user := &models.Account{}
 	err := ds.Db.Where("id = ?", id).Find(user).Error
@@ -0,0 +1,196 @@
+package data_store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+const defaultImportBatchSize = 500
+
+// errDryRun rolls back a batch that was only imported to validate it
+var errDryRun = errors.New("dry run")
+
+// ImportBooks adds the rows to the catalog in batches of options.BatchSize,
+// each in its own transaction. Rows without a name, with an invalid ISBN, with
+// an ISBN used earlier in the file or, unless upserting, already in the
+// catalog are reported and skipped. A batch that fails as a whole is rolled
+// back and every row in it is reported.
+//
+// Upserting keeps the existing book and its copies; the row's non-empty
+// details replace the stored ones and its stock is ignored.
+func (ds *DataStore) ImportBooks(rows []models.ImportRow, options models.ImportOptions) *models.ImportReport {
+	report := &models.ImportReport{
+		DryRun: options.DryRun,
+		Upsert: options.Upsert,
+		Errors: []models.ImportError{},
+	}
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+	var valid []models.ImportRow
+	seen := map[string]int{}
+	for _, row := range rows {
+		book, err := prepareImportBook(row.Book)
+		if err == nil && book.ISBNNormalized != nil {
+			if first, ok := seen[*book.ISBNNormalized]; ok {
+				err = fmt.Errorf("isbn already used by row %d", first)
+			} else {
+				seen[*book.ISBNNormalized] = row.Row
+			}
+		}
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, importError(row, err))
+			continue
+		}
+		valid = append(valid, models.ImportRow{Row: row.Row, Book: book})
+	}
+	for start := 0; start < len(valid); start += batchSize {
+		end := start + batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		batch := valid[start:end]
+		var (
+			created, updated int
+			skipped          []models.ImportError
+		)
+		err := ds.withTransaction(func(tx *gorm.DB) error {
+			for _, row := range batch {
+				merged, err := importBook(tx, row.Book, options.Upsert)
+				switch {
+				case err == ErrDuplicateISBN:
+					skipped = append(skipped, importError(row, err))
+				case err != nil:
+					return fmt.Errorf("row %d: %v", row.Row, err)
+				case merged:
+					updated++
+				default:
+					created++
+				}
+			}
+			if options.DryRun {
+				return errDryRun
+			}
+			return nil
+		})
+		if err != nil && err != errDryRun {
+			report.Failed += len(batch)
+			for _, row := range batch {
+				report.Errors = append(report.Errors, importError(row, fmt.Errorf("batch rolled back, %v", err)))
+			}
+			continue
+		}
+		report.Created += created
+		report.Updated += updated
+		report.Failed += len(skipped)
+		report.Errors = append(report.Errors, skipped...)
+	}
+	return report
+}
+
+// ExportBooks hands the catalog to fn one book at a time, in id order, so it
+// can be streamed without loading every book first.
+func (ds *DataStore) ExportBooks(fn func(*models.Book) error) error {
+	rows, err := ds.Db.Model(&models.Book{}).Order("id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		book := &models.Book{}
+		err = ds.Db.ScanRows(rows, book)
+		if err != nil {
+			return err
+		}
+		err = fn(book)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// prepareImportBook validates a row and drops what belongs to the catalog it
+// came from: ids, timestamps and ratings.
+func prepareImportBook(book models.Book) (models.Book, error) {
+	book.Name = strings.TrimSpace(book.Name)
+	if book.Name == "" {
+		return book, ErrEmptyName
+	}
+	var err error
+	book.ISBN, book.ISBNNormalized, err = normalizeISBN(book.ISBN)
+	if err != nil {
+		return book, err
+	}
+	book.BaseModel = models.BaseModel{}
+	book.Rating = 0
+	book.RatingCount = 0
+	return book, nil
+}
+
+// importBook creates the book of a row, or merges it into the book with the
+// same ISBN when upsert is set. It reports whether an existing book was updated.
+func importBook(tx *gorm.DB, book models.Book, upsert bool) (bool, error) {
+	if book.ISBNNormalized != nil {
+		existing, err := findByISBN(tx, *book.ISBNNormalized)
+		if err == nil {
+			if !upsert {
+				return false, ErrDuplicateISBN
+			}
+			return true, mergeBook(tx, existing.ID, book)
+		}
+		if err != gorm.ErrRecordNotFound {
+			return false, err
+		}
+	}
+	stock := book.Stock
+	book.Stock = 0
+	return false, insertBook(tx, &book, stock)
+}
+
+func mergeBook(tx *gorm.DB, bookID uint, book models.Book) error {
+	existing, err := lockBook(tx, bookID)
+	if err != nil {
+		return err
+	}
+	changes := map[string]interface{}{
+		"name": book.Name,
+	}
+	if book.Year != "" {
+		changes["year"] = book.Year
+	}
+	if book.Edition != 0 {
+		changes["edition"] = book.Edition
+	}
+	if book.Cover != "" {
+		changes["cover"] = book.Cover
+	}
+	if book.Abstract != "" {
+		changes["abstract"] = book.Abstract
+	}
+	err = tx.Model(existing).Where("id = ?", bookID).Updates(changes).Error
+	if err != nil {
+		return err
+	}
+	if book.Author == "" {
+		book.Author = existing.Author
+	}
+	if book.Category == "" {
+		book.Category = existing.Category
+	}
+	return linkBookNames(tx, bookID, book.Author, book.Category)
+}
+
+func importError(row models.ImportRow, err error) models.ImportError {
+	return models.ImportError{
+		Row:   row.Row,
+		ISBN:  row.Book.ISBN,
+		Error: err.Error(),
+	}
+}
@@ -1,23 +1,141 @@
 package data_store
 
 import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/isbn"
 	"github.com/library/models"
 )
 
-func (ds *DataStore) CreateUserAccount(acc models.Account) error {
-	return ds.Db.Create(&acc).Error
+var (
+	ErrDuplicateISBN    = errors.New("a book with this isbn already exists")
+	ErrDuplicateAccount = errors.New("an account with this email already exists")
+)
+
+// CreateUserAccount stores a new account and returns it with its id. The role
+// must be one of the roles in the role table.
+func (ds *DataStore) CreateUserAccount(acc models.Account) (*models.Account, error) {
+	err := ds.Db.Where("name = ?", acc.AccountRole).First(&models.Role{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUnknownRole
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = ds.Db.Create(&acc).Error
+	if isDuplicateEntry(err) {
+		return nil, ErrDuplicateAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &acc, nil
 }
 
+// VerifyUser finds the account a login is for. Deleted accounts are not found;
+// suspension is left to the caller, see AccountSuspended.
 func (ds *DataStore) VerifyUser(details models.LoginDetails) (*models.Account, error) {
 	account := &models.Account{}
 	err := ds.Db.Where("email=? AND account_role=?", details.Email, details.AccountRole).First(account).Error
 	return account, err
 }
 
+// CreateBook adds a book with Stock copies. A book whose ISBN is already in the
+// catalog is refused with ErrDuplicateISBN; AddStockByISBN adds the copies to
+// the existing record instead.
 func (ds *DataStore) CreateBook(book models.Book) error {
-	err := ds.Db.Create(&book).Error
+	var err error
+	book.ISBN, book.ISBNNormalized, err = normalizeISBN(book.ISBN)
 	if err != nil {
 		return err
 	}
+	stock := book.Stock
+	book.Stock = 0
+	// ratings come from reader reviews only
+	book.Rating = 0
+	book.RatingCount = 0
+	err = ds.withTransaction(func(tx *gorm.DB) error {
+		if book.ISBNNormalized != nil {
+			_, err := findByISBN(tx, *book.ISBNNormalized)
+			if err == nil {
+				return ErrDuplicateISBN
+			}
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+		}
+		return insertBook(tx, &book, stock)
+	})
+	// a concurrent insert of the same isbn can still slip past the lookup
+	if isDuplicateEntry(err) {
+		return ErrDuplicateISBN
+	}
 	return err
 }
+
+// AddStockByISBN adds count copies to the book with the given ISBN.
+func (ds *DataStore) AddStockByISBN(rawISBN string, count uint) (*models.Book, error) {
+	_, normalized, err := normalizeISBN(rawISBN)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == nil {
+		return nil, isbn.ErrInvalidFormat
+	}
+	book := &models.Book{}
+	err = ds.withTransaction(func(tx *gorm.DB) error {
+		existing, err := findByISBN(tx, *normalized)
+		if err != nil {
+			return err
+		}
+		_, err = lockBook(tx, existing.ID)
+		if err != nil {
+			return err
+		}
+		err = createCopies(tx, existing.ID, count)
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ?", existing.ID).First(book).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// insertBook adds a new book, links its authors and categories and creates
+// stock copies of it.
+func insertBook(tx *gorm.DB, book *models.Book, stock uint) error {
+	err := tx.Create(book).Error
+	if err != nil {
+		return err
+	}
+	err = linkBookNames(tx, book.ID, book.Author, book.Category)
+	if err != nil {
+		return err
+	}
+	return createCopies(tx, book.ID, stock)
+}
+
+// normalizeISBN validates a free-text ISBN and returns it without hyphens
+// together with its canonical ISBN-13. A blank ISBN is allowed and has no
+// canonical form.
+func normalizeISBN(raw string) (string, *string, error) {
+	normalized := isbn.Normalize(raw)
+	if normalized == "" {
+		return "", nil, nil
+	}
+	canonical, err := isbn.Canonical(normalized)
+	if err != nil {
+		return "", nil, err
+	}
+	return normalized, &canonical, nil
+}
+
+func findByISBN(db *gorm.DB, canonical string) (*models.Book, error) {
+	book := &models.Book{}
+	err := db.Where("isbn_normalized = ?", canonical).First(book).Error
+	return book, err
+}
@@ -0,0 +1,80 @@
+package data_store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/library/models"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrJobLocked = errors.New("job is already running on another instance")
+
+// AcquireJobLock takes a MySQL advisory lock named after the job so only one
+// replica runs it at a time. GET_LOCK belongs to the session, so the lock is
+// held on a dedicated connection until the returned release func is called.
+func (ds *DataStore) AcquireJobLock(job string) (func(), error) {
+	ctx := context.Background()
+	conn, err := ds.Db.DB().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := "library." + job
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, `select get_lock(?, 0)`, name).Scan(&acquired)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		_ = conn.Close()
+		return nil, ErrJobLocked
+	}
+	return func() {
+		var released sql.NullInt64
+		err := conn.QueryRowContext(ctx, `select release_lock(?)`, name).Scan(&released)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"job":   job,
+				"error": err,
+			}).Error("releasing job lock")
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+func (ds *DataStore) StartJobRun(job, host string) (*models.JobRun, error) {
+	run := &models.JobRun{
+		Job:       job,
+		Host:      host,
+		Status:    models.JobRunning,
+		StartedAt: time.Now(),
+	}
+	err := ds.Db.Create(run).Error
+	return run, err
+}
+
+func (ds *DataStore) FinishJobRun(run *models.JobRun, updated int, jobErr error) error {
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Updated = updated
+	run.Status = models.JobSucceeded
+	if jobErr != nil {
+		run.Status = models.JobFailed
+		run.Error = jobErr.Error()
+	}
+	return ds.Db.Model(run).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"status":     run.Status,
+		"updated":    run.Updated,
+		"error":      run.Error,
+		"finishedAt": run.FinishedAt,
+	}).Error
+}
+
+func (ds *DataStore) GetLastJobRun(job string) (*models.JobRun, error) {
+	run := &models.JobRun{}
+	err := ds.Db.Where("job = ?", job).Order("id desc").First(run).Error
+	return run, err
+}
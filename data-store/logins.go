@@ -0,0 +1,87 @@
+package data_store
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+// LockoutPolicy locks a subject out once it has MaxAttempts failed logins
+// with no gap longer than Window between them. The first lockout lasts
+// Lockout and every further failure doubles it, up to MaxLockout.
+type LockoutPolicy struct {
+	MaxAttempts int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+func (p LockoutPolicy) lockout(failures int) time.Duration {
+	lockout := p.Lockout
+	for i := p.MaxAttempts; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+// LoginLockedUntil returns when the lockout of a subject ends, or the zero
+// time if it is not locked out at now.
+func (ds *DataStore) LoginLockedUntil(scope, subject string, now time.Time) (time.Time, error) {
+	failure := &models.LoginFailure{}
+	err := ds.Db.Where("scope = ? and subject = ?", scope, subject).First(failure).Error
+	if err == gorm.ErrRecordNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if failure.LockedUntil == nil || !failure.LockedUntil.After(now) {
+		return time.Time{}, nil
+	}
+	return *failure.LockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login of a subject and returns its
+// updated record. LockedUntil is set once the policy's attempts are used up.
+func (ds *DataStore) RecordLoginFailure(scope, subject string, now time.Time, policy LockoutPolicy) (*models.LoginFailure, error) {
+	failure := &models.LoginFailure{}
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Create(&models.LoginFailure{Scope: scope, Subject: subject, LastFailureAt: now}).Error
+		if err != nil && !isDuplicateEntry(err) {
+			return err
+		}
+		err = forUpdate(tx).Where("scope = ? and subject = ?", scope, subject).First(failure).Error
+		if err != nil {
+			return err
+		}
+		locked := failure.LockedUntil != nil && failure.LockedUntil.After(now)
+		if !locked && now.Sub(failure.LastFailureAt) > policy.Window {
+			failure.Failures = 0
+		}
+		failure.Failures++
+		failure.LastFailureAt = now
+		if failure.Failures >= policy.MaxAttempts {
+			lockedUntil := now.Add(policy.lockout(failure.Failures))
+			failure.LockedUntil = &lockedUntil
+		}
+		return tx.Model(&models.LoginFailure{}).Where("scope = ? and subject = ?", scope, subject).
+			Updates(map[string]interface{}{
+				"failures":      failure.Failures,
+				"lastFailureAt": failure.LastFailureAt,
+				"lockedUntil":   failure.LockedUntil,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return failure, nil
+}
+
+// ClearLoginFailures forgets the failures of a subject and lifts its lockout.
+func (ds *DataStore) ClearLoginFailures(scope, subject string) error {
+	return ds.Db.Where("scope = ? and subject = ?", scope, subject).Delete(&models.LoginFailure{}).Error
+}
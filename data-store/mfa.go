@@ -0,0 +1,102 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrMfaEnabled     = errors.New("mfa is already enabled")
+	ErrMfaNotEnrolled = errors.New("mfa enrollment has not been started")
+	ErrMfaCodeUsed    = errors.New("code was already used")
+)
+
+// StartMfaEnrollment stores a new TOTP secret for an account that does not use
+// MFA yet. Starting over replaces the secret of an unfinished enrollment.
+func (ds *DataStore) StartMfaEnrollment(userID uint, secret string) error {
+	result := ds.Db.Model(&models.Account{}).Where("id = ? and mfa_enabled = ?", userID, false).
+		Update("mfaSecret", secret)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		err := ds.Db.Where("id = ?", userID).First(&models.Account{}).Error
+		if err != nil {
+			return err
+		}
+		return ErrMfaEnabled
+	}
+	return nil
+}
+
+// EnableMfa finishes an enrollment once a code of the new secret matched at
+// step, and replaces the recovery codes of the account.
+func (ds *DataStore) EnableMfa(userID uint, step int64, codeHashes []string) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		account := &models.Account{}
+		err := forUpdate(tx).Where("id = ?", userID).First(account).Error
+		if err != nil {
+			return err
+		}
+		if account.MfaEnabled {
+			return ErrMfaEnabled
+		}
+		if account.MfaSecret == "" {
+			return ErrMfaNotEnrolled
+		}
+		err = tx.Model(&models.Account{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"mfaEnabled":  true,
+			"mfaLastStep": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			err = tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hash}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseTotpStep records that a code of step was accepted. A step at or before
+// the last accepted one is refused, so a code cannot be replayed.
+func (ds *DataStore) UseTotpStep(userID uint, step int64) error {
+	result := ds.Db.Model(&models.Account{}).Where("id = ? and mfa_last_step < ?", userID, step).
+		Update("mfaLastStep", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMfaCodeUsed
+	}
+	return nil
+}
+
+// GetRecoveryCodes returns the unused recovery codes of an account.
+func (ds *DataStore) GetRecoveryCodes(userID uint) (*[]models.RecoveryCode, error) {
+	codes := &[]models.RecoveryCode{}
+	err := ds.Db.Where("user_id = ? and used_at is null", userID).Find(codes).Error
+	return codes, err
+}
+
+// UseRecoveryCode marks a recovery code as used; each works once.
+func (ds *DataStore) UseRecoveryCode(id uint, now time.Time) error {
+	result := ds.Db.Model(&models.RecoveryCode{}).Where("id = ? and used_at is null", id).Update("usedAt", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMfaCodeUsed
+	}
+	return nil
+}
@@ -2,11 +2,21 @@ package data_store
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/library/models"
 )
 
+var (
+	ErrRenewalLimit = errors.New("maximum renewals reached for this loan")
+	ErrLoanTooLong  = errors.New("loan cannot exceed the maximum loan period")
+	ErrHoldsWaiting = errors.New("another reader is waiting for this book")
+	ErrRenewalDate  = errors.New("new return date must be after the current return date")
+	ErrNoOpenLoan   = errors.New("reader has no open loan of this book")
+)
+
 func (ds *DataStore) GetCompleteHistory() (*[]models.BookHistory, error) {
 	var history []models.BookHistory
 	err := ds.Db.Find(&history).Error
@@ -20,9 +30,21 @@ func (ds *DataStore) GetHistory(id uint) (*[]models.BookHistory, error) {
 	return &history, err
 }
 
+// GetOpenLoan returns the loan of a book a reader has not returned yet, or
+// ErrNoOpenLoan when there is none.
+func (ds *DataStore) GetOpenLoan(bookID, userID uint) (*models.BookHistory, error) {
+	loan := &models.BookHistory{}
+	err := ds.Db.Where("book_id = ? and user_id = ? and status in (?)", bookID, userID,
+		[]string{"borrowed", "overdue"}).Order("id desc").First(loan).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNoOpenLoan
+	}
+	return loan, err
+}
+
 func (ds *DataStore) GetBooksbyStatus(status string) (*[]models.BookHistoryAll, error) {
 	var history []models.BookHistoryAll
-	query := `select * from book_history inner join book on book.id=book_history.book_id where book_history.status = ?`
+	query := `select book_history.*, book.name, book.cover from book_history inner join book on book.id=book_history.book_id where book_history.status = ?`
 	err := ds.Db.Raw(query, status).Scan(&history).Error
 	return &history, err
 }
@@ -40,107 +62,247 @@ func (ds *DataStore) CheckAvailability(id uint) (bool, error) {
 	}
 }
 
-func (ds *DataStore) ReserveBook(bookID, userID uint, reservedDate, returnDate *time.Time) error {
-	book := &models.Book{}
-	err := ds.Db.Where("id = ?", bookID).First(book).Error
-	if err != nil {
-		return err
-	}
-	if book.Stock == 0 {
-		queue := &models.BookQueue{
+// ReserveBook lends an available copy to a reader, or queues a hold when none
+// is on the shelf. Readers who are suspended or owe more than
+// fineBlockThreshold in fines are refused.
+func (ds *DataStore) ReserveBook(bookID, userID uint, reservedDate, returnDate *time.Time,
+	fineRatePerDay, fineBlockThreshold int64) error {
+	queued := false
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		user := &models.Account{}
+		err = forUpdate(tx).Where("id = ?", userID).First(user).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrAccountInactive
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if AccountSuspended(user, now) {
+			return ErrAccountInactive
+		}
+		// the balance is read with the account locked, so a loan cannot slip
+		// in while a return that charges a fine is being confirmed
+		balance, err := fineBalance(tx, userID, fineRatePerDay, &now)
+		if err != nil {
+			return err
+		}
+		if balance.Outstanding > fineBlockThreshold {
+			return ErrFinesOutstanding
+		}
+		hold := &models.BookQueue{}
+		err = forUpdate(tx).Where("book_id = ? and user_id = ? and status in (?)", bookID, userID,
+			[]string{models.HoldWaiting, models.HoldReady}).First(hold).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		bookCopy := &models.BookCopy{}
+		if hold.Status == models.HoldReady {
+			// the returned copy was already set aside for this reader
+			err = forUpdate(tx).Where("id = ?", hold.CopyID).First(bookCopy).Error
+		} else {
+			err = forUpdate(tx).Where("book_id = ? and status = ?", bookID, models.CopyAvailable).
+				Order("id").First(bookCopy).Error
+			if err == gorm.ErrRecordNotFound {
+				if hold.Status == models.HoldWaiting {
+					return ErrAlreadyQueued
+				}
+				queued = true
+				return tx.Create(&models.BookQueue{
+					UserID:       userID,
+					BookID:       bookID,
+					ReservedDate: reservedDate,
+					ReturnDate:   returnDate,
+					Status:       models.HoldWaiting,
+				}).Error
+			}
+		}
+		if err != nil {
+			return err
+		}
+		var activeLoans int
+		err = tx.Model(&models.BookHistory{}).Where("user_id = ? and status in (?)", userID,
+			[]string{"borrowed", "overdue"}).Count(&activeLoans).Error
+		if err != nil {
+			return err
+		}
+		if activeLoans >= 10 {
+			return errors.New("maximum reserved books reached")
+		}
+		err = tx.Model(bookCopy).Where("id = ?", bookCopy.ID).Updates(map[string]interface{}{
+			"status": models.CopyBorrowed,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if hold.ID != 0 {
+			err = tx.Model(hold).Where("id = ?", hold.ID).Updates(map[string]interface{}{
+				"status": models.HoldFulfilled,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = syncStock(tx, bookID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(user).Where("id = ?", userID).Updates(map[string]interface{}{
+			"reservedBooks": gorm.Expr("reserved_books + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.BookHistory{
 			UserID:       userID,
 			BookID:       bookID,
+			CopyID:       bookCopy.ID,
 			ReservedDate: reservedDate,
 			ReturnDate:   returnDate,
+			Status:       "borrowed",
+		}).Error
+	})
+	if err == nil && queued {
+		return ErrBookQueued
+	}
+	return err
+}
+
+func (ds *DataStore) AdminConfirmReturnBook(bookID, studentID uint, pickupDeadline *time.Time, fineRatePerDay int64) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
 		}
-		err = ds.Db.Create(queue).Error
+		history := &models.BookHistory{}
+		err = forUpdate(tx).Where("book_id = ? and user_id = ? and status in (?)", bookID, studentID,
+			[]string{"borrowed", "overdue"}).Order("id desc").First(history).Error
 		if err != nil {
 			return err
 		}
-		return errors.New("book unavailable")
-	}
-	var bookHistory []models.BookHistory
-	query := `select * from book_history where user_id = ?`
-	err = ds.Db.Raw(query, userID).Scan(&bookHistory).Error
-	if err != nil {
-		return err
-	}
-	if len(bookHistory) >= 10 {
-		return errors.New("maximum reserved books reached")
-	}
-	err = ds.Db.Model(book).Where("id = ?", bookID).Updates(map[string]interface{}{
-		"stock": book.Stock - 1,
-	}).Error
-	if err != nil {
-		return err
-	}
-	history := &models.BookHistory{}
-	user := &models.Account{}
-	err = ds.Db.Where("id = ?", userID).First(user).Error
-	if err != nil {
-		return err
-	}
-	// fmt.Println("p reserved books is:", user.ReservedBooks)
-	err = ds.Db.Model(user).Where("id = ?", userID).Updates(map[string]interface{}{
-		"reservedBooks": user.ReservedBooks + 1,
-	}).Error
-	// fmt.Println("n reserved books is:", user.ReservedBooks)
-	if err != nil {
-		return err
-	}
-	for _, v := range bookHistory {
-		if v.BookID == bookID {
-			return ds.Db.Model(history).Where("book_id = ? and user_id = ?", bookID, userID).Updates(map[string]interface{}{
-				"status": "borrowed",
-			}).Error
+		copyID := history.CopyID
+		if copyID == 0 {
+			// loans made before copies were tracked get their copy registered on return
+			bookCopy := &models.BookCopy{
+				BookID:    bookID,
+				Barcode:   fmt.Sprintf("LIB-%d-R%d", bookID, history.ID),
+				Condition: "good",
+				Status:    models.CopyBorrowed,
+			}
+			err = tx.Create(bookCopy).Error
+			if err != nil {
+				return err
+			}
+			copyID = bookCopy.ID
 		}
-	}
-	history = &models.BookHistory{
-		UserID:       userID,
-		BookID:       bookID,
-		ReservedDate: reservedDate,
-		ReturnDate:   returnDate,
-		Status:       "borrowed",
-	}
-	return ds.Db.Create(history).Error
+		err = allocateReturnedCopy(tx, bookID, copyID, pickupDeadline)
+		if err != nil {
+			return err
+		}
+		user := &models.Account{}
+		err = forUpdate(tx).Where("id = ?", studentID).First(user).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(user).Where("id = ?", studentID).Updates(map[string]interface{}{
+			"reservedBooks": gorm.Expr("reserved_books - 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		returnedAt := time.Now()
+		err = chargeOverdueFine(tx, history, fineRatePerDay, returnedAt)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(history).Where("id = ?", history.ID).Updates(map[string]interface{}{
+			"copyId":     copyID,
+			"returnDate": returnedAt,
+			"status":     "returned",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return syncOverdueBooks(tx, []uint{studentID})
+	})
 }
 
-func (ds *DataStore) AdminConfirmReturnBook(bookID, studentID uint) error {
-	book := &models.Book{}
-	err := ds.Db.Model(book).Where("id = ?", bookID).Updates(map[string]interface{}{
-		"stock": book.Stock + 1,
-	}).Error
-	if err != nil {
-		return err
-	}
+func (ds *DataStore) RenewBook(bookID, userID uint, returnDate *time.Time, maxRenewals, maxLoanDays int) (*models.BookHistory, error) {
 	history := &models.BookHistory{}
-	user := &models.Account{}
-	err = ds.Db.Where("id = ?", studentID).First(user).Error
-	if err != nil {
-		return err
-	}
-	err = ds.Db.Model(user).Where("id = ?", studentID).Updates(map[string]interface{}{
-		"reservedBooks": user.ReservedBooks - 1,
-	}).Error
-	if err != nil {
-		return err
-	}
-	err = ds.Db.Where("book_id = ? and user_id = ?", bookID, studentID).Find(history).Error
-	if err != nil {
-		return err
-	}
-	if history.Status == "overdue" {
-		err = ds.Db.Model(user).Where("id = ?", studentID).Updates(map[string]interface{}{
-			"overdueBooks": user.OverdueBooks - 1,
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		// a suspension only ends refresh sessions, an access token issued
+		// before it is still accepted until it expires
+		user := &models.Account{}
+		err = forUpdate(tx).Where("id = ?", userID).First(user).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrAccountInactive
+		}
+		if err != nil {
+			return err
+		}
+		if AccountSuspended(user, time.Now()) {
+			return ErrAccountInactive
+		}
+		err = forUpdate(tx).Where("book_id = ? and user_id = ? and status = 'borrowed'", bookID, userID).
+			Order("id desc").First(history).Error
+		if err != nil {
+			return err
+		}
+		if int(history.Renewals) >= maxRenewals {
+			return ErrRenewalLimit
+		}
+		if history.ReturnDate != nil && !returnDate.After(*history.ReturnDate) {
+			return ErrRenewalDate
+		}
+		if history.ReservedDate != nil && returnDate.Sub(*history.ReservedDate).Hours()/24 > float64(maxLoanDays) {
+			return ErrLoanTooLong
+		}
+		var waiting int
+		err = tx.Model(&models.BookQueue{}).Where("book_id = ? and status = ?", bookID, models.HoldWaiting).
+			Count(&waiting).Error
+		if err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return ErrHoldsWaiting
+		}
+		previousReturnDate := history.ReturnDate
+		err = tx.Model(history).Where("id = ?", history.ID).Updates(map[string]interface{}{
+			"returnDate": returnDate,
+			"renewals":   gorm.Expr("renewals + 1"),
 		}).Error
 		if err != nil {
 			return err
 		}
+		history.ReturnDate = returnDate
+		history.Renewals++
+		return tx.Create(&models.BookHistoryEvent{
+			HistoryID:          history.ID,
+			BookID:             bookID,
+			UserID:             userID,
+			Event:              "renewed",
+			PreviousReturnDate: previousReturnDate,
+			ReturnDate:         returnDate,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ds.Db.Model(history).Where("book_id = ? and user_id = ?", bookID, studentID).Updates(map[string]interface{}{
-		"returnDate": time.Now(),
-		"status":     "returned",
-	}).Error
+	return history, nil
+}
+
+func (ds *DataStore) GetHistoryEvents(bookID uint) (*[]models.BookHistoryEvent, error) {
+	var events []models.BookHistoryEvent
+	err := ds.Db.Where("book_id = ?", bookID).Order("id").Find(&events).Error
+	return &events, err
 }
 
 func (ds *DataStore) StudentReturnBook(bookID, userID uint, reservedDate, returnDate *time.Time) error {
@@ -160,41 +322,60 @@ func (ds *DataStore) StudentReturnBook(bookID, userID uint, reservedDate, return
 	return nil
 }
 
-func (ds *DataStore) UpdateBookOverdue(currentTime *time.Time) error {
-	var books []models.BookHistory
-	query := `select * from book_history where DATE(return_date) < ?`
-	err := ds.Db.Raw(query, currentTime).Scan(&books).Error
-	if err != nil {
-		return nil
-	}
-	for _, book := range books {
-		user := &models.Account{}
-		err = ds.Db.Where("id = ?", book.UserID).First(user).Error
-		if err != nil {
+// UpdateBookOverdue moves borrowed loans that are past their return date to
+// overdue and returns the loans it changed. Loans that are already overdue or
+// returned are left alone, so running it again is a no-op.
+func (ds *DataStore) UpdateBookOverdue(currentTime *time.Time) (*[]models.BookHistory, error) {
+	var loans []models.BookHistory
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		err := forUpdate(tx).Where("status = 'borrowed' and DATE(return_date) < DATE(?)", currentTime).
+			Order("id").Find(&loans).Error
+		if err != nil || len(loans) == 0 {
 			return err
 		}
-		err = ds.Db.Model(user).Where("id = ?", book.UserID).Updates(map[string]interface{}{
-			"overdueBooks": user.OverdueBooks + 1,
+		ids := make([]uint, 0, len(loans))
+		userIDs := make([]uint, 0, len(loans))
+		seen := map[uint]bool{}
+		for i := range loans {
+			loans[i].Status = "overdue"
+			ids = append(ids, loans[i].ID)
+			if !seen[loans[i].UserID] {
+				seen[loans[i].UserID] = true
+				userIDs = append(userIDs, loans[i].UserID)
+			}
+		}
+		err = tx.Model(&models.BookHistory{}).Where("id in (?)", ids).Updates(map[string]interface{}{
+			"status": "overdue",
 		}).Error
 		if err != nil {
 			return err
 		}
+		return syncOverdueBooks(tx, userIDs)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ds.Db.Model(books).Updates(map[string]interface{}{
-		"status": "overdue",
-	}).Error
+	return &loans, nil
+}
+
+// syncOverdueBooks recomputes the overdue counter of the given accounts from
+// their loan history instead of adjusting it, so it cannot drift.
+func syncOverdueBooks(db *gorm.DB, userIDs []uint) error {
+	return db.Exec(`update account set overdue_books = (
+		select count(*) from book_history where book_history.user_id = account.id and book_history.status = 'overdue'
+	) where id in (?)`, userIDs).Error
 }
 
 func (ds *DataStore) GetBooksStudentOverdue(userID uint) (*[]models.BookHistoryAll, error) {
 	var history []models.BookHistoryAll
-	query := `select * from book_history inner join book on book.id=book_history.book_id where book_history.user_id = ? and book_history.status = 'overdue'`
+	query := `select book_history.*, book.name, book.cover from book_history inner join book on book.id=book_history.book_id where book_history.user_id = ? and book_history.status = 'overdue'`
 	err := ds.Db.Raw(query, userID).Scan(&history).Error
 	return &history, err
 }
 
 func (ds *DataStore) GetBooksStudentReserved(userID uint) (*[]models.BookHistoryAll, error) {
 	var history []models.BookHistoryAll
-	query := `select * from book_history inner join book on book.id=book_history.book_id where book_history.user_id = ? and book_history.status = 'borrowed'`
+	query := `select book_history.*, book.name, book.cover from book_history inner join book on book.id=book_history.book_id where book_history.user_id = ? and book_history.status = 'borrowed'`
 	err := ds.Db.Raw(query, userID).Scan(&history).Error
 	return &history, err
 }
@@ -0,0 +1,128 @@
+package data_store
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrInvalidRating    = errors.New("rating must be between 1 and 5")
+	ErrReviewNotAllowed = errors.New("only readers who returned the book can review it")
+	ErrAlreadyReviewed  = errors.New("book already reviewed by this user")
+	ErrReviewNotOwned   = errors.New("review belongs to another user")
+)
+
+func (ds *DataStore) CreateReview(review models.Review) (*models.Review, error) {
+	if review.Rating < 1 || review.Rating > 5 {
+		return nil, ErrInvalidRating
+	}
+	review.ID = 0
+	review.Hidden = false
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, review.BookID)
+		if err != nil {
+			return err
+		}
+		var returned int
+		err = tx.Model(&models.BookHistory{}).Where("book_id = ? and user_id = ? and status = 'returned'",
+			review.BookID, review.UserID).Count(&returned).Error
+		if err != nil {
+			return err
+		}
+		if returned == 0 {
+			return ErrReviewNotAllowed
+		}
+		var reviewed int
+		err = tx.Model(&models.Review{}).Where("book_id = ? and user_id = ?", review.BookID, review.UserID).
+			Count(&reviewed).Error
+		if err != nil {
+			return err
+		}
+		if reviewed > 0 {
+			return ErrAlreadyReviewed
+		}
+		err = tx.Create(&review).Error
+		if err != nil {
+			return err
+		}
+		return syncRating(tx, review.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (ds *DataStore) GetReviews(bookID uint) (*[]models.Review, error) {
+	var reviews []models.Review
+	err := ds.Db.Where("book_id = ? and hidden = ?", bookID, false).Order("id desc").Find(&reviews).Error
+	return &reviews, err
+}
+
+func (ds *DataStore) UpdateReview(reviewID, userID, rating uint, text string) error {
+	if rating < 1 || rating > 5 {
+		return ErrInvalidRating
+	}
+	return ds.withReview(reviewID, func(tx *gorm.DB, review *models.Review) error {
+		if review.UserID != userID {
+			return ErrReviewNotOwned
+		}
+		return tx.Model(review).Where("id = ?", reviewID).Updates(map[string]interface{}{
+			"rating": rating,
+			"review": text,
+		}).Error
+	})
+}
+
+func (ds *DataStore) DeleteReview(reviewID, userID uint) error {
+	return ds.withReview(reviewID, func(tx *gorm.DB, review *models.Review) error {
+		if review.UserID != userID {
+			return ErrReviewNotOwned
+		}
+		return tx.Where("id = ?", reviewID).Delete(&models.Review{}).Error
+	})
+}
+
+func (ds *DataStore) ModerateReview(reviewID uint, hidden bool) error {
+	return ds.withReview(reviewID, func(tx *gorm.DB, review *models.Review) error {
+		return tx.Model(review).Where("id = ?", reviewID).Updates(map[string]interface{}{
+			"hidden": hidden,
+		}).Error
+	})
+}
+
+// withReview runs fn on a locked review and refreshes the rating of its book
+// afterwards, taking the book lock first like every other book write.
+func (ds *DataStore) withReview(reviewID uint, fn func(tx *gorm.DB, review *models.Review) error) error {
+	review := &models.Review{}
+	err := ds.Db.Where("id = ?", reviewID).First(review).Error
+	if err != nil {
+		return err
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		_, err := lockBook(tx, review.BookID)
+		if err != nil {
+			return err
+		}
+		err = forUpdate(tx).Where("id = ?", reviewID).First(review).Error
+		if err != nil {
+			return err
+		}
+		err = fn(tx, review)
+		if err != nil {
+			return err
+		}
+		return syncRating(tx, review.BookID)
+	})
+}
+
+// syncRating recomputes the average rating and review count of a book from
+// its visible reviews.
+func syncRating(db *gorm.DB, bookID uint) error {
+	return db.Exec(`update book set
+		rating = coalesce((select avg(review.rating) from review where book_id = ? and hidden = false), 0),
+		rating_count = (select count(*) from review where book_id = ? and hidden = false)
+	where id = ?`, bookID, bookID, bookID).Error
+}
@@ -0,0 +1,119 @@
+package data_store
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrUnknownRole       = errors.New("no such role")
+	ErrUnknownPermission = errors.New("no such permission")
+	// ErrProtectedRole keeps the admin role able to manage roles, so a bad
+	// update cannot lock every administrator out.
+	ErrProtectedRole = errors.New("the admin role must keep the users:write permission")
+)
+
+func (ds *DataStore) GetRolePermissions(role string) ([]string, error) {
+	var permissions []string
+	err := ds.Db.Model(&models.RolePermission{}).Where("role = ?", role).
+		Order("permission").Pluck("permission", &permissions).Error
+	return permissions, err
+}
+
+func (ds *DataStore) GetRoles() (*[]models.Role, error) {
+	var roles []models.Role
+	err := ds.Db.Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	var grants []models.RolePermission
+	err = ds.Db.Order("permission").Find(&grants).Error
+	if err != nil {
+		return nil, err
+	}
+	byRole := map[string][]string{}
+	for _, grant := range grants {
+		byRole[grant.Role] = append(byRole[grant.Role], grant.Permission)
+	}
+	for i := range roles {
+		roles[i].Permissions = byRole[roles[i].Name]
+		if roles[i].Permissions == nil {
+			roles[i].Permissions = []string{}
+		}
+	}
+	return &roles, nil
+}
+
+// SetRolePermissions replaces the permissions granted to a role.
+func (ds *DataStore) SetRolePermissions(role string, permissions []string) error {
+	granted := map[string]bool{}
+	for _, permission := range permissions {
+		if !isPermission(permission) {
+			return ErrUnknownPermission
+		}
+		granted[permission] = true
+	}
+	if role == models.AdminAccount && !granted[models.PermUsersWrite] {
+		return ErrProtectedRole
+	}
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := forUpdate(tx).Where("name = ?", role).First(&models.Role{}).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrUnknownRole
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.Where("role = ?", role).Delete(&models.RolePermission{}).Error
+		if err != nil {
+			return err
+		}
+		sorted := make([]string, 0, len(granted))
+		for permission := range granted {
+			sorted = append(sorted, permission)
+		}
+		sort.Strings(sorted)
+		for _, permission := range sorted {
+			err = tx.Create(&models.RolePermission{Role: role, Permission: permission}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetAccountRole assigns a role to an account. Tokens already issued keep the
+// old role until they are refreshed.
+func (ds *DataStore) SetAccountRole(userID uint, role string) error {
+	return ds.withTransaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ?", role).First(&models.Role{}).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrUnknownRole
+		}
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.Account{}).Where("id = ?", userID).Update("accountRole", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// an unchanged role affects no rows either
+			return tx.Where("id = ?", userID).First(&models.Account{}).Error
+		}
+		return nil
+	})
+}
+
+func isPermission(permission string) bool {
+	for _, known := range models.Permissions {
+		if permission == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package data_store
+
+import (
+	"github.com/library/models"
+	"github.com/library/search"
+)
+
+// SearchBooks ranks the catalog against a free-text query. MySQL uses the
+// FULLTEXT index over name, author, abstract and category; other dialects fall
+// back to ranking the rows in Go.
+func (ds *DataStore) SearchBooks(query string, limit int) (*[]models.SearchResult, error) {
+	if ds.Db.Dialect().GetName() != "mysql" {
+		var books []models.Book
+		err := ds.Db.Find(&books).Error
+		if err != nil {
+			return nil, err
+		}
+		results := search.Rank(books, query, limit)
+		return &results, nil
+	}
+	results := []models.SearchResult{}
+	sql := `select book.*, match(name, author, abstract, category) against (? in natural language mode) as score
+		from book
+		where deleted_at is null and match(name, author, abstract, category) against (? in natural language mode)
+		order by score desc, id
+		limit ?`
+	err := ds.Db.Raw(sql, query, query, limit).Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	terms := search.Terms(query)
+	for i := range results {
+		results[i].Snippets = search.Snippets(results[i].Book, terms)
+	}
+	return &results, nil
+}
@@ -0,0 +1,99 @@
+package data_store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/library/models"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+)
+
+func (ds *DataStore) CreateRefreshToken(userID uint, familyID, tokenHash string, expiresAt time.Time) error {
+	return ds.Db.Create(&models.RefreshToken{
+		CreatedAt: time.Now(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+// RotateRefreshToken swaps the refresh token with hash tokenHash for a new one
+// in the same family and returns the revoked token. Presenting a token that
+// was already rotated revokes every token of its family and returns
+// ErrRefreshTokenReused, since either the client or an attacker holds a copy.
+func (ds *DataStore) RotateRefreshToken(tokenHash, newHash string, now, expiresAt time.Time) (*models.RefreshToken, error) {
+	token := &models.RefreshToken{}
+	reused := false
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		err := forUpdate(tx).Where("token_hash = ?", tokenHash).First(token).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if token.RevokedAt != nil {
+			reused = true
+			return revokeFamily(tx, token.FamilyID, now)
+		}
+		if !now.Before(token.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+		err = tx.Model(token).Where("id = ?", token.ID).Update("revokedAt", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.RefreshToken{
+			CreatedAt: now,
+			UserID:    token.UserID,
+			FamilyID:  token.FamilyID,
+			TokenHash: newHash,
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return token, nil
+}
+
+// RevokeSession revokes every refresh token of a login session.
+func (ds *DataStore) RevokeSession(familyID string, at time.Time) error {
+	return revokeFamily(ds.Db, familyID, at)
+}
+
+func revokeFamily(tx *gorm.DB, familyID string, at time.Time) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? and revoked_at is null", familyID).
+		Update("revokedAt", at).Error
+}
+
+// RevokeToken blocks an access token until it expires. Entries of tokens that
+// have expired by now are dropped on the way, as the signature check already
+// rejects those.
+func (ds *DataStore) RevokeToken(jti string, expiresAt time.Time) error {
+	err := ds.Db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+	if err != nil {
+		return err
+	}
+	err = ds.Db.Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+	if isDuplicateEntry(err) {
+		return nil
+	}
+	return err
+}
+
+func (ds *DataStore) IsTokenRevoked(jti string) (bool, error) {
+	count := 0
+	err := ds.Db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
@@ -1,28 +1,64 @@
 package data_store
 
 import (
-	"github.com/library/models"
+	"github.com/jinzhu/gorm"
 )
 
 func (ds *DataStore) UpdateBook(bookID uint, newTitle, newISBN string,
-	newStock uint, newAuthor, newYear string, newEdition uint,
-	newCover, newAbstract, newCategory string, newRating uint) error {
-	book := &models.Book{}
-	err := ds.Db.Where("id = ?", bookID).First(book).Error
+	newAuthor, newYear string, newEdition uint,
+	newCover, newAbstract, newCategory string) error {
+	newISBN, normalized, err := normalizeISBN(newISBN)
 	if err != nil {
 		return err
 	}
-	err = ds.Db.Model(book).Where("id = ?", bookID).Updates(map[string]interface{}{
-		"name":     newTitle,
-		"isbn":     newISBN,
-		"stock":    newStock,
-		"author":   newAuthor,
-		"year":     newYear,
-		"edition":  newEdition,
-		"cover":    newCover,
-		"abstract": newAbstract,
-		"category": newCategory,
-		"rating":   newRating,
-	}).Error
+	err = ds.withTransaction(func(tx *gorm.DB) error {
+		book, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		if normalized != nil {
+			existing, err := findByISBN(tx, *normalized)
+			if err == nil && existing.ID != bookID {
+				return ErrDuplicateISBN
+			}
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+		}
+		err = tx.Model(book).Where("id = ?", bookID).Updates(map[string]interface{}{
+			"name":           newTitle,
+			"isbn":           newISBN,
+			"isbnNormalized": normalized,
+			"year":           newYear,
+			"edition":        newEdition,
+			"cover":          newCover,
+			"abstract":       newAbstract,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return linkBookNames(tx, bookID, newAuthor, newCategory)
+	})
+	if isDuplicateEntry(err) {
+		return ErrDuplicateISBN
+	}
 	return err
 }
+
+// SetBookCover points a book at a newly stored cover and returns the key of
+// the cover it replaces so the caller can remove it from the object store.
+func (ds *DataStore) SetBookCover(bookID uint, key, url string) (string, error) {
+	var oldKey string
+	err := ds.withTransaction(func(tx *gorm.DB) error {
+		book, err := lockBook(tx, bookID)
+		if err != nil {
+			return err
+		}
+		oldKey = book.CoverKey
+		return tx.Model(book).Where("id = ?", bookID).Updates(map[string]interface{}{
+			"cover":    url,
+			"coverKey": key,
+		}).Error
+	})
+	return oldKey, err
+}
@@ -1,5 +1,7 @@
 package envConfig
 
+import "time"
+
 type Env struct {
 	UserSvcPort       string `envconfig:"PORT" default:"8000"`
 	BookSvcPort       string `envconfig:"PORT" default:"8001"`
@@ -8,6 +10,12 @@ type Env struct {
 	PushGateway       string `envconfig:"PUSH_GATEWAY" default:"localhost:9091"`
 	DbConfig
 	FluentConfig
+	LoanConfig
+	JobConfig
+	ImportConfig
+	StorageConfig
+	AuthConfig
+	MailConfig
 }
 
 type DbConfig struct {
@@ -20,3 +28,83 @@ type FluentConfig struct {
 	FluentPort string `envconfig:"FLUENT_PORT" default:"24224"`
 	FluentHost string `envconfig:"FLUENT_HOST" default:"127.0.0.1"`
 }
+
+type LoanConfig struct {
+	HoldPickupDays int `envconfig:"HOLD_PICKUP_DAYS" default:"3"`
+	MaxLoanDays    int `envconfig:"MAX_LOAN_DAYS" default:"42"`
+	MaxRenewals    int `envconfig:"MAX_RENEWALS" default:"2"`
+	// fines are in the smallest currency unit
+	FineRatePerDay     int64 `envconfig:"FINE_RATE_PER_DAY" default:"50"`
+	FineBlockThreshold int64 `envconfig:"FINE_BLOCK_THRESHOLD" default:"1000"`
+}
+
+type JobConfig struct {
+	// a zero interval disables the in-process overdue scheduler
+	OverdueJobInterval time.Duration `envconfig:"OVERDUE_JOB_INTERVAL" default:"1h"`
+}
+
+type ImportConfig struct {
+	ImportBatchSize int `envconfig:"IMPORT_BATCH_SIZE" default:"500"`
+	// uploads above this size are refused before they are decoded
+	ImportMaxBytes int64 `envconfig:"IMPORT_MAX_BYTES" default:"33554432"`
+}
+
+type StorageConfig struct {
+	// filesystem or s3
+	StorageBackend    string `envconfig:"STORAGE_BACKEND" default:"filesystem"`
+	StoragePath       string `envconfig:"STORAGE_PATH" default:"data/objects"`
+	S3Endpoint        string `envconfig:"S3_ENDPOINT"`
+	S3Region          string `envconfig:"S3_REGION" default:"us-east-1"`
+	S3Bucket          string `envconfig:"S3_BUCKET"`
+	S3AccessKeyID     string `envconfig:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `envconfig:"S3_SECRET_ACCESS_KEY"`
+	S3ForcePathStyle  bool   `envconfig:"S3_FORCE_PATH_STYLE" default:"true"`
+	CoverMaxBytes     int64  `envconfig:"COVER_MAX_BYTES" default:"5242880"`
+}
+
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration `envconfig:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"`
+	// PEM RSA or P-256 key user-svc signs access tokens with; without one
+	// tokens are signed with JwtSigningKey
+	JwtPrivateKeyFile string `envconfig:"JWT_PRIVATE_KEY_FILE"`
+	// PEM public keys that stay valid next to the signing key while rotating
+	JwtPublicKeyFiles []string      `envconfig:"JWT_PUBLIC_KEY_FILES"`
+	JwksURL           string        `envconfig:"JWKS_URL"`
+	JwksFile          string        `envconfig:"JWKS_FILE"`
+	JwksCacheTTL      time.Duration `envconfig:"JWKS_CACHE_TTL" default:"10m"`
+	// refuse to log in accounts that have not verified their email address
+	RequireEmailVerification bool          `envconfig:"REQUIRE_EMAIL_VERIFICATION" default:"false"`
+	EmailVerificationTTL     time.Duration `envconfig:"EMAIL_VERIFICATION_TTL" default:"48h"`
+	PasswordResetTTL         time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"1h"`
+	// failed logins of an email address, or from a client address, before
+	// it is locked out; the lockout doubles with every further failure
+	LoginMaxAttempts      int           `envconfig:"LOGIN_MAX_ATTEMPTS" default:"5"`
+	LoginMaxAttemptsPerIP int           `envconfig:"LOGIN_MAX_ATTEMPTS_PER_IP" default:"20"`
+	LoginLockout          time.Duration `envconfig:"LOGIN_LOCKOUT" default:"1m"`
+	LoginLockoutMax       time.Duration `envconfig:"LOGIN_LOCKOUT_MAX" default:"1h"`
+	// failures are forgotten once none happened for this long
+	LoginFailureWindow time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"1h"`
+	// take the client address from X-Forwarded-For; only safe behind a proxy
+	// that sets the header
+	TrustProxyHeaders bool `envconfig:"TRUST_PROXY_HEADERS" default:"false"`
+	// admins have to enroll in TOTP before they get a session
+	RequireAdminMFA bool `envconfig:"REQUIRE_ADMIN_MFA" default:"false"`
+	// how long the token between the password and the code is valid
+	MfaPendingTTL time.Duration `envconfig:"MFA_PENDING_TTL" default:"5m"`
+	// shown as the account's issuer in authenticator apps
+	MfaIssuer string `envconfig:"MFA_ISSUER" default:"Library"`
+}
+
+type MailConfig struct {
+	// smtp, file or log
+	MailBackend  string `envconfig:"MAIL_BACKEND" default:"log"`
+	MailFrom     string `envconfig:"MAIL_FROM" default:"library@localhost"`
+	MailFilePath string `envconfig:"MAIL_FILE_PATH" default:"data/mail.mbox"`
+	SmtpHost     string `envconfig:"SMTP_HOST"`
+	SmtpPort     string `envconfig:"SMTP_PORT" default:"587"`
+	SmtpUsername string `envconfig:"SMTP_USERNAME"`
+	SmtpPassword string `envconfig:"SMTP_PASSWORD"`
+	// links in emails point here, e.g. APP_URL/verify-email?token=...
+	AppURL string `envconfig:"APP_URL" default:"http://localhost:8000"`
+}
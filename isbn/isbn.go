@@ -0,0 +1,128 @@
+// Package isbn validates and converts International Standard Book Numbers.
+// Both ISBN-10 and ISBN-13 are accepted with or without hyphens and spaces;
+// Canonical turns either form into the hyphen-free ISBN-13 used to detect
+// duplicates.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidFormat   = errors.New("isbn must have 10 digits or 13 digits starting with 978 or 979")
+	ErrInvalidChecksum = errors.New("isbn check digit does not match")
+	ErrNoISBN10        = errors.New("only isbn-13 starting with 978 have an isbn-10 form")
+)
+
+// Normalize drops hyphens and spaces and upper-cases the ISBN-10 check
+// character. It does not validate.
+func Normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r == 'x':
+			return 'X'
+		}
+		return r
+	}, strings.TrimSpace(s))
+}
+
+// Validate checks the length, characters and check digit of an ISBN-10 or
+// ISBN-13.
+func Validate(s string) error {
+	n := Normalize(s)
+	switch len(n) {
+	case 10:
+		if !digits(n[:9]) || !(digits(n[9:]) || n[9] == 'X') {
+			return ErrInvalidFormat
+		}
+		if checkDigit10(n[:9]) != n[9] {
+			return ErrInvalidChecksum
+		}
+	case 13:
+		if !digits(n) || !(strings.HasPrefix(n, "978") || strings.HasPrefix(n, "979")) {
+			return ErrInvalidFormat
+		}
+		if checkDigit13(n[:12]) != n[12] {
+			return ErrInvalidChecksum
+		}
+	default:
+		return ErrInvalidFormat
+	}
+	return nil
+}
+
+// ToISBN13 returns the ISBN-13 form of a valid ISBN-10 or ISBN-13.
+func ToISBN13(s string) (string, error) {
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+	n := Normalize(s)
+	if len(n) == 13 {
+		return n, nil
+	}
+	body := "978" + n[:9]
+	return body + string(checkDigit13(body)), nil
+}
+
+// ToISBN10 returns the ISBN-10 form of a valid ISBN, which only exists for
+// the 978 prefix.
+func ToISBN10(s string) (string, error) {
+	if err := Validate(s); err != nil {
+		return "", err
+	}
+	n := Normalize(s)
+	if len(n) == 10 {
+		return n, nil
+	}
+	if !strings.HasPrefix(n, "978") {
+		return "", ErrNoISBN10
+	}
+	body := n[3:12]
+	return body + string(checkDigit10(body)), nil
+}
+
+// Canonical is the hyphen-free ISBN-13 of a valid ISBN. Two ISBNs name the
+// same book exactly when their canonical forms are equal.
+func Canonical(s string) (string, error) {
+	return ToISBN13(s)
+}
+
+// checkDigit10 weights the nine digits 10 down to 2; the check digit brings
+// the sum to a multiple of 11, with X standing for 10.
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// checkDigit13 weights the twelve digits alternately 1 and 3; the check digit
+// brings the sum to a multiple of 10.
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(body[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
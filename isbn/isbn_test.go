@@ -0,0 +1,77 @@
+package isbn
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		isbn string
+		want error
+	}{
+		{name: "isbn-10", isbn: "0306406152"},
+		{name: "isbn-10 with hyphens", isbn: "0-306-40615-2"},
+		{name: "isbn-10 with an X check digit", isbn: "0-8044-2957-X"},
+		{name: "isbn-10 with a lower-case x", isbn: "080442957x"},
+		{name: "isbn-13 with the 978 prefix", isbn: "978-0-306-40615-7"},
+		{name: "isbn-13 with the 979 prefix", isbn: "979-10-90636-07-1"},
+		{name: "isbn-13 with spaces", isbn: "978 0 8044 2957 3"},
+		{name: "isbn-10 with a bad checksum", isbn: "0306406153", want: ErrInvalidChecksum},
+		{name: "isbn-13 with a bad checksum", isbn: "9780306406158", want: ErrInvalidChecksum},
+		{name: "too short", isbn: "030640615", want: ErrInvalidFormat},
+		{name: "too long", isbn: "97803064061570", want: ErrInvalidFormat},
+		{name: "empty", isbn: "", want: ErrInvalidFormat},
+		{name: "X outside the check digit", isbn: "03064X6152", want: ErrInvalidFormat},
+		{name: "isbn-13 with an unknown prefix", isbn: "9770306406157", want: ErrInvalidFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Validate(test.isbn); err != test.want {
+				t.Errorf("Validate(%q) = %v, want %v", test.isbn, err, test.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name    string
+		isbn    string
+		isbn13  string
+		isbn10  string
+		err10   error
+		invalid error
+	}{
+		{name: "isbn-10", isbn: "0-306-40615-2", isbn13: "9780306406157", isbn10: "0306406152"},
+		{name: "isbn-10 with an X check digit", isbn: "080442957X", isbn13: "9780804429573", isbn10: "080442957X"},
+		{name: "978 isbn-13", isbn: "978-0-8044-2957-3", isbn13: "9780804429573", isbn10: "080442957X"},
+		{name: "979 isbn-13", isbn: "9791090636071", isbn13: "9791090636071", err10: ErrNoISBN10},
+		{name: "bad checksum", isbn: "0306406153", invalid: ErrInvalidChecksum},
+		{name: "bad length", isbn: "978030640615", invalid: ErrInvalidFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want10 := test.err10
+			if test.invalid != nil {
+				want10 = test.invalid
+			}
+			isbn13, err := ToISBN13(test.isbn)
+			if err != test.invalid || isbn13 != test.isbn13 {
+				t.Errorf("ToISBN13(%q) = %q, %v, want %q, %v", test.isbn, isbn13, err, test.isbn13, test.invalid)
+			}
+			canonical, err := Canonical(test.isbn)
+			if err != test.invalid || canonical != test.isbn13 {
+				t.Errorf("Canonical(%q) = %q, %v, want %q, %v", test.isbn, canonical, err, test.isbn13, test.invalid)
+			}
+			isbn10, err := ToISBN10(test.isbn)
+			if err != want10 || isbn10 != test.isbn10 {
+				t.Errorf("ToISBN10(%q) = %q, %v, want %q, %v", test.isbn, isbn10, err, test.isbn10, want10)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize(" 0-8044 2957-x "); got != "080442957X" {
+		t.Errorf("Normalize = %q, want %q", got, "080442957X")
+	}
+}
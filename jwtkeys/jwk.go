@@ -0,0 +1,182 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+)
+
+// JWK is a public key in the JSON Web Key format. Only the members of RSA and
+// EC keys are modelled.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet is a fixed set of public keys by kid.
+type KeySet map[string]crypto.PublicKey
+
+func (keys KeySet) Key(kid string) (crypto.PublicKey, error) {
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (keys KeySet) Add(public crypto.PublicKey) error {
+	kid, err := KeyID(public)
+	if err != nil {
+		return err
+	}
+	keys[kid] = public
+	return nil
+}
+
+// JWKS lists the keys ordered by kid so the published document is stable.
+func (keys KeySet) JWKS() *JWKS {
+	set := &JWKS{Keys: []JWK{}}
+	for kid, public := range keys {
+		key := JWK{Kid: kid, Use: "sig"}
+		switch public := public.(type) {
+		case *rsa.PublicKey:
+			key.Kty, key.Alg = "RSA", "RS256"
+			key.N, key.E = encode(public.N.Bytes()), rsaExponent(public)
+		case *ecdsa.PublicKey:
+			key.Kty, key.Alg, key.Crv = "EC", "ES256", "P-256"
+			key.X, key.Y = ecCoordinates(public)
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+// ParseJWKS reads a JWK Set. Keys of other types or meant for encryption are
+// skipped, as RFC 7517 asks of keys a reader does not understand.
+func ParseJWKS(data []byte) (KeySet, error) {
+	set := &JWKS{}
+	err := json.Unmarshal(data, set)
+	if err != nil {
+		return nil, err
+	}
+	keys := KeySet{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		public, err := key.PublicKey()
+		if err == ErrUnsupportedKey {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key.Kid, err)
+		}
+		kid := key.Kid
+		if kid == "" {
+			kid, err = KeyID(public)
+			if err != nil {
+				continue
+			}
+		}
+		keys[kid] = public
+	}
+	return keys, nil
+}
+
+func LoadJWKS(path string) (KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return keys, nil
+}
+
+func (key JWK) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case key.Kty == "RSA":
+		n, err := decodeInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent is out of range")
+		}
+		public := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if n.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return public, nil
+	case key.Kty == "EC" && key.Crv == "P-256":
+		x, err := decodeInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func rsaExponent(public *rsa.PublicKey) string {
+	return encode(big.NewInt(int64(public.E)).Bytes())
+}
+
+// ecCoordinates encodes the point padded to the curve size, as JWK requires.
+func ecCoordinates(public *ecdsa.PublicKey) (string, string) {
+	size := (public.Curve.Params().BitSize + 7) / 8
+	return encode(pad(public.X.Bytes(), size)), encode(pad(public.Y.Bytes(), size))
+}
+
+func pad(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
@@ -0,0 +1,229 @@
+// Package jwtkeys loads the asymmetric keys access tokens are signed with and
+// publishes and resolves them as a JSON Web Key Set (RFC 7517), so the
+// services verify tokens without sharing a secret. A key is named by its
+// RFC 7638 thumbprint, which every token carries in its kid header.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/library/envConfig"
+)
+
+const minRSABits = 2048
+
+var (
+	ErrUnknownKey     = errors.New("no key with that kid")
+	ErrUnsupportedKey = errors.New("only RSA and P-256 ECDSA keys are supported")
+)
+
+// SigningKey signs tokens with RS256 or ES256, depending on the key.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+	// *rsa.PrivateKey or *ecdsa.PrivateKey, as jwt-go expects
+	private interface{}
+}
+
+func (key *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Resolver finds the public key a token was signed with by its kid.
+type Resolver interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// FromConfig loads the key user-svc signs with and the set of keys it
+// publishes: the signing key and every extra public key. Both are empty when
+// no private key file is configured.
+func FromConfig(config envConfig.AuthConfig) (*SigningKey, KeySet, error) {
+	keys := KeySet{}
+	var signingKey *SigningKey
+	if config.JwtPrivateKeyFile != "" {
+		var err error
+		signingKey, err = LoadSigningKey(config.JwtPrivateKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[signingKey.ID] = signingKey.Public
+	}
+	for _, path := range config.JwtPublicKeyFiles {
+		public, err := LoadPublicKey(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = keys.Add(public)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return signingKey, keys, nil
+}
+
+// NewResolver picks where a service looks up verification keys: the JWKS
+// URL, backed by the JWKS file when the URL cannot be reached; the JWKS file
+// alone; or the local key files. It returns nil when none is configured and
+// tokens are still signed with the shared secret.
+func NewResolver(config envConfig.AuthConfig) (Resolver, error) {
+	fallback := KeySet{}
+	if config.JwksFile != "" {
+		var err error
+		fallback, err = LoadJWKS(config.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.JwksURL != "" {
+		return NewRemoteKeySet(config.JwksURL, config.JwksCacheTTL, fallback), nil
+	}
+	if config.JwksFile != "" {
+		return fallback, nil
+	}
+	_, keys, err := FromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+// LoadSigningKey reads a PEM private key in PKCS#1, SEC 1 or PKCS#8 form.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	key := &SigningKey{private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, &private.PublicKey
+	case *ecdsa.PrivateKey:
+		key.Method, key.Public = jwt.SigningMethodES256, &private.PublicKey
+	}
+	key.ID, err = KeyID(key.Public)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// LoadPublicKey reads a PEM public key, a certificate or a private key, of
+// which only the public half is kept.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var public crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			public = cert.PublicKey
+		}
+	default:
+		var key *SigningKey
+		key, err = LoadSigningKey(path)
+		if err == nil {
+			public = key.Public
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	_, err = KeyID(public)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// KeyID is the RFC 7638 thumbprint of a public key. It also rejects the keys
+// tokens cannot be signed with.
+func KeyID(public crypto.PublicKey) (string, error) {
+	var members interface{}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSABits {
+			return "", fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		// the thumbprint needs the members in lexical order, which a struct keeps
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{rsaExponent(public), "RSA", encode(public.N.Bytes())}
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return "", ErrUnsupportedKey
+		}
+		x, y := ecCoordinates(public)
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{"P-256", "EC", x, y}
+	default:
+		return "", ErrUnsupportedKey
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encode(sum[:]), nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
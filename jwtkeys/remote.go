@@ -0,0 +1,89 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// minRefetchInterval bounds how often tokens with an unknown kid, which
+	// anyone can make up, can send a request to the key server.
+	minRefetchInterval = 30 * time.Second
+	maxJWKSBytes       = 1 << 20
+)
+
+// RemoteKeySet resolves keys from a JWKS URL and caches the set for a TTL.
+// A kid that is not in the cache triggers an early refetch, so a key added
+// during rotation is picked up before the TTL runs out. While the URL cannot
+// be reached the last set fetched stays in use, and keys missing from it are
+// looked up in the fallback set.
+type RemoteKeySet struct {
+	url      string
+	ttl      time.Duration
+	client   *http.Client
+	fallback KeySet
+
+	mu        sync.Mutex
+	keys      KeySet
+	fetchedAt time.Time
+	triedAt   time.Time
+	fetchErr  error
+}
+
+func NewRemoteKeySet(url string, ttl time.Duration, fallback KeySet) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:      url,
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		fallback: fallback,
+		keys:     KeySet{},
+	}
+}
+
+func (remote *RemoteKeySet) Key(kid string) (crypto.PublicKey, error) {
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	now := time.Now()
+	key, ok := remote.keys[kid]
+	expired := now.Sub(remote.fetchedAt) >= remote.ttl
+	if (!ok || expired) && now.Sub(remote.triedAt) >= minRefetchInterval {
+		remote.triedAt = now
+		keys, err := remote.fetch()
+		remote.fetchErr = err
+		if err == nil {
+			remote.keys, remote.fetchedAt = keys, now
+		}
+		key, ok = remote.keys[kid]
+	}
+	if !ok {
+		key, ok = remote.fallback[kid]
+	}
+	if !ok {
+		if remote.fetchErr != nil {
+			return nil, fmt.Errorf("%v, fetching %s failed: %v", ErrUnknownKey, remote.url, remote.fetchErr)
+		}
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (remote *RemoteKeySet) fetch() (KeySet, error) {
+	resp, err := remote.client.Get(remote.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
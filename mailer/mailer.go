@@ -0,0 +1,65 @@
+// Package mailer sends the transactional emails of the user service, such as
+// address verification and password reset links. Deployments use SMTP; the
+// file and log sinks keep messages local for development and tests. New picks
+// one from the environment.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/library/envConfig"
+)
+
+const (
+	SMTPBackend = "smtp"
+	FileBackend = "file"
+	LogBackend  = "log"
+)
+
+var ErrInvalidHeader = errors.New("mail headers must not contain line breaks")
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func New(config envConfig.MailConfig) (Mailer, error) {
+	switch config.MailBackend {
+	case SMTPBackend:
+		return NewSMTPMailer(config)
+	case FileBackend:
+		return NewFileMailer(config.MailFilePath, config.MailFrom)
+	case LogBackend:
+		return &LogMailer{From: config.MailFrom}, nil
+	}
+	return nil, fmt.Errorf("unknown mail backend %q, use %s, %s or %s", config.MailBackend,
+		SMTPBackend, FileBackend, LogBackend)
+}
+
+// format renders msg as an RFC 5322 message. Addresses and the subject are
+// refused if they contain line breaks, which would let a caller add headers.
+func format(from string, msg Message) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(strings.Replace(msg.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return []byte(b.String()), nil
+}
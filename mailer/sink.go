@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileMailer appends every message to a file in mbox format instead of
+// sending it, so links can be followed during development and read back by
+// tests.
+type FileMailer struct {
+	Path string
+	From string
+	mu   sync.Mutex
+}
+
+func NewFileMailer(path, from string) (*FileMailer, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{Path: path, From: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	// mbox uses plain newlines and quotes body lines that look like a separator
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\nFrom ", "\n>From ", -1)
+	_, err = file.WriteString("From " + m.From + " " + time.Now().UTC().Format(time.ANSIC) + "\n" + text + "\n\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LogMailer writes messages to the service log. The bodies hold live tokens,
+// so it is meant for local development only.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	_, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+	}).Info("mail")
+	return nil
+}
@@ -31,3 +31,83 @@ func NewMetrics(svc string) *Metrics {
 		LatencyCalculator: latencyCounter,
 	}
 }
+
+type JobMetrics struct {
+	RunCounter       *prometheus.CounterVec
+	LastRunTimestamp *prometheus.GaugeVec
+	LastRunUpdated   *prometheus.GaugeVec
+	LastRunSuccess   *prometheus.GaugeVec
+}
+
+func NewJobMetrics(svc string) *JobMetrics {
+	runCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "job_run_counter",
+			Help:      "Count of background job runs in " + svc,
+		}, []string{"job", "status"},
+	)
+
+	lastRunTimestamp := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "job_last_run_timestamp_seconds",
+			Help:      "Unix time the last background job run finished in " + svc,
+		}, []string{"job"},
+	)
+
+	lastRunUpdated := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "job_last_run_updated",
+			Help:      "Rows changed by the last background job run in " + svc,
+		}, []string{"job"},
+	)
+
+	lastRunSuccess := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "job_last_run_success",
+			Help:      "Whether the last background job run succeeded (1) or failed (0) in " + svc,
+		}, []string{"job"},
+	)
+	return &JobMetrics{
+		RunCounter:       runCounter,
+		LastRunTimestamp: lastRunTimestamp,
+		LastRunUpdated:   lastRunUpdated,
+		LastRunSuccess:   lastRunSuccess,
+	}
+}
+
+type LoginMetrics struct {
+	FailedLogins *prometheus.CounterVec
+	Lockouts     *prometheus.CounterVec
+}
+
+func NewLoginMetrics(svc string) *LoginMetrics {
+	failedLogins := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "failed_logins_total",
+			Help:      "Count of refused logins in " + svc + " by reason",
+		}, []string{"reason"},
+	)
+
+	lockouts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "library",
+			Subsystem: svc,
+			Name:      "login_lockouts_total",
+			Help:      "Count of email or client addresses locked out after failed logins in " + svc,
+		}, []string{"scope"},
+	)
+	return &LoginMetrics{
+		FailedLogins: failedLogins,
+		Lockouts:     lockouts,
+	}
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"context"
+	"crypto"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/library/models"
@@ -12,12 +14,51 @@ import (
 
 var jwtSigningKey string
 
+// RevocationList reports access tokens that were revoked before they expired,
+// by their jti claim.
+type RevocationList interface {
+	IsTokenRevoked(string) (bool, error)
+}
+
+var revocationList RevocationList
+
+// KeyResolver finds the public key an access token was signed with by the
+// kid in its header.
+type KeyResolver interface {
+	Key(string) (crypto.PublicKey, error)
+}
+
+var keyResolver KeyResolver
+
+// asymmetricMethods are the only algorithms accepted once a KeyResolver is set;
+// in particular an HMAC token can no longer be forged with the shared secret.
+var asymmetricMethods = []string{"RS256", "ES256"}
+
 const ContextAuthInfo = "auth-info"
 
+var (
+	errNoExpiry = errors.New("token has no expiry")
+	errRevoked  = errors.New("token has been revoked")
+
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
 func SetJwtSigningKey(key string) {
 	jwtSigningKey = key
 }
 
+// SetKeyResolver makes CheckAuth verify RS256 and ES256 tokens with the keys
+// of resolver instead of HMAC tokens with the shared signing key.
+func SetKeyResolver(resolver KeyResolver) {
+	keyResolver = resolver
+}
+
+// SetRevocationList makes CheckAuth reject revoked tokens. Without one only
+// the signature and expiry are checked.
+func SetRevocationList(list RevocationList) {
+	revocationList = list
+}
+
 func CheckAuth() func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +76,16 @@ func CheckAuth() func(handler http.Handler) http.Handler {
 				http.Error(w, "empty token", http.StatusUnauthorized)
 				return
 			}
-			if err := ValidateToken(acc, token, jwtSigningKey); err != nil {
+			var err error
+			if keyResolver != nil {
+				err = ValidateSignedToken(acc, token, keyResolver)
+			} else {
+				err = ValidateToken(acc, token, jwtSigningKey)
+			}
+			if err == nil {
+				err = checkRevoked(acc)
+			}
+			if err != nil {
 				logrus.WithFields(logrus.Fields{
 					"statusCode": http.StatusUnauthorized,
 					"error":      err,
@@ -49,6 +99,47 @@ func CheckAuth() func(handler http.Handler) http.Handler {
 	}
 }
 
+// ActingUser is the account a request acts for. A request that names no user
+// (userID 0) acts for the caller; naming another user takes the users:act
+// permission.
+func ActingUser(authInfo *models.AuthInfo, userID uint) (uint, error) {
+	if userID == 0 {
+		userID = authInfo.ID
+	}
+	if userID == 0 {
+		return 0, ErrPermissionDenied
+	}
+	if userID != authInfo.ID {
+		granted, err := HasPermission(authInfo, models.PermUsersAct)
+		if err != nil {
+			return 0, err
+		}
+		if !granted {
+			return 0, ErrPermissionDenied
+		}
+	}
+	return userID, nil
+}
+
+// checkRevoked runs after ValidateToken, which has already refused expired
+// tokens; tokens without any expiry would never be refused, so they are too.
+func checkRevoked(acc *models.AuthInfo) error {
+	if acc.ExpiresAt == 0 {
+		return errNoExpiry
+	}
+	if revocationList == nil || acc.Id == "" {
+		return nil
+	}
+	revoked, err := revocationList.IsTokenRevoked(acc.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errRevoked
+	}
+	return nil
+}
+
 func ValidateToken(claims jwt.Claims, token, jwtSigningKey string) error {
 	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -65,3 +156,17 @@ func ValidateToken(claims jwt.Claims, token, jwtSigningKey string) error {
 	}
 	return nil
 }
+
+// ValidateSignedToken verifies an RS256 or ES256 token with the key named by
+// its kid header.
+func ValidateSignedToken(claims jwt.Claims, token string, resolver KeyResolver) error {
+	parser := &jwt.Parser{ValidMethods: asymmetricMethods}
+	_, err := parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		return resolver.Key(kid)
+	})
+	return err
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/library/models"
+	"github.com/sirupsen/logrus"
+)
+
+// PermissionSource lists the permissions granted to a role.
+type PermissionSource interface {
+	GetRolePermissions(string) ([]string, error)
+}
+
+// permissionTTL is how long a role's permissions are cached; a change made
+// through the role API reaches every service within it.
+const permissionTTL = time.Minute
+
+type cachedPermissions struct {
+	granted   map[string]bool
+	fetchedAt time.Time
+}
+
+var (
+	permissionSource PermissionSource
+	permissionsMu    sync.Mutex
+	permissionCache  = map[string]cachedPermissions{}
+)
+
+func SetPermissionSource(source PermissionSource) {
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+	permissionSource = source
+	permissionCache = map[string]cachedPermissions{}
+}
+
+// HasPermission reports whether the role of the caller grants permission.
+// Without a permission source nothing is granted.
+func HasPermission(authInfo *models.AuthInfo, permission string) (bool, error) {
+	permissionsMu.Lock()
+	defer permissionsMu.Unlock()
+	if permissionSource == nil {
+		return false, nil
+	}
+	cached, ok := permissionCache[authInfo.Role]
+	if !ok || time.Since(cached.fetchedAt) >= permissionTTL {
+		permissions, err := permissionSource.GetRolePermissions(authInfo.Role)
+		if err != nil {
+			return false, err
+		}
+		cached = cachedPermissions{granted: map[string]bool{}, fetchedAt: time.Now()}
+		for _, granted := range permissions {
+			cached.granted[granted] = true
+		}
+		permissionCache[authInfo.Role] = cached
+	}
+	return cached.granted[permission], nil
+}
+
+// RequirePermission lets a request through only when the caller's role grants
+// all of permissions. It runs after CheckAuth, which puts the caller in the
+// context.
+func RequirePermission(permissions ...string) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acc, ok := r.Context().Value(ContextAuthInfo).(*models.AuthInfo)
+			if !ok {
+				http.Error(w, "permission denied", http.StatusUnauthorized)
+				return
+			}
+			for _, permission := range permissions {
+				granted, err := HasPermission(acc, permission)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"statusCode": http.StatusInternalServerError,
+						"error":      err,
+					}).Error("loading permissions")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !granted {
+					logrus.WithFields(logrus.Fields{
+						"statusCode": http.StatusUnauthorized,
+						"role":       acc.Role,
+						"permission": permission,
+					}).Error("permission denied")
+					http.Error(w, "permission denied", http.StatusUnauthorized)
+					return
+				}
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
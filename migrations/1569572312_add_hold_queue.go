@@ -0,0 +1,41 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1569572312",
+		Up: []string{
+			`
+			ALTER TABLE book_queue
+				ADD COLUMN id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST,
+				ADD COLUMN created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER id,
+				ADD COLUMN status varchar(20) NOT NULL DEFAULT 'waiting',
+				ADD COLUMN ready_date timestamp NULL DEFAULT NULL,
+				ADD COLUMN pickup_deadline timestamp NULL DEFAULT NULL;
+			`,
+			`
+			CREATE INDEX book_queue_book_status ON book_queue (book_id, status);
+			`,
+			`
+			ALTER TABLE book_queue ADD CONSTRAINT FOREIGN KEY(user_id) REFERENCES account(id) ON DELETE CASCADE;
+			`,
+			`
+			ALTER TABLE book_queue ADD CONSTRAINT FOREIGN KEY(book_id) REFERENCES book(id) ON DELETE CASCADE;
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`ALTER TABLE book_queue DROP FOREIGN KEY book_queue_ibfk_1, DROP FOREIGN KEY book_queue_ibfk_2;`,
+			`DROP INDEX book_queue_book_status ON book_queue;`,
+			`
+			ALTER TABLE book_queue
+				DROP COLUMN id,
+				DROP COLUMN created_at,
+				DROP COLUMN status,
+				DROP COLUMN ready_date,
+				DROP COLUMN pickup_deadline;
+			`,
+		},
+	})
+}
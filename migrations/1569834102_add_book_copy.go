@@ -0,0 +1,78 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1569834102",
+		Up: []string{
+			`
+			CREATE TABLE book_copy (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			  deleted_at timestamp NULL DEFAULT NULL,
+			  book_id bigint(20) NOT NULL,
+			  barcode varchar(64) NOT NULL,
+			  copy_condition varchar(20) NOT NULL DEFAULT 'good',
+			  shelf_location varchar(255) NOT NULL DEFAULT '',
+			  acquisition_date timestamp NULL DEFAULT NULL,
+			  status varchar(20) NOT NULL DEFAULT 'available',
+			  PRIMARY KEY (id),
+			  UNIQUE KEY barcode (barcode),
+			  KEY book_copy_book_status (book_id, status),
+			  FOREIGN KEY (book_id) REFERENCES book(id) ON DELETE CASCADE
+			);
+			`,
+			// every unit of stock becomes a copy numbered 1..stock. The numbers
+			// come from a table doubled until it reaches the largest stock, and
+			// the procedure fails with a message if the copies do not add up
+			`DROP PROCEDURE IF EXISTS book_copy_backfill;`,
+			`
+			CREATE PROCEDURE book_copy_backfill()
+			BEGIN
+			  DECLARE max_stock bigint DEFAULT 0;
+			  DECLARE filled bigint DEFAULT 1;
+			  DECLARE copies bigint DEFAULT 0;
+			  DECLARE total_stock bigint DEFAULT 0;
+			  DECLARE message varchar(128);
+			  DROP TABLE IF EXISTS book_copy_seq;
+			  CREATE TABLE book_copy_seq (n bigint NOT NULL PRIMARY KEY);
+			  INSERT INTO book_copy_seq (n) VALUES (1);
+			  SELECT COALESCE(MAX(stock), 0), COALESCE(SUM(stock), 0) INTO max_stock, total_stock FROM book;
+			  WHILE filled < max_stock DO
+			    INSERT INTO book_copy_seq (n)
+			    SELECT n + filled FROM book_copy_seq WHERE n + filled <= max_stock;
+			    SET filled = filled * 2;
+			  END WHILE;
+			  INSERT INTO book_copy (book_id, barcode, acquisition_date, status)
+			  SELECT book.id, CONCAT('LIB-', book.id, '-', seq.n), book.created_at, 'available'
+			  FROM book INNER JOIN book_copy_seq seq ON seq.n <= book.stock;
+			  DROP TABLE book_copy_seq;
+			  SELECT COUNT(*) INTO copies FROM book_copy;
+			  IF copies <> total_stock THEN
+			    SET message = CONCAT('book_copy backfill created ', copies, ' copies for a total stock of ', total_stock);
+			    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = message;
+			  END IF;
+			END;
+			`,
+			`CALL book_copy_backfill();`,
+			`DROP PROCEDURE book_copy_backfill;`,
+			`
+			ALTER TABLE book_history
+				ADD COLUMN id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST,
+				ADD COLUMN copy_id bigint(20) NOT NULL DEFAULT 0;
+			`,
+			`
+			ALTER TABLE book_queue
+				ADD COLUMN copy_id bigint(20) NOT NULL DEFAULT 0;
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`ALTER TABLE book_queue DROP COLUMN copy_id;`,
+			`ALTER TABLE book_history DROP COLUMN copy_id, DROP COLUMN id;`,
+			`DROP TABLE book_copy;`,
+		},
+	})
+}
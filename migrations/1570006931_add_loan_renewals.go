@@ -0,0 +1,35 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570006931",
+		Up: []string{
+			`
+			ALTER TABLE book_history
+				ADD COLUMN renewals int(20) NOT NULL DEFAULT 0;
+			`,
+			`
+			CREATE TABLE book_history_event (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  history_id bigint(20) NOT NULL,
+			  book_id bigint(20) NOT NULL,
+			  user_id bigint(20) NOT NULL,
+			  event varchar(20) NOT NULL,
+			  previous_return_date timestamp NULL DEFAULT NULL,
+			  return_date timestamp NULL DEFAULT NULL,
+			  PRIMARY KEY (id),
+			  KEY book_history_event_book (book_id),
+			  FOREIGN KEY (history_id) REFERENCES book_history(id) ON DELETE CASCADE
+			);
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`DROP TABLE book_history_event;`,
+			`ALTER TABLE book_history DROP COLUMN renewals;`,
+		},
+	})
+}
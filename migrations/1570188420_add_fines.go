@@ -0,0 +1,29 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570188420",
+		Up: []string{
+			`
+			CREATE TABLE fine (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  user_id bigint(20) NOT NULL,
+			  history_id bigint(20) NOT NULL DEFAULT 0,
+			  kind varchar(20) NOT NULL,
+			  amount bigint(20) NOT NULL,
+			  note varchar(255) NOT NULL DEFAULT '',
+			  PRIMARY KEY (id),
+			  KEY fine_user (user_id),
+			  FOREIGN KEY (user_id) REFERENCES account(id) ON DELETE CASCADE
+			);
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`DROP TABLE fine;`,
+		},
+	})
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570362715",
+		Up: []string{
+			`
+			CREATE TABLE job_run (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  job varchar(64) NOT NULL,
+			  host varchar(255) NOT NULL DEFAULT '',
+			  status varchar(20) NOT NULL,
+			  updated int(11) NOT NULL DEFAULT 0,
+			  error text,
+			  started_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  finished_at timestamp NULL DEFAULT NULL,
+			  PRIMARY KEY (id),
+			  KEY job_run_job (job, id)
+			);
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`DROP TABLE job_run;`,
+		},
+	})
+}
@@ -0,0 +1,19 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570449308",
+		Up: []string{
+			`
+			UPDATE account SET overdue_books = (
+			  SELECT count(*) FROM book_history
+			  WHERE book_history.user_id = account.id AND book_history.status = 'overdue'
+			);
+			`,
+		},
+		//language=SQL
+		Down: []string{},
+	})
+}
@@ -0,0 +1,19 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570535617",
+		Up: []string{
+			`
+			ALTER TABLE book
+				ADD FULLTEXT INDEX book_search (name, author, abstract, category);
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`ALTER TABLE book DROP INDEX book_search;`,
+		},
+	})
+}
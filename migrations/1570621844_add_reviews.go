@@ -0,0 +1,43 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570621844",
+		Up: []string{
+			`
+			CREATE TABLE review (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			  book_id bigint(20) NOT NULL,
+			  user_id bigint(20) NOT NULL,
+			  rating int(20) NOT NULL,
+			  review text NOT NULL,
+			  hidden tinyint(1) NOT NULL DEFAULT 0,
+			  PRIMARY KEY (id),
+			  UNIQUE KEY review_book_user (book_id, user_id),
+			  FOREIGN KEY (book_id) REFERENCES book(id) ON DELETE CASCADE,
+			  FOREIGN KEY (user_id) REFERENCES account(id) ON DELETE CASCADE
+			);
+			`,
+			`
+			ALTER TABLE book
+				MODIFY COLUMN rating decimal(3,2) NOT NULL DEFAULT 0,
+				ADD COLUMN rating_count int(20) NOT NULL DEFAULT 0;
+			`,
+			// the old admin-set ratings are not backed by any review
+			`UPDATE book SET rating = 0;`,
+		},
+		//language=SQL
+		Down: []string{
+			`DROP TABLE review;`,
+			`
+			ALTER TABLE book
+				DROP COLUMN rating_count,
+				MODIFY COLUMN rating int(20) NOT NULL DEFAULT 5;
+			`,
+		},
+	})
+}
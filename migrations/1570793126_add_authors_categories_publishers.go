@@ -0,0 +1,133 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570793126",
+		Up: []string{
+			`
+			CREATE TABLE author (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			  name varchar(255) NOT NULL,
+			  PRIMARY KEY (id),
+			  UNIQUE KEY author_name (name)
+			);
+			`,
+			`
+			CREATE TABLE category (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			  name varchar(255) NOT NULL,
+			  parent_id bigint(20) NULL DEFAULT NULL,
+			  PRIMARY KEY (id),
+			  KEY category_parent (parent_id),
+			  FOREIGN KEY (parent_id) REFERENCES category(id)
+			);
+			`,
+			`
+			CREATE TABLE publisher (
+			  id bigint(20) NOT NULL AUTO_INCREMENT,
+			  created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			  updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			  name varchar(255) NOT NULL,
+			  PRIMARY KEY (id),
+			  UNIQUE KEY publisher_name (name)
+			);
+			`,
+			`
+			CREATE TABLE book_author (
+			  book_id bigint(20) NOT NULL,
+			  author_id bigint(20) NOT NULL,
+			  position int(20) NOT NULL DEFAULT 0,
+			  PRIMARY KEY (book_id, author_id),
+			  KEY book_author_author (author_id),
+			  FOREIGN KEY (book_id) REFERENCES book(id) ON DELETE CASCADE,
+			  FOREIGN KEY (author_id) REFERENCES author(id) ON DELETE CASCADE
+			);
+			`,
+			`
+			CREATE TABLE book_category (
+			  book_id bigint(20) NOT NULL,
+			  category_id bigint(20) NOT NULL,
+			  PRIMARY KEY (book_id, category_id),
+			  KEY book_category_category (category_id),
+			  FOREIGN KEY (book_id) REFERENCES book(id) ON DELETE CASCADE,
+			  FOREIGN KEY (category_id) REFERENCES category(id) ON DELETE CASCADE
+			);
+			`,
+			`
+			CREATE TABLE book_publisher (
+			  book_id bigint(20) NOT NULL,
+			  publisher_id bigint(20) NOT NULL,
+			  PRIMARY KEY (book_id, publisher_id),
+			  KEY book_publisher_publisher (publisher_id),
+			  FOREIGN KEY (book_id) REFERENCES book(id) ON DELETE CASCADE,
+			  FOREIGN KEY (publisher_id) REFERENCES publisher(id) ON DELETE CASCADE
+			);
+			`,
+			// co-authors are stored comma separated in book.author. Without
+			// recursive CTEs, which need MySQL 8, part n of every list is picked
+			// by joining a table of numbers doubled up to the longest list, the
+			// way the copy backfill numbers copies
+			`DROP PROCEDURE IF EXISTS book_author_backfill;`,
+			`
+			CREATE PROCEDURE book_author_backfill()
+			BEGIN
+			  DECLARE max_parts bigint DEFAULT 0;
+			  DECLARE filled bigint DEFAULT 1;
+			  DROP TABLE IF EXISTS book_author_seq;
+			  CREATE TABLE book_author_seq (n bigint NOT NULL PRIMARY KEY);
+			  INSERT INTO book_author_seq (n) VALUES (1);
+			  SELECT COALESCE(MAX(1 + LENGTH(author) - LENGTH(REPLACE(author, ',', ''))), 0) INTO max_parts FROM book;
+			  WHILE filled < max_parts DO
+			    INSERT INTO book_author_seq (n)
+			    SELECT n + filled FROM book_author_seq WHERE n + filled <= max_parts;
+			    SET filled = filled * 2;
+			  END WHILE;
+			  CREATE TEMPORARY TABLE book_author_split
+			  SELECT book_id, position, name FROM (
+			    SELECT book.id AS book_id, seq.n - 1 AS position,
+			      TRIM(SUBSTRING_INDEX(SUBSTRING_INDEX(book.author, ',', seq.n), ',', -1)) AS name
+			    FROM book INNER JOIN book_author_seq seq
+			      ON seq.n <= 1 + LENGTH(book.author) - LENGTH(REPLACE(book.author, ',', ''))
+			  ) split WHERE name <> '';
+			  DROP TABLE book_author_seq;
+			END;
+			`,
+			`CALL book_author_backfill();`,
+			`DROP PROCEDURE book_author_backfill;`,
+			`
+			INSERT INTO author (name)
+			SELECT DISTINCT name FROM book_author_split;
+			`,
+			`
+			INSERT IGNORE INTO book_author (book_id, author_id, position)
+			SELECT book_author_split.book_id, author.id, book_author_split.position
+			FROM book_author_split INNER JOIN author ON author.name = book_author_split.name;
+			`,
+			`DROP TEMPORARY TABLE book_author_split;`,
+			`
+			INSERT INTO category (name)
+			SELECT DISTINCT TRIM(category) FROM book WHERE TRIM(category) <> '';
+			`,
+			`
+			INSERT INTO book_category (book_id, category_id)
+			SELECT book.id, category.id
+			FROM book INNER JOIN category ON category.name = TRIM(book.category);
+			`,
+		},
+		//language=SQL
+		Down: []string{
+			`DROP TABLE book_publisher;`,
+			`DROP TABLE book_category;`,
+			`DROP TABLE book_author;`,
+			`DROP TABLE publisher;`,
+			`DROP TABLE category;`,
+			`DROP TABLE author;`,
+		},
+	})
+}
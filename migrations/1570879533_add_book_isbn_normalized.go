@@ -0,0 +1,47 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570879533",
+		Up: []string{
+			`
+			ALTER TABLE book
+				ADD COLUMN isbn_normalized varchar(13) NULL DEFAULT NULL,
+				ADD UNIQUE KEY book_isbn_normalized (isbn_normalized);
+			`,
+			`
+			CREATE TEMPORARY TABLE book_isbn
+			SELECT id, UPPER(REPLACE(REPLACE(isbn, '-', ''), ' ', '')) AS clean FROM book WHERE deleted_at IS NULL;
+			`,
+			// existing rows are only normalized by shape; check digits are
+			// enforced by the application on every write from now on
+			`
+			CREATE TEMPORARY TABLE book_isbn13
+			SELECT id,
+			  CASE
+			    WHEN clean REGEXP '^97[89][0-9]{10}$' THEN clean
+			    WHEN clean REGEXP '^[0-9]{9}[0-9X]$' THEN CONCAT('978', LEFT(clean, 9), MOD(10 - MOD(38
+			      + 3 * SUBSTRING(clean, 1, 1) + SUBSTRING(clean, 2, 1) + 3 * SUBSTRING(clean, 3, 1)
+			      + SUBSTRING(clean, 4, 1) + 3 * SUBSTRING(clean, 5, 1) + SUBSTRING(clean, 6, 1)
+			      + 3 * SUBSTRING(clean, 7, 1) + SUBSTRING(clean, 8, 1) + 3 * SUBSTRING(clean, 9, 1), 10), 10))
+			  END AS normalized
+			FROM book_isbn;
+			`,
+			// when several books share an ISBN the oldest keeps it
+			`
+			UPDATE book INNER JOIN (
+			  SELECT MIN(id) AS id, normalized FROM book_isbn13 WHERE normalized IS NOT NULL GROUP BY normalized
+			) first ON first.id = book.id
+			SET book.isbn_normalized = first.normalized;
+			`,
+			`DROP TEMPORARY TABLE book_isbn13;`,
+			`DROP TEMPORARY TABLE book_isbn;`,
+		},
+		//language=SQL
+		Down: []string{
+			`ALTER TABLE book DROP INDEX book_isbn_normalized, DROP COLUMN isbn_normalized;`,
+		},
+	})
+}
@@ -0,0 +1,15 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1570966102",
+		Up: []string{
+			`ALTER TABLE book ADD COLUMN cover_key varchar(255) NOT NULL DEFAULT '';`,
+		},
+		Down: []string{
+			`ALTER TABLE book DROP COLUMN cover_key;`,
+		},
+	})
+}
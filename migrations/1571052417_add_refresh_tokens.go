@@ -0,0 +1,38 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571052417",
+		Up: []string{
+			`
+			CREATE TABLE refresh_token (
+				id int(10) unsigned NOT NULL AUTO_INCREMENT,
+				created_at timestamp NULL DEFAULT NULL,
+				user_id int(10) unsigned NOT NULL,
+				family_id varchar(32) NOT NULL,
+				token_hash char(64) NOT NULL,
+				expires_at timestamp NULL DEFAULT NULL,
+				revoked_at timestamp NULL DEFAULT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY refresh_token_hash (token_hash),
+				KEY refresh_token_family (family_id),
+				KEY refresh_token_user (user_id)
+			);
+			`,
+			`
+			CREATE TABLE revoked_token (
+				jti varchar(64) NOT NULL,
+				expires_at timestamp NULL DEFAULT NULL,
+				PRIMARY KEY (jti),
+				KEY revoked_token_expires_at (expires_at)
+			);
+			`,
+		},
+		Down: []string{
+			`DROP TABLE revoked_token;`,
+			`DROP TABLE refresh_token;`,
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571138733",
+		Up: []string{
+			`
+			CREATE TABLE role (
+			  name varchar(32) NOT NULL,
+			  description varchar(255) NOT NULL DEFAULT '',
+			  PRIMARY KEY (name)
+			);
+			`,
+			`
+			CREATE TABLE role_permission (
+			  role varchar(32) NOT NULL,
+			  permission varchar(64) NOT NULL,
+			  PRIMARY KEY (role, permission),
+			  FOREIGN KEY (role) REFERENCES role(name) ON DELETE CASCADE
+			);
+			`,
+			`
+			INSERT INTO role (name, description) VALUES
+			  ('admin', 'Full access, including user and role management'),
+			  ('librarian', 'Runs the desk: catalog upkeep, loans, holds and fines, but cannot delete books'),
+			  ('user', 'Reader; borrows, holds and reviews books for themselves');
+			`,
+			`
+			INSERT INTO role_permission (role, permission) VALUES
+			  ('admin', 'catalog:write'),
+			  ('admin', 'catalog:delete'),
+			  ('admin', 'loans:confirm'),
+			  ('admin', 'fines:manage'),
+			  ('admin', 'reviews:moderate'),
+			  ('admin', 'reports:read'),
+			  ('admin', 'users:read'),
+			  ('admin', 'users:write'),
+			  ('admin', 'users:act'),
+			  ('librarian', 'catalog:write'),
+			  ('librarian', 'loans:confirm'),
+			  ('librarian', 'fines:manage'),
+			  ('librarian', 'reviews:moderate'),
+			  ('librarian', 'reports:read'),
+			  ('librarian', 'users:read'),
+			  ('librarian', 'users:act');
+			`,
+		},
+		Down: []string{
+			`DROP TABLE role_permission;`,
+			`DROP TABLE role;`,
+		},
+	})
+}
@@ -0,0 +1,14 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571224911",
+		Up: []string{
+			`UPDATE account SET status = 'active' WHERE status IS NULL OR status = '';`,
+		},
+		//language=SQL
+		Down: []string{},
+	})
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571311345",
+		Up: []string{
+			`
+			CREATE TABLE account_token (
+				id int(10) unsigned NOT NULL AUTO_INCREMENT,
+				created_at timestamp NULL DEFAULT NULL,
+				user_id int(10) unsigned NOT NULL,
+				purpose varchar(32) NOT NULL,
+				token_hash char(64) NOT NULL,
+				expires_at timestamp NULL DEFAULT NULL,
+				used_at timestamp NULL DEFAULT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY account_token_hash (token_hash),
+				KEY account_token_user (user_id, purpose)
+			);
+			`,
+		},
+		Down: []string{
+			`DROP TABLE account_token;`,
+		},
+	})
+}
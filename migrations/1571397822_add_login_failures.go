@@ -0,0 +1,24 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571397822",
+		Up: []string{
+			`
+			CREATE TABLE login_failure (
+				scope varchar(16) NOT NULL,
+				subject varchar(255) NOT NULL,
+				failures int(10) unsigned NOT NULL DEFAULT 0,
+				last_failure_at timestamp NULL DEFAULT NULL,
+				locked_until timestamp NULL DEFAULT NULL,
+				PRIMARY KEY (scope, subject)
+			);
+			`,
+		},
+		Down: []string{
+			`DROP TABLE login_failure;`,
+		},
+	})
+}
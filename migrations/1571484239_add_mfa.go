@@ -0,0 +1,31 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571484239",
+		Up: []string{
+			`
+			ALTER TABLE account
+				ADD COLUMN mfa_secret varchar(64) NOT NULL DEFAULT '',
+				ADD COLUMN mfa_enabled tinyint(1) NOT NULL DEFAULT 0,
+				ADD COLUMN mfa_last_step bigint(20) NOT NULL DEFAULT 0;
+			`,
+			`
+			CREATE TABLE recovery_code (
+				id int(10) unsigned NOT NULL AUTO_INCREMENT,
+				user_id int(10) unsigned NOT NULL,
+				code_hash varchar(255) NOT NULL,
+				used_at timestamp NULL DEFAULT NULL,
+				PRIMARY KEY (id),
+				KEY recovery_code_user (user_id)
+			);
+			`,
+		},
+		Down: []string{
+			`DROP TABLE recovery_code;`,
+			`ALTER TABLE account DROP COLUMN mfa_secret, DROP COLUMN mfa_enabled, DROP COLUMN mfa_last_step;`,
+		},
+	})
+}
@@ -0,0 +1,19 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571570655",
+		Up: []string{
+			`
+			ALTER TABLE account
+				ADD COLUMN suspended_reason varchar(255) NOT NULL DEFAULT '',
+				ADD COLUMN suspended_until timestamp NULL DEFAULT NULL;
+			`,
+		},
+		Down: []string{
+			`ALTER TABLE account DROP COLUMN suspended_reason, DROP COLUMN suspended_until;`,
+		},
+	})
+}
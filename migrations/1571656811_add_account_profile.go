@@ -0,0 +1,21 @@
+package migrations
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func init() {
+	instance.add(&migrate.Migration{
+		Id: "1571656811",
+		Up: []string{
+			`
+			ALTER TABLE account
+				ADD COLUMN display_name varchar(100) NOT NULL DEFAULT '',
+				ADD COLUMN phone varchar(32) NOT NULL DEFAULT '',
+				ADD COLUMN member_id varchar(32) NULL DEFAULT NULL,
+				ADD UNIQUE KEY account_member_id (member_id);
+			`,
+		},
+		Down: []string{
+			`ALTER TABLE account DROP INDEX account_member_id, DROP COLUMN display_name, DROP COLUMN phone, DROP COLUMN member_id;`,
+		},
+	})
+}
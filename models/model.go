@@ -6,9 +6,81 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Roles an account can have. UserAccount is the reader role.
 const (
-	AdminAccount = "admin"
-	UserAccount  = "user"
+	AdminAccount     = "admin"
+	LibrarianAccount = "librarian"
+	UserAccount      = "user"
+)
+
+// Permissions granted to roles through the role_permission table.
+const (
+	PermCatalogWrite    = "catalog:write"
+	PermCatalogDelete   = "catalog:delete"
+	PermLoansConfirm    = "loans:confirm"
+	PermFinesManage     = "fines:manage"
+	PermReviewsModerate = "reviews:moderate"
+	PermReportsRead     = "reports:read"
+	PermUsersRead       = "users:read"
+	PermUsersWrite      = "users:write"
+	// PermUsersAct lets a caller borrow, return or review on behalf of a reader
+	PermUsersAct = "users:act"
+)
+
+var Permissions = []string{
+	PermCatalogWrite,
+	PermCatalogDelete,
+	PermLoansConfirm,
+	PermFinesManage,
+	PermReviewsModerate,
+	PermReportsRead,
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersAct,
+}
+
+// Account statuses. A self-registered account stays pending until its email
+// address is verified; accounts created by an administrator start active. A
+// suspended account cannot log in or borrow until it is reactivated or its
+// SuspendedUntil has passed.
+const (
+	AccountPending   = "pending"
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+)
+
+const (
+	CopyAvailable = "available"
+	CopyBorrowed  = "borrowed"
+	CopyHeld      = "held"
+	CopyDamaged   = "damaged"
+	CopyLost      = "lost"
+)
+
+const (
+	FineCharge  = "charge"
+	FinePayment = "payment"
+	FineWaiver  = "waiver"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Background jobs, named as in job_run and the job lock.
+const (
+	OverdueJob     = "update_book_overdue"
+	ExpireHoldsJob = "expire_holds"
+)
+
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
 )
 
 type BaseModel struct {
@@ -27,12 +99,50 @@ type Account struct {
 	PasswordHash  string `json:"-"`
 	ReservedBooks uint   `json:"reservedBooks"`
 	OverdueBooks  uint   `json:"overdueBooks"`
+	// MfaSecret is the TOTP secret, set at enrollment and in use once
+	// MfaEnabled; MfaLastStep is the time step of the last accepted code
+	MfaSecret   string `json:"-"`
+	MfaEnabled  bool   `json:"mfaEnabled"`
+	MfaLastStep int64  `json:"-"`
+	// set while Status is suspended
+	SuspendedReason string     `json:"suspendedReason,omitempty"`
+	SuspendedUntil  *time.Time `json:"suspendedUntil,omitempty"`
+	// profile details, kept up to date by the reader through /me. MemberID is
+	// the student or staff ID and is unique when set
+	DisplayName string  `json:"displayName"`
+	Phone       string  `json:"phone"`
+	MemberID    *string `json:"memberId"`
 }
 
 func (Account) TableName() string {
 	return "account"
 }
 
+type Role struct {
+	Name        string   `gorm:"primary_key" json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `gorm:"-" json:"permissions"`
+}
+
+func (Role) TableName() string {
+	return "role"
+}
+
+type RolePermission struct {
+	Role       string `gorm:"primary_key"`
+	Permission string `gorm:"primary_key"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permission"
+}
+
+type RoleAssignment struct {
+	Role string `json:"role"`
+}
+
+// Book.Author and Book.Category are display names derived from the book_author
+// and book_category links, comma separated when there is more than one.
 type Book struct {
 	BaseModel
 	Name     string `json:"name"`
@@ -44,19 +154,195 @@ type Book struct {
 	Cover    string `json:"cover"`
 	Abstract string `json:"abstract"`
 	Category string `json:"category"`
-	Rating   uint   `json:"rating"`
+	// ISBNNormalized is the canonical ISBN-13, unique across the catalog
+	ISBNNormalized *string `gorm:"column:isbn_normalized" json:"-"`
+	// CoverKey locates an uploaded cover in the object store; Cover then
+	// points at the endpoint serving it
+	CoverKey string `gorm:"column:cover_key" json:"-"`
+	// Rating is the average of the visible reviews, kept up to date with RatingCount
+	Rating      float64 `json:"rating"`
+	RatingCount uint    `json:"ratingCount"`
 }
 
 func (Book) TableName() string {
 	return "book"
 }
 
+type Author struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+}
+
+func (Author) TableName() string {
+	return "author"
+}
+
+// Category is a node in the category tree; root categories have no parent.
+type Category struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+	ParentID  *uint     `json:"parentId"`
+}
+
+func (Category) TableName() string {
+	return "category"
+}
+
+type Publisher struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+}
+
+func (Publisher) TableName() string {
+	return "publisher"
+}
+
+// BookAuthor links a book to one of its authors; Position keeps the order in
+// which co-authors are credited.
+type BookAuthor struct {
+	BookID   uint `gorm:"primary_key;auto_increment:false"`
+	AuthorID uint `gorm:"primary_key;auto_increment:false"`
+	Position int
+}
+
+func (BookAuthor) TableName() string {
+	return "book_author"
+}
+
+type BookCategory struct {
+	BookID     uint `gorm:"primary_key;auto_increment:false"`
+	CategoryID uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (BookCategory) TableName() string {
+	return "book_category"
+}
+
+type BookPublisher struct {
+	BookID      uint `gorm:"primary_key;auto_increment:false"`
+	PublisherID uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (BookPublisher) TableName() string {
+	return "book_publisher"
+}
+
+// LinkRequest lists the ids to link to a book, in order.
+type LinkRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// Review is a reader's star rating and text for a book they have returned.
+// Hidden reviews were moderated away and do not count towards the rating.
+type Review struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	BookID    uint      `json:"bookId"`
+	UserID    uint      `json:"userId"`
+	Rating    uint      `json:"rating"`
+	Text      string    `gorm:"column:review" json:"review"`
+	Hidden    bool      `json:"hidden"`
+}
+
+func (Review) TableName() string {
+	return "review"
+}
+
+// BookQuery combines the catalog filters; zero values leave a filter out.
+// Sort is a column name, prefixed with "-" for descending order, and Cursor is
+// the opaque NextCursor of the previous page.
+type BookQuery struct {
+	Author    string
+	Category  string
+	ISBN      string
+	Edition   uint
+	YearFrom  int
+	YearTo    int
+	RatingMin *float64
+	RatingMax *float64
+	Available *bool
+	Sort      string
+	Limit     int
+	Cursor    string
+}
+
+type BookPage struct {
+	Books      []Book `json:"books"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"nextCursor"`
+}
+
+// ImportRow is a decoded record of an import file; Row counts records from 1,
+// not counting a CSV header.
+type ImportRow struct {
+	Row  int
+	Book Book
+}
+
+// ImportOptions control a bulk import. DryRun validates and applies every row
+// but rolls each batch back; Upsert updates the book that already has a row's
+// ISBN instead of reporting it as a duplicate.
+type ImportOptions struct {
+	DryRun    bool
+	Upsert    bool
+	BatchSize int
+}
+
+type ImportError struct {
+	Row   int    `json:"row"`
+	ISBN  string `json:"isbn,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	Format  string        `json:"format"`
+	DryRun  bool          `json:"dryRun"`
+	Upsert  bool          `json:"upsert"`
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// SearchResult is a book matched by the catalog search with its relevance
+// score and highlighted excerpts keyed by field.
+type SearchResult struct {
+	Book
+	Score    float64           `json:"score"`
+	Snippets map[string]string `gorm:"-" json:"snippets"`
+}
+
+type BookCopy struct {
+	BaseModel
+	BookID          uint       `json:"bookId"`
+	Barcode         string     `json:"barcode"`
+	Condition       string     `gorm:"column:copy_condition" json:"condition"`
+	ShelfLocation   string     `json:"shelfLocation"`
+	AcquisitionDate *time.Time `json:"acquisitionDate"`
+	Status          string     `json:"status"`
+}
+
+func (BookCopy) TableName() string {
+	return "book_copy"
+}
+
 type BookHistory struct {
+	ID           uint       `gorm:"primary_key" json:"id"`
 	UserID       uint       `json:"userId"`
 	BookID       uint       `json:"bookId"`
+	CopyID       uint       `json:"copyId"`
 	ReservedDate *time.Time `json:"reservedDate"`
 	ReturnDate   *time.Time `json:"returnDate"`
 	Status       string     `json:"status"`
+	Renewals     uint       `json:"renewals"`
 }
 
 type BookHistoryAll struct {
@@ -69,17 +355,48 @@ func (BookHistory) TableName() string {
 	return "book_history"
 }
 
+type BookHistoryEvent struct {
+	ID                 uint       `gorm:"primary_key" json:"id"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	HistoryID          uint       `json:"historyId"`
+	BookID             uint       `json:"bookId"`
+	UserID             uint       `json:"userId"`
+	Event              string     `json:"event"`
+	PreviousReturnDate *time.Time `json:"previousReturnDate"`
+	ReturnDate         *time.Time `json:"returnDate"`
+}
+
+func (BookHistoryEvent) TableName() string {
+	return "book_history_event"
+}
+
 type BookQueue struct {
-	UserID       uint       `json:"userId"`
-	BookID       uint       `json:"bookId"`
-	ReservedDate *time.Time `json:"reservedDate"`
-	ReturnDate   *time.Time `json:"returnDate"`
+	ID             uint       `gorm:"primary_key" json:"id"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UserID         uint       `json:"userId"`
+	BookID         uint       `json:"bookId"`
+	CopyID         uint       `json:"copyId"`
+	ReservedDate   *time.Time `json:"reservedDate"`
+	ReturnDate     *time.Time `json:"returnDate"`
+	Status         string     `json:"status"`
+	ReadyDate      *time.Time `json:"readyDate"`
+	PickupDeadline *time.Time `json:"pickupDeadline"`
 }
 
 func (BookQueue) TableName() string {
 	return "book_queue"
 }
 
+type QueuePosition struct {
+	HoldID         uint       `json:"holdId"`
+	BookID         uint       `json:"bookId"`
+	UserID         uint       `json:"userId"`
+	Status         string     `json:"status"`
+	Position       int        `json:"position"`
+	QueueLength    int        `json:"queueLength"`
+	PickupDeadline *time.Time `json:"pickupDeadline"`
+}
+
 type StudentReturnBook struct {
 	UserID       uint       `json:"userId"`
 	BookID       uint       `json:"bookId"`
@@ -91,10 +408,234 @@ func (StudentReturnBook) TableName() string {
 	return "student_return_book"
 }
 
+// Fine is a ledger entry; amounts are in the smallest currency unit and
+// always positive, Kind decides whether they add to or settle the balance.
+type Fine struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint      `json:"userId"`
+	HistoryID uint      `json:"historyId"`
+	Kind      string    `json:"kind"`
+	Amount    int64     `json:"amount"`
+	Note      string    `json:"note"`
+}
+
+func (Fine) TableName() string {
+	return "fine"
+}
+
+type FineBalance struct {
+	UserID      uint   `json:"userId"`
+	Charged     int64  `json:"charged"`
+	Paid        int64  `json:"paid"`
+	Waived      int64  `json:"waived"`
+	Accruing    int64  `json:"accruing"`
+	Outstanding int64  `json:"outstanding"`
+	Entries     []Fine `json:"entries"`
+}
+
+// JobRun records one execution of a background job and how many rows it changed.
+type JobRun struct {
+	ID         uint       `gorm:"primary_key" json:"id"`
+	Job        string     `json:"job"`
+	Host       string     `json:"host"`
+	Status     string     `json:"status"`
+	Updated    int        `json:"updated"`
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+func (JobRun) TableName() string {
+	return "job_run"
+}
+
+// OverdueUpdate is the outcome of one overdue run: the run record and the
+// loans that moved from borrowed to overdue in it.
+type OverdueUpdate struct {
+	Run   *JobRun       `json:"run"`
+	Loans []BookHistory `json:"loans"`
+}
+
+// Response carries a fresh access token, valid for ExpiresIn seconds, and the
+// refresh token that trades for the next one.
 type Response struct {
+	AccountRole  string `json:"accountRole"`
+	Token        string `json:"token"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+	UserId       uint   `json:"userId"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// RefreshToken is one link of a login session. Every refresh revokes the
+// presented token and adds a new one to the same family, so a revoked token
+// that shows up again means it was stolen and the whole family is revoked.
+// Only the SHA-256 of the token is stored.
+type RefreshToken struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `json:"userId"`
+	FamilyID  string     `json:"familyId"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_token"
+}
+
+// Purposes of an AccountToken.
+const (
+	VerifyEmailToken   = "verify_email"
+	PasswordResetToken = "password_reset"
+	// MfaLoginToken stands for a correct password while the second factor
+	// is asked for; MfaEnrollToken lets an account that must use MFA enroll
+	MfaLoginToken  = "mfa_login"
+	MfaEnrollToken = "mfa_enroll"
+)
+
+// AccountToken is a single-use token mailed to the owner of an account to
+// verify its address or reset its password. Only the SHA-256 of the token is
+// stored; UsedAt is set when it is redeemed or superseded by a newer one.
+type AccountToken struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `json:"userId"`
+	Purpose   string     `json:"purpose"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+func (AccountToken) TableName() string {
+	return "account_token"
+}
+
+// RecoveryCode is a one-time replacement for a TOTP code, handed out when MFA
+// is enabled. Only its bcrypt hash is stored.
+type RecoveryCode struct {
+	ID       uint       `gorm:"primary_key" json:"id"`
+	UserID   uint       `json:"userId"`
+	CodeHash string     `json:"-"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_code"
+}
+
+// Scopes of a LoginFailure.
+const (
+	LoginScopeAccount = "account"
+	LoginScopeIP      = "ip"
+)
+
+// LoginFailure counts the recent failed logins for an email address or from a
+// client address. Email addresses are tracked whether or not they have an
+// account, so a lockout does not tell which ones do.
+type LoginFailure struct {
+	Scope         string     `gorm:"primary_key" json:"scope"`
+	Subject       string     `gorm:"primary_key" json:"subject"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil"`
+}
+
+func (LoginFailure) TableName() string {
+	return "login_failure"
+}
+
+// RevokedToken keeps the id of a logged out access token until it would have
+// expired anyway.
+type RevokedToken struct {
+	JTI       string `gorm:"primary_key;column:jti"`
+	ExpiresAt time.Time
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_token"
+}
+
+// RegistrationRequest is the body of a self-registration. The role and status
+// of the new account are never taken from the client.
+type RegistrationRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// NewAccountRequest is the body an administrator sends to create an account
+// with any role.
+type NewAccountRequest struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
 	AccountRole string `json:"accountRole"`
-	Token       string `json:"token"`
-	UserId      uint   `json:"userId"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest asks for a verification or password reset email to be sent.
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// MfaChallenge answers a login with a correct password when a second factor
+// is needed. MfaToken goes with the code to /login/mfa, or with
+// EnrollmentRequired to /login/mfa/enroll first.
+type MfaChallenge struct {
+	MfaRequired        bool   `json:"mfaRequired"`
+	EnrollmentRequired bool   `json:"enrollmentRequired,omitempty"`
+	MfaToken           string `json:"mfaToken"`
+	ExpiresIn          int64  `json:"expiresIn"`
+}
+
+// MfaCodeRequest carries a TOTP code or a recovery code. MfaToken is only
+// needed during login.
+type MfaCodeRequest struct {
+	MfaToken     string `json:"mfaToken,omitempty"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+type MfaEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MfaConfirmation holds the recovery codes of a new enrollment, shown only
+// this once, and the session tokens when it completed a login.
+type MfaConfirmation struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+	*Response
+}
+
+// SuspendRequest suspends an account, indefinitely when Until is not set.
+type SuspendRequest struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+// ProfileRequest holds the details readers may change on their own account.
+type ProfileRequest struct {
+	DisplayName string `json:"displayName"`
+	Phone       string `json:"phone"`
+	MemberID    string `json:"memberId"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
 }
 
 type LoginDetails struct {
@@ -104,6 +645,10 @@ type LoginDetails struct {
 }
 
 type AuthInfo struct {
+	// ID is the account the token was issued to
+	ID   uint `json:"id"`
 	Role string
+	// SessionID is the refresh token family the access token was issued for
+	SessionID string `json:"sid"`
 	jwt.StandardClaims
 }
@@ -0,0 +1,195 @@
+// Package search ranks catalog entries against a free-text query. It backs the
+// catalog search when the database has no FULLTEXT support and builds the
+// highlighted snippets for every dialect.
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/library/models"
+)
+
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+	// SnippetWidth is the number of characters of context kept around a match.
+	SnippetWidth = 160
+)
+
+// field weights favour a hit in the title over one buried in the abstract
+var fieldWeights = map[string]float64{
+	"name":     3,
+	"author":   2,
+	"category": 1.5,
+	"abstract": 1,
+}
+
+// Terms splits a query into distinct lower-case words.
+func Terms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, word := range words(query) {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// Fields returns the searchable text of a book keyed by column name.
+func Fields(book models.Book) map[string]string {
+	return map[string]string{
+		"name":     book.Name,
+		"author":   book.Author,
+		"category": book.Category,
+		"abstract": book.Abstract,
+	}
+}
+
+// Score is a weighted term frequency over the searchable fields, normalised by
+// field length so a short title that matches outranks a long abstract. A word
+// matches a term when it starts with it.
+func Score(book models.Book, terms []string) float64 {
+	score := 0.0
+	for field, text := range Fields(book) {
+		fieldWords := words(text)
+		if len(fieldWords) == 0 {
+			continue
+		}
+		hits := 0
+		for _, term := range terms {
+			for _, word := range fieldWords {
+				if strings.HasPrefix(word, term) {
+					hits++
+				}
+			}
+		}
+		score += fieldWeights[field] * float64(hits) / math.Sqrt(float64(len(fieldWords)))
+	}
+	return score
+}
+
+// Rank scores books against query and returns the matching ones, best first,
+// with snippets attached. A limit of zero or less returns every match.
+func Rank(books []models.Book, query string, limit int) []models.SearchResult {
+	terms := Terms(query)
+	results := []models.SearchResult{}
+	if len(terms) == 0 {
+		return results
+	}
+	for _, book := range books {
+		score := Score(book, terms)
+		if score == 0 {
+			continue
+		}
+		results = append(results, models.SearchResult{
+			Book:     book,
+			Score:    score,
+			Snippets: Snippets(book, terms),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Snippets returns a highlighted excerpt for every field of the book that
+// contains one of the terms.
+func Snippets(book models.Book, terms []string) map[string]string {
+	snippets := map[string]string{}
+	for field, text := range Fields(book) {
+		if snippet, ok := Snippet(text, terms, SnippetWidth); ok {
+			snippets[field] = snippet
+		}
+	}
+	return snippets
+}
+
+// Snippet cuts a window of width characters around the first match in text and
+// wraps every match inside it in <mark> tags. The rest of the text is HTML
+// escaped so the snippet can be rendered as is.
+func Snippet(text string, terms []string, width int) (string, bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	first, _ := nextMatch(lower, terms, 0)
+	if first < 0 {
+		return "", false
+	}
+	start := 0
+	if len(runes) > width {
+		start = first - width/4
+		if start < 0 {
+			start = 0
+		}
+		if start+width > len(runes) {
+			start = len(runes) - width
+		}
+	}
+	end := len(runes)
+	if start+width < end {
+		end = start + width
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		at, length := nextMatch(lower[:end], terms, i)
+		if at < 0 {
+			b.WriteString(html.EscapeString(string(runes[i:end])))
+			break
+		}
+		b.WriteString(html.EscapeString(string(runes[i:at])))
+		b.WriteString(markOpen)
+		b.WriteString(html.EscapeString(string(runes[at : at+length])))
+		b.WriteString(markClose)
+		i = at + length
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// nextMatch finds the earliest term that starts a word at or after from and
+// returns its position and length, or -1 when there is none.
+func nextMatch(text []rune, terms []string, from int) (int, int) {
+	for i := from; i < len(text); i++ {
+		if i > 0 && isWordRune(text[i-1]) {
+			continue
+		}
+		for _, term := range terms {
+			t := []rune(term)
+			if i+len(t) <= len(text) && string(text[i:i+len(t)]) == term {
+				return i, len(t)
+			}
+		}
+	}
+	return -1, 0
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,145 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/library/models"
+)
+
+func book(id uint, name, author, category, abstract string) models.Book {
+	return models.Book{
+		BaseModel: models.BaseModel{ID: id},
+		Name:      name,
+		Author:    author,
+		Category:  category,
+		Abstract:  abstract,
+	}
+}
+
+var catalog = []models.Book{
+	book(1, "Gardening for Beginners", "Ann Green", "Hobbies", "Grow vegetables and herbs at home."),
+	book(2, "A History of Rome", "Mary Beard", "History", "Rome from its founding to the empire, with notes on gardening in the villas."),
+	book(3, "Rome", "John Smith", "Travel", "A guide to the city."),
+	book(4, "Cooking", "Rome Gardener", "Food", "Recipes from an Italian kitchen."),
+	book(5, "Databases", "Edgar Codd", "Computing", "Relational algebra and the theory behind SQL."),
+}
+
+func ids(results []models.SearchResult) []uint {
+	found := []uint{}
+	for _, result := range results {
+		found = append(found, result.ID)
+	}
+	return found
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []uint
+	}{
+		{
+			name:  "a short title outranks a longer one",
+			query: "rome",
+			want:  []uint{3, 2, 4},
+		},
+		{
+			name:  "a title match outranks an author and an abstract match",
+			query: "garden",
+			want:  []uint{1, 4, 2},
+		},
+		{
+			name:  "every term adds to the score",
+			query: "rome history",
+			want:  []uint{2, 3, 4},
+		},
+		{
+			name:  "words match by prefix and case is ignored",
+			query: "RELAT",
+			want:  []uint{5},
+		},
+		{
+			name:  "limit keeps the best results",
+			query: "rome",
+			limit: 2,
+			want:  []uint{3, 2},
+		},
+		{
+			name:  "a limit of zero returns every match",
+			query: "garden",
+			limit: 0,
+			want:  []uint{1, 4, 2},
+		},
+		{
+			name:  "no match",
+			query: "astronomy",
+			want:  []uint{},
+		},
+		{
+			name:  "empty query",
+			query: " ,. ",
+			want:  []uint{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ids(Rank(catalog, test.query, test.limit))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Rank(%q, %d) = %v, want %v", test.query, test.limit, got, test.want)
+			}
+		})
+	}
+}
+
+func TestScoreFieldWeights(t *testing.T) {
+	terms := Terms("mars")
+	byField := map[string]models.Book{
+		"name":     book(1, "Mars", "", "", ""),
+		"author":   book(2, "", "Mars", "", ""),
+		"category": book(3, "", "", "Mars", ""),
+		"abstract": book(4, "", "", "", "Mars"),
+	}
+	order := []string{"name", "author", "category", "abstract"}
+	for i := 1; i < len(order); i++ {
+		higher := Score(byField[order[i-1]], terms)
+		lower := Score(byField[order[i]], terms)
+		if higher <= lower {
+			t.Errorf("a match in %s scored %v, not above %v for %s", order[i-1], higher, lower, order[i])
+		}
+	}
+}
+
+func TestRankTiesByID(t *testing.T) {
+	books := []models.Book{
+		book(9, "Mars", "", "", ""),
+		book(7, "Mars", "", "", ""),
+		book(8, "Mars", "", "", ""),
+	}
+	got := ids(Rank(books, "mars", 0))
+	want := []uint{7, 8, 9}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rank of equal scores = %v, want %v", got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	snippet, ok := Snippet("Tom & Jerry go to <Rome>", Terms("rome"), SnippetWidth)
+	if !ok {
+		t.Fatal("Snippet found no match")
+	}
+	want := "Tom &amp; Jerry go to &lt;<mark>Rome</mark>&gt;"
+	if snippet != want {
+		t.Errorf("Snippet = %q, want %q", snippet, want)
+	}
+	if _, ok := Snippet("Jerome", Terms("rome"), SnippetWidth); ok {
+		t.Error("Snippet matched inside a word")
+	}
+	long := strings.Repeat("filler ", 50) + "rome" + strings.Repeat(" filler", 50)
+	snippet, _ = Snippet(long, Terms("rome"), 40)
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || !strings.Contains(snippet, "<mark>rome</mark>") {
+		t.Errorf("Snippet of a long text = %q", snippet)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps every object as a file under Root. The filesystem has no
+// room for metadata, so the content type is taken from the key's extension.
+type FileStore struct {
+	Root string
+}
+
+func NewFileStore(root string) (*FileStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{Root: root}, nil
+}
+
+func (fs *FileStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	name, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	// write next to the target and rename so readers never see half a file
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (fs *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	name, err := fs.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return file, &ObjectInfo{
+		ContentType:  contentType,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (fs *FileStore) Delete(ctx context.Context, key string) error {
+	name, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path maps a key to a file under Root and refuses keys that would escape it.
+func (fs *FileStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || strings.HasSuffix(key, "/") || clean != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(fs.Root, filepath.FromSlash(clean)), nil
+}
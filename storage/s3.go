@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/library/envConfig"
+)
+
+// S3Store keeps objects in a bucket of S3 or of a compatible service such as
+// MinIO, which is reached through S3Endpoint.
+type S3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+func NewS3Store(config envConfig.StorageConfig) (*S3Store, error) {
+	if config.S3Bucket == "" {
+		return nil, errors.New("s3 storage needs a bucket")
+	}
+	awsConfig := &aws.Config{
+		Region:           aws.String(config.S3Region),
+		S3ForcePathStyle: aws.Bool(config.S3ForcePathStyle),
+	}
+	if config.S3Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.S3Endpoint)
+	}
+	// without static keys the SDK falls back to its usual credential chain
+	if config.S3AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.S3AccessKeyID, config.S3SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+		bucket:   config.S3Bucket,
+	}, nil
+}
+
+func (store *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := store.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:               aws.String(store.bucket),
+		Key:                  aws.String(key),
+		Body:                 body,
+		ContentType:          aws.String(contentType),
+		ACL:                  aws.String(s3.ObjectCannedACLPrivate),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	return err
+}
+
+func (store *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	out, err := store.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Body, &ObjectInfo{
+		ContentType:  aws.StringValue(out.ContentType),
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := store.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,53 @@
+// Package storage keeps binary objects such as book covers outside the
+// database. ObjectStore has an S3-compatible implementation for deployments
+// and a local filesystem one for development and single node installs; New
+// picks one from the environment.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/library/envConfig"
+)
+
+const (
+	FilesystemBackend = "filesystem"
+	S3Backend         = "s3"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("object key is not valid")
+)
+
+// ObjectStore saves, streams and removes objects by key. Keys are slash
+// separated paths such as "covers/12/3f9a.png".
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get returns ErrNotFound for a key that was never stored or has been
+	// deleted. The caller closes the body.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete does not fail for a key that does not exist.
+	Delete(ctx context.Context, key string) error
+}
+
+type ObjectInfo struct {
+	ContentType  string
+	Size         int64
+	LastModified time.Time
+}
+
+func New(config envConfig.StorageConfig) (ObjectStore, error) {
+	switch config.StorageBackend {
+	case FilesystemBackend:
+		return NewFileStore(config.StoragePath)
+	case S3Backend:
+		return NewS3Store(config)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q, use %s or %s", config.StorageBackend,
+		FilesystemBackend, S3Backend)
+}
@@ -0,0 +1,180 @@
+// Package thumbnail scales cover images down to the fixed sizes the catalog
+// clients display, using only the standard library codecs. JPEG covers give
+// JPEG thumbnails; PNG and GIF covers give PNG ones so transparency survives.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels bounds the decoded size of a cover. A small file can declare huge
+// dimensions, so the header is checked before any pixel is decoded.
+const MaxPixels = 4096 * 4096
+
+const jpegQuality = 85
+
+var (
+	ErrUnsupported = errors.New("only jpeg, png and gif images can be resized")
+	ErrTooLarge    = errors.New("image has more than 4096x4096 pixels")
+)
+
+// Size is a variant bounded by MaxDimension on its longer side.
+type Size struct {
+	Name         string
+	MaxDimension int
+}
+
+var Sizes = []Size{
+	{Name: "small", MaxDimension: 160},
+	{Name: "medium", MaxDimension: 320},
+	{Name: "large", MaxDimension: 640},
+}
+
+// Lookup finds a size by name.
+func Lookup(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+type Variant struct {
+	Size        string
+	ContentType string
+	Data        []byte
+}
+
+// Supported reports whether images of contentType can be resized.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Generate decodes an image and returns a variant for every size smaller than
+// the image itself; an image that already fits a size is served as is.
+func Generate(data []byte, contentType string) ([]Variant, error) {
+	if !Supported(contentType) {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	src, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	rgba := toRGBA(src)
+	var variants []Variant
+	for _, size := range Sizes {
+		bounds := rgba.Bounds()
+		if bounds.Dx() <= size.MaxDimension && bounds.Dy() <= size.MaxDimension {
+			continue
+		}
+		var buf bytes.Buffer
+		variant := Variant{Size: size.Name, ContentType: "image/png"}
+		resized := Resize(rgba, size.MaxDimension)
+		if contentType == "image/jpeg" {
+			variant.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+func decode(data []byte, contentType string) (image.Image, error) {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		return png.Decode(bytes.NewReader(data))
+	}
+	// only the first frame of an animated gif is used
+	return gif.Decode(bytes.NewReader(data))
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	return rgba
+}
+
+// Resize scales src down so its longer side is maxDimension, keeping the
+// aspect ratio. Every output pixel is the average of the block of source
+// pixels it covers, which avoids the aliasing of nearest neighbour sampling.
+// Images that already fit are returned unchanged.
+func Resize(src *image.RGBA, maxDimension int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= maxDimension && sh <= maxDimension {
+		return src
+	}
+	dw, dh := maxDimension, sh*maxDimension/sw
+	if sh > sw {
+		dw, dh = sw*maxDimension/sh, maxDimension
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0, sy1 := span(dy, dh, sh)
+		for dx := 0; dx < dw; dx++ {
+			sx0, sx1 := span(dx, dw, sw)
+			var r, g, b, a uint64
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride+sx0*4 : sy*src.Stride+sx1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+				}
+			}
+			n := uint64((sx1 - sx0) * (sy1 - sy0))
+			i := dst.PixOffset(dx, dy)
+			// round to nearest; the pixels are premultiplied so a plain mean is right
+			dst.Pix[i] = uint8((r + n/2) / n)
+			dst.Pix[i+1] = uint8((g + n/2) / n)
+			dst.Pix[i+2] = uint8((b + n/2) / n)
+			dst.Pix[i+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+// span is the range of source rows or columns covered by output index d.
+func span(d, dn, sn int) (int, int) {
+	s0, s1 := d*sn/dn, (d+1)*sn/dn
+	if s1 <= s0 {
+		s1 = s0 + 1
+	}
+	return s0, s1
+}
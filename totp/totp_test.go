@@ -0,0 +1,123 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors, the ASCII
+// string "12345678901234567890".
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists eight digit codes; six digit codes are their last six
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		now := time.Unix(test.unix, 0)
+		want := test.code[len(test.code)-Digits:]
+		code, err := Code(rfc6238Secret, Step(now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("Code at %d = %s, want %s", test.unix, code, want)
+		}
+		step, ok := Validate(rfc6238Secret, want, now, 0)
+		if !ok || step != Step(now) {
+			t.Errorf("Validate at %d = %d, %v, want %d, true", test.unix, step, ok, Step(now))
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	tests := []struct {
+		name string
+		step int64
+		skew int64
+		ok   bool
+	}{
+		{name: "current step", step: current, skew: 1, ok: true},
+		{name: "previous step within skew", step: current - 1, skew: 1, ok: true},
+		{name: "next step within skew", step: current + 1, skew: 1, ok: true},
+		{name: "previous step without skew", step: current - 1, skew: 0},
+		{name: "two steps back", step: current - 2, skew: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Code(rfc6238Secret, test.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfc6238Secret, code, now, test.skew)
+			if ok != test.ok {
+				t.Fatalf("Validate = %v, want %v", ok, test.ok)
+			}
+			// the matched step, not the current one, is what a replay is checked against
+			if ok && step != test.step {
+				t.Errorf("Validate matched step %d, want %d", step, test.step)
+			}
+		})
+	}
+}
+
+func TestValidateSameStep(t *testing.T) {
+	// every instant of a step yields the same code and step, so a code used
+	// once is recognised for the rest of its step
+	start := time.Unix(1111111110, 0)
+	code, _ := Code(rfc6238Secret, Step(start))
+	first, ok := Validate(rfc6238Secret, code, start, 1)
+	if !ok {
+		t.Fatal("Validate rejected a current code")
+	}
+	later := start.Add(Period*time.Second - time.Second)
+	again, ok := Validate(rfc6238Secret, code, later, 1)
+	if !ok || again != first {
+		t.Errorf("Validate later in the step = %d, %v, want %d, true", again, ok, first)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, test := range []struct{ name, secret, code string }{
+		{"wrong code", rfc6238Secret, "000000"},
+		{"eight digits", rfc6238Secret, "94287082"},
+		{"empty code", rfc6238Secret, ""},
+		{"bad secret", "not base32!", "287082"},
+		{"empty secret", "", "287082"},
+	} {
+		if _, ok := Validate(test.secret, test.code, now, 1); ok {
+			t.Errorf("Validate accepted %s", test.name)
+		}
+	}
+	if _, err := Code("not base32!", 1); err != ErrInvalidSecret {
+		t.Errorf("Code with a bad secret = %v, want %v", err, ErrInvalidSecret)
+	}
+}
+
+func TestSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := decode(strings.ToLower(secret)); err != nil || len(key) != SecretSize {
+		t.Errorf("GenerateSecret gave %q, decoding to %d bytes, %v", secret, len(key), err)
+	}
+	uri := URI("Library", "reader@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Library:reader@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("URI = %q", uri)
+	}
+}